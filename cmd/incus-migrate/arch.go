@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+
+	"github.com/lxc/incus/v6/shared/osarch"
+)
+
+// archScanSize is how much of a disk image gets scanned for executables.
+const archScanSize = 512 * 1024 * 1024
+
+// archScanMinHits is the number of executables needed before trusting a detected architecture.
+const archScanMinHits = 3
+
+// gptRootTypes maps the architecture specific root partition types from the
+// Discoverable Partitions Specification to architecture names.
+var gptRootTypes = map[string]string{
+	"44479540-F297-41B2-9AF7-D131D5F0458A": "i686",
+	"4F68BCE3-E8CD-4DB1-96E7-FBCAF984B709": "x86_64",
+	"69DAD710-2CE4-4E3C-B16C-21A1D49ABED3": "armv7l",
+	"B921B045-1DF0-41C3-AF44-4C6F280D3FAE": "aarch64",
+	"72EC70A6-CF74-40E6-BD49-4BDA08E8F224": "riscv64",
+}
+
+// elfMachines maps ELF machine types to architecture names.
+var elfMachines = map[uint16]string{
+	3:   "i686",
+	40:  "armv7l",
+	62:  "x86_64",
+	183: "aarch64",
+	243: "riscv64",
+	258: "loongarch64",
+	22:  "s390x",
+}
+
+// peMachines maps PE machine types (EFI binaries and Windows executables) to architecture names.
+var peMachines = map[uint16]string{
+	0x014c: "i686",
+	0x01c2: "armv7l",
+	0x01c4: "armv7l",
+	0x8664: "x86_64",
+	0xaa64: "aarch64",
+	0x5064: "riscv64",
+	0x6264: "loongarch64",
+}
+
+// compatibleArchitectures lists the guest architectures an instance architecture can run.
+var compatibleArchitectures = map[string][]string{
+	"x86_64":  {"i686"},
+	"aarch64": {"armv7l"},
+}
+
+// architecturesCompatible returns whether a guest of the given architecture runs on the other one.
+func architecturesCompatible(guest string, host string) bool {
+	if guest == host {
+		return true
+	}
+
+	return slices.Contains(compatibleArchitectures[host], guest)
+}
+
+// detectImageArchitecture returns the architecture of the operating system on a raw disk
+// image or block device, or an empty string when it couldn't be determined.
+// Architecture specific GPT root partition types are used when present, otherwise the
+// start of the disk is scanned for ELF and PE executables (EFI bootloaders, kernel
+// modules, binaries) and the most common architecture wins.
+func detectImageArchitecture(r io.ReaderAt, size int64) (string, error) {
+	arch, err := gptRootArchitecture(r)
+	if err != nil {
+		return "", err
+	}
+
+	if arch != "" {
+		return arch, nil
+	}
+
+	hits := map[string]int{}
+	buf := make([]byte, 1024*1024)
+
+	for offset := int64(0); offset < size && offset < archScanSize; offset += int64(len(buf)) {
+		n, err := r.ReadAt(buf, offset)
+		if err != nil && err != io.EOF {
+			return "", err
+		}
+
+		// Files start on a sector boundary.
+		for i := 0; i+512 <= n; i += 512 {
+			arch := executableArchitecture(buf[i:n])
+			if arch != "" {
+				hits[arch]++
+			}
+		}
+
+		if n < len(buf) {
+			break
+		}
+	}
+
+	var best string
+	for arch, count := range hits {
+		if count >= archScanMinHits && (best == "" || count > hits[best] || (count == hits[best] && arch < best)) {
+			best = arch
+		}
+	}
+
+	return best, nil
+}
+
+// gptRootArchitecture returns the architecture of the first architecture specific root
+// partition found in the GPT partition entries.
+func gptRootArchitecture(r io.ReaderAt) (string, error) {
+	for _, sectorSize := range []int64{512, 4096} {
+		if !isGPTHeader(r, sectorSize) {
+			continue
+		}
+
+		header := make([]byte, 92)
+
+		_, err := r.ReadAt(header, sectorSize)
+		if err != nil {
+			return "", err
+		}
+
+		entriesLBA := int64(binary.LittleEndian.Uint64(header[72:80]))
+		entriesCount := binary.LittleEndian.Uint32(header[80:84])
+		entrySize := binary.LittleEndian.Uint32(header[84:88])
+
+		// The UEFI specification requires a power of two of at least 128 bytes, larger entries
+		// than a sector are only found on corrupt or crafted headers.
+		if entrySize < 128 || entrySize > 4096 || entrySize&(entrySize-1) != 0 || entriesCount > 1024 {
+			return "", nil
+		}
+
+		entries := make([]byte, int(entriesCount)*int(entrySize))
+
+		_, err = r.ReadAt(entries, entriesLBA*sectorSize)
+		if err != nil && err != io.EOF {
+			return "", err
+		}
+
+		for i := 0; i+int(entrySize) <= len(entries); i += int(entrySize) {
+			arch, ok := gptRootTypes[formatGPTGUID(entries[i:i+16])]
+			if ok {
+				return arch, nil
+			}
+		}
+
+		return "", nil
+	}
+
+	return "", nil
+}
+
+// formatGPTGUID formats a GUID as stored on disk, the first three fields being little-endian.
+func formatGPTGUID(guid []byte) string {
+	return fmt.Sprintf("%08X-%04X-%04X-%X-%X",
+		binary.LittleEndian.Uint32(guid[0:4]),
+		binary.LittleEndian.Uint16(guid[4:6]),
+		binary.LittleEndian.Uint16(guid[6:8]),
+		guid[8:10],
+		guid[10:16])
+}
+
+// executableArchitecture returns the architecture of the ELF or PE executable starting
+// at the beginning of the buffer, if any.
+func executableArchitecture(buf []byte) string {
+	if len(buf) >= 20 && bytes.Equal(buf[0:4], []byte("\x7fELF")) {
+		var byteOrder binary.ByteOrder
+
+		switch buf[5] {
+		case 1:
+			byteOrder = binary.LittleEndian
+		case 2:
+			byteOrder = binary.BigEndian
+		default:
+			return ""
+		}
+
+		machine := byteOrder.Uint16(buf[18:20])
+
+		// PowerPC comes in both byte orders.
+		if machine == 21 {
+			if buf[5] == 1 {
+				return "ppc64le"
+			}
+
+			return "ppc64"
+		}
+
+		return elfMachines[machine]
+	}
+
+	if len(buf) >= 64 && bytes.Equal(buf[0:2], []byte("MZ")) {
+		peOffset := int(binary.LittleEndian.Uint32(buf[60:64]))
+		if peOffset < 64 || peOffset+6 > len(buf) || peOffset > 4096 {
+			return ""
+		}
+
+		if !bytes.Equal(buf[peOffset:peOffset+4], []byte("PE\x00\x00")) {
+			return ""
+		}
+
+		return peMachines[binary.LittleEndian.Uint16(buf[peOffset+4:peOffset+6])]
+	}
+
+	return ""
+}
+
+// detectImageArchitectureFromPath returns the architecture of the operating system on a
+// raw disk image or block device.
+func detectImageArchitectureFromPath(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+
+	defer func() { _ = f.Close() }()
+
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return "", err
+	}
+
+	return detectImageArchitecture(f, size)
+}
+
+// instanceArchitecture returns the architecture to use for the new instance.
+// This is the local architecture unless overridden through --architecture.
+func (c *cmdMigrate) instanceArchitecture() (string, error) {
+	localArchitecture, err := osarch.ArchitectureGetLocal()
+	if err != nil {
+		return "", err
+	}
+
+	if c.flagArchitecture == "" {
+		return localArchitecture, nil
+	}
+
+	// Validate and normalize the provided architecture name.
+	architectureID, err := osarch.ArchitectureID(c.flagArchitecture)
+	if err != nil {
+		return "", err
+	}
+
+	architectureName, err := osarch.ArchitectureName(architectureID)
+	if err != nil {
+		return "", err
+	}
+
+	if architectureName != localArchitecture {
+		fmt.Fprintf(c.out, "WARNING: The instance architecture (%s) differs from the local architecture (%s)\n", architectureName, localArchitecture)
+
+		if !architecturesCompatible(architectureName, localArchitecture) {
+			fmt.Fprintf(c.out, "WARNING: %s instances can't run on %s servers, the instance only starts on a server of its own architecture\n", architectureName, localArchitecture)
+		}
+	}
+
+	return architectureName, nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/lxc/incus/v6/shared/osarch"
+)
+
+// writeELF writes a minimal little-endian ELF header at the provided offset.
+func writeELF(disk []byte, offset int, machine uint16) {
+	copy(disk[offset:], "\x7fELF")
+	disk[offset+4] = 2
+	disk[offset+5] = 1
+	binary.LittleEndian.PutUint16(disk[offset+18:offset+20], machine)
+}
+
+// writePE writes a minimal PE header at the provided offset.
+func writePE(disk []byte, offset int, machine uint16) {
+	copy(disk[offset:], "MZ")
+	binary.LittleEndian.PutUint32(disk[offset+60:offset+64], 128)
+	copy(disk[offset+128:], "PE\x00\x00")
+	binary.LittleEndian.PutUint16(disk[offset+132:offset+134], machine)
+}
+
+// writeGPTEntriesHeader writes a GPT header at LBA 1 of a disk with 512 bytes sectors, its
+// partition entries starting at LBA 2.
+func writeGPTEntriesHeader(disk []byte, entriesCount uint32, entrySize uint32) {
+	header := disk[512 : 512+92]
+	copy(header[0:8], "EFI PART")
+	binary.LittleEndian.PutUint32(header[12:16], 92)
+	binary.LittleEndian.PutUint64(header[72:80], 2)
+	binary.LittleEndian.PutUint32(header[80:84], entriesCount)
+	binary.LittleEndian.PutUint32(header[84:88], entrySize)
+	binary.LittleEndian.PutUint32(header[16:20], crc32.ChecksumIEEE(header))
+}
+
+func TestDetectImageArchitecture(t *testing.T) {
+	const size = 1024 * 1024
+
+	tests := []struct {
+		name  string
+		setup func(disk []byte)
+		want  string
+	}{
+		{
+			name:  "Empty disk",
+			setup: func(disk []byte) {},
+			want:  "",
+		},
+		{
+			name: "GPT with aarch64 root partition",
+			setup: func(disk []byte) {
+				writeGPTEntriesHeader(disk, 4, 128)
+
+				// B921B045-1DF0-41C3-AF44-4C6F280D3FAE as stored on disk.
+				copy(disk[1024+128:], []byte{0x45, 0xb0, 0x21, 0xb9, 0xf0, 0x1d, 0xc3, 0x41, 0xaf, 0x44, 0x4c, 0x6f, 0x28, 0x0d, 0x3f, 0xae})
+			},
+			want: "aarch64",
+		},
+		{
+			name: "GPT with huge partition entries",
+			setup: func(disk []byte) {
+				writeGPTEntriesHeader(disk, 1024, 0xffffff80)
+			},
+			want: "",
+		},
+		{
+			name: "GPT with partition entries size not a power of two",
+			setup: func(disk []byte) {
+				writeGPTEntriesHeader(disk, 4, 192)
+			},
+			want: "",
+		},
+		{
+			name: "x86_64 executables",
+			setup: func(disk []byte) {
+				writePE(disk, 4096, 0x8664)
+				writeELF(disk, 8192, 62)
+				writeELF(disk, 12288, 62)
+				writeELF(disk, 16384, 183)
+			},
+			want: "x86_64",
+		},
+		{
+			name: "Too few executables",
+			setup: func(disk []byte) {
+				writeELF(disk, 8192, 183)
+				writeELF(disk, 12288, 183)
+			},
+			want: "",
+		},
+		{
+			name: "Unaligned executables",
+			setup: func(disk []byte) {
+				writeELF(disk, 8193, 183)
+				writeELF(disk, 12289, 183)
+				writeELF(disk, 16385, 183)
+			},
+			want: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			disk := make([]byte, size)
+			tt.setup(disk)
+
+			got, err := detectImageArchitecture(bytes.NewReader(disk), size)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestArchitecturesCompatible(t *testing.T) {
+	assert.True(t, architecturesCompatible("x86_64", "x86_64"))
+	assert.True(t, architecturesCompatible("i686", "x86_64"))
+	assert.False(t, architecturesCompatible("x86_64", "i686"))
+	assert.False(t, architecturesCompatible("aarch64", "x86_64"))
+}
+
+func TestInstanceArchitecture(t *testing.T) {
+	local, err := osarch.ArchitectureGetLocal()
+	require.NoError(t, err)
+
+	// An architecture the local one can run, if any, and one it can't.
+	compatible := ""
+	if len(compatibleArchitectures[local]) > 0 {
+		compatible = compatibleArchitectures[local][0]
+	}
+
+	incompatible := "s390x"
+	if local == incompatible {
+		incompatible = "x86_64"
+	}
+
+	tests := []struct {
+		name         string
+		architecture string
+		want         string
+		wantWarnings int
+	}{
+		{
+			name: "Local architecture",
+			want: local,
+		},
+		{
+			name:         "Same as the local architecture",
+			architecture: local,
+			want:         local,
+		},
+		{
+			name:         "Compatible architecture",
+			architecture: compatible,
+			want:         compatible,
+			wantWarnings: 1,
+		},
+		{
+			name:         "Incompatible architecture",
+			architecture: incompatible,
+			want:         incompatible,
+			wantWarnings: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.wantWarnings == 1 && tt.architecture == "" {
+				t.Skipf("No architecture compatible with %s", local)
+			}
+
+			var out bytes.Buffer
+
+			c := &cmdMigrate{out: &out, flagArchitecture: tt.architecture}
+
+			got, err := c.instanceArchitecture()
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.wantWarnings, strings.Count(out.String(), "WARNING:"))
+		})
+	}
+}
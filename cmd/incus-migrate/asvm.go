@@ -0,0 +1,372 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+
+	internalUtil "github.com/lxc/incus/v6/internal/util"
+	"github.com/lxc/incus/v6/shared/revert"
+	"github.com/lxc/incus/v6/shared/subprocess"
+	"github.com/lxc/incus/v6/shared/units"
+	"github.com/lxc/incus/v6/shared/util"
+)
+
+// asVMESPSize is the size of the EFI system partition of images built with --as-vm.
+const asVMESPSize = 512 * 1024 * 1024
+
+// asVMCommands are the commands needed to build a disk image with --as-vm.
+var asVMCommands = []string{"sfdisk", "losetup", "mkfs.vfat", "mkfs.ext4", "blkid", "rsync", "chroot"}
+
+// grubEFITargets maps the instance architectures to the EFI targets of grub-install.
+var grubEFITargets = map[string]string{
+	"x86_64":  "x86_64-efi",
+	"aarch64": "arm64-efi",
+}
+
+// checkAsVMSource checks that a root filesystem can be turned into a virtual machine, which
+// mostly means that it comes with a kernel and a bootloader to install.
+func checkAsVMSource(rootfs string, architecture string) error {
+	if !util.PathExists(filepath.Join(rootfs, "etc")) {
+		return fmt.Errorf("%q isn't a root filesystem", rootfs)
+	}
+
+	_, ok := grubEFITargets[architecture]
+	if !ok {
+		return fmt.Errorf("Root filesystems can't be migrated as virtual machines on %s", architecture)
+	}
+
+	err := checkAsVMKernel(rootfs)
+	if err != nil {
+		return err
+	}
+
+	_, _, err = grubCommands(rootfs)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// checkAsVMKernel checks that the root filesystem at the provided path has a kernel in /boot.
+func checkAsVMKernel(rootfs string) error {
+	kernels, _ := filepath.Glob(filepath.Join(rootfs, "boot", "vmlinu*"))
+	if len(kernels) == 0 {
+		kernels, _ = filepath.Glob(filepath.Join(rootfs, "boot", "Image*"))
+	}
+
+	if len(kernels) == 0 {
+		return errors.New("The source has no kernel in /boot, install one (along with GRUB) before migrating it as a virtual machine")
+	}
+
+	return nil
+}
+
+// asVMSubMounts returns the paths within the root filesystem of the local filesystems mounted
+// below it (like a separate /boot or /var), which get copied into the root partition of the disk
+// image, along with the mount points of the other ones. The EFI system partition is left out as
+// the disk image comes with its own.
+func asVMSubMounts(rootfs string) ([]string, []string, error) {
+	root, err := filepath.Abs(rootfs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	root, err = filepath.EvalSymlinks(root)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	mounts, err := sourceSubMounts(root)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var local []string
+	var skipped []string
+	for _, mount := range mounts {
+		path, err := filepath.Rel(root, mount.MountPoint)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		path = "/" + path
+		if path == "/boot/efi" || strings.HasPrefix(path, "/boot/efi/") {
+			continue
+		}
+
+		if !strings.HasPrefix(mount.Source, "/dev/") {
+			skipped = append(skipped, mount.MountPoint)
+			continue
+		}
+
+		local = append(local, path)
+	}
+
+	return local, skipped, nil
+}
+
+// grubCommands returns the paths within the root filesystem of the commands installing GRUB and
+// generating its configuration, along with the path of that configuration.
+func grubCommands(rootfs string) (string, []string, error) {
+	for _, prefix := range []string{"grub", "grub2"} {
+		for _, dir := range []string{"/usr/sbin", "/sbin", "/usr/bin"} {
+			install := filepath.Join(dir, prefix+"-install")
+			mkconfig := filepath.Join(dir, prefix+"-mkconfig")
+
+			if util.PathExists(filepath.Join(rootfs, install)) && util.PathExists(filepath.Join(rootfs, mkconfig)) {
+				return install, []string{mkconfig, "-o", fmt.Sprintf("/boot/%s/grub.cfg", prefix)}, nil
+			}
+		}
+	}
+
+	return "", nil, errors.New("The source has no GRUB EFI bootloader, install it (grub-efi or grub2-efi) before migrating it as a virtual machine")
+}
+
+// buildVMImage packs a root filesystem into a bootable raw disk image, for --as-vm. The image
+// holds a GPT partition table with an EFI system partition and an ext4 root partition, the data
+// being copied over before GRUB gets installed from within the source. It's built in the
+// --as-vm-dir directory if set, or in the provided one otherwise, and removed by the caller.
+func (c *cmdMigrate) buildVMImage(dir string, config *cmdMigrateData) (string, error) {
+	for _, command := range asVMCommands {
+		err := checkCommand(command)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	rootfs := config.SourcePath
+
+	imageDir := dir
+	if c.flagAsVMDir != "" {
+		imageDir = c.flagAsVMDir
+	}
+
+	fmt.Fprintln(c.out, "Calculating the size of the root filesystem")
+
+	used, err := sourceSize(rootfs, MigrationTypeContainer)
+	if err != nil {
+		return "", fmt.Errorf("Failed to calculate the size of the source: %w", err)
+	}
+
+	// Leave some room for the filesystem overhead and the guest, unless a size was requested.
+	size := asVMESPSize + used + used/4 + 1024*1024*1024
+
+	rootSize := config.InstanceArgs.Devices["root"]["size"]
+	if rootSize != "" {
+		size, err = units.ParseByteSizeString(rootSize)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	// Partitions are aligned on MiB boundaries.
+	size = (size + 1024*1024 - 1) / (1024 * 1024) * (1024 * 1024)
+
+	// The image is sparse, only the copied data takes space.
+	var stat unix.Statfs_t
+
+	err = unix.Statfs(imageDir, &stat)
+	if err != nil {
+		return "", err
+	}
+
+	available := int64(stat.Bavail) * stat.Bsize
+	if available < used+asVMESPSize {
+		return "", fmt.Errorf("Not enough space in %q to build the disk image (%s needed, %s available), pick another directory with --as-vm-dir", imageDir, units.GetByteSizeStringIEC(used+asVMESPSize, 2), units.GetByteSizeStringIEC(available, 2))
+	}
+
+	imagePath := filepath.Join(imageDir, fmt.Sprintf("incus-migrate-as-vm-%d.img", os.Getpid()))
+
+	reverter := revert.New()
+	defer reverter.Fail()
+
+	fmt.Fprintf(c.out, "Building a %s disk image from %q\n", units.GetByteSizeStringIEC(size, 2), rootfs)
+	c.setPhase("converting")
+
+	f, err := os.Create(imagePath)
+	if err != nil {
+		return "", err
+	}
+
+	reverter.Add(func() { _ = os.Remove(imagePath) })
+
+	err = f.Truncate(size)
+	_ = f.Close()
+	if err != nil {
+		return "", err
+	}
+
+	err = subprocess.RunCommandWithFds(context.Background(), strings.NewReader(fmt.Sprintf("label: gpt\n,%dMiB,U\n,,L\n", asVMESPSize/1024/1024)), nil, "sfdisk", "--quiet", imagePath)
+	if err != nil {
+		return "", fmt.Errorf("Failed to partition the disk image: %w", err)
+	}
+
+	out, err := subprocess.RunCommand("losetup", "--find", "--show", "--partscan", imagePath)
+	if err != nil {
+		return "", fmt.Errorf("Failed to attach the disk image: %w", err)
+	}
+
+	loopDevice := strings.TrimSpace(out)
+	defer func() { _, _ = subprocess.RunCommand("losetup", "--detach", loopDevice) }()
+
+	espDevice := loopDevice + "p1"
+	rootDevice := loopDevice + "p2"
+
+	_, err = subprocess.RunCommand("mkfs.vfat", "-F", "32", espDevice)
+	if err != nil {
+		return "", fmt.Errorf("Failed to format the EFI system partition: %w", err)
+	}
+
+	_, err = subprocess.RunCommand("mkfs.ext4", "-q", rootDevice)
+	if err != nil {
+		return "", fmt.Errorf("Failed to format the root partition: %w", err)
+	}
+
+	target := filepath.Join(dir, "as-vm")
+
+	err = os.Mkdir(target, 0o755)
+	if err != nil {
+		return "", err
+	}
+
+	defer func() { _ = os.Remove(target) }()
+
+	// Everything mounted below the target, unmounted in reverse order.
+	var mounts []string
+	defer func() {
+		for i := len(mounts) - 1; i >= 0; i-- {
+			_ = unix.Unmount(mounts[i], unix.MNT_DETACH)
+		}
+	}()
+
+	err = unix.Mount(rootDevice, target, "ext4", 0, "")
+	if err != nil {
+		return "", fmt.Errorf("Failed to mount the root partition: %w", err)
+	}
+
+	mounts = append(mounts, target)
+
+	// The root filesystem and the local ones mounted below it, pseudo filesystems like /proc get
+	// their mount points. Parents come first, each copy stopping at the mounts below it.
+	subMounts, skipped, err := asVMSubMounts(rootfs)
+	if err != nil {
+		return "", fmt.Errorf("Failed to list the filesystems mounted below the source: %w", err)
+	}
+
+	for _, mountPoint := range skipped {
+		fmt.Fprintf(c.out, "WARNING: %q isn't a local filesystem, it isn't copied into the disk image\n", mountPoint)
+	}
+
+	fmt.Fprintln(c.out, "Copying the root filesystem into the disk image")
+
+	for _, path := range append([]string{"/"}, subMounts...) {
+		mountPath := filepath.Join(target, path)
+
+		err = os.MkdirAll(mountPath, 0o755)
+		if err != nil {
+			return "", err
+		}
+
+		_, err = subprocess.RunCommand("rsync", "-aHAX", "--numeric-ids", "--one-file-system", internalUtil.AddSlash(filepath.Join(rootfs, path)), mountPath)
+		if err != nil {
+			return "", fmt.Errorf("Failed to copy %q: %w", path, err)
+		}
+	}
+
+	// The kernel may have been on a filesystem which wasn't copied.
+	err = checkAsVMKernel(target)
+	if err != nil {
+		return "", err
+	}
+
+	espPath := filepath.Join(target, "boot", "efi")
+
+	err = os.MkdirAll(espPath, 0o755)
+	if err != nil {
+		return "", err
+	}
+
+	err = unix.Mount(espDevice, espPath, "vfat", 0, "umask=0077")
+	if err != nil {
+		return "", fmt.Errorf("Failed to mount the EFI system partition: %w", err)
+	}
+
+	mounts = append(mounts, espPath)
+
+	err = writeAsVMFstab(target, rootDevice, espDevice)
+	if err != nil {
+		return "", err
+	}
+
+	for _, path := range []string{"/dev", "/proc", "/sys"} {
+		mountPath := filepath.Join(target, path)
+
+		err = os.MkdirAll(mountPath, 0o755)
+		if err != nil {
+			return "", err
+		}
+
+		err = unix.Mount(path, mountPath, "none", unix.MS_BIND|unix.MS_REC, "")
+		if err != nil {
+			return "", fmt.Errorf("Failed to mount %s for the bootloader installation: %w", path, err)
+		}
+
+		mounts = append(mounts, mountPath)
+	}
+
+	// Installed in the removable media path, the VM firmware finds it without any boot entry.
+	fmt.Fprintln(c.out, "Installing the bootloader")
+
+	install, mkconfig, err := grubCommands(target)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = subprocess.RunCommand("chroot", target, install, "--target="+grubEFITargets[config.InstanceArgs.Architecture], "--efi-directory=/boot/efi", "--removable", "--no-nvram", loopDevice)
+	if err != nil {
+		return "", fmt.Errorf("Failed to install GRUB: %w", err)
+	}
+
+	// The host disks are visible through /dev, os-prober would add boot entries for them.
+	_, err = subprocess.RunCommand("chroot", append([]string{target, "env", "GRUB_DISABLE_OS_PROBER=true"}, mkconfig...)...)
+	if err != nil {
+		return "", fmt.Errorf("Failed to generate the GRUB configuration: %w", err)
+	}
+
+	reverter.Success()
+
+	return imagePath, nil
+}
+
+// writeAsVMFstab replaces the fstab of the root filesystem, which refers to the disks of the
+// source, with one for the partitions of the disk image. The original one is kept aside.
+func writeAsVMFstab(target string, rootDevice string, espDevice string) error {
+	uuids := make([]string, 0, 2)
+	for _, device := range []string{rootDevice, espDevice} {
+		out, err := subprocess.RunCommand("blkid", "-s", "UUID", "-o", "value", device)
+		if err != nil {
+			return fmt.Errorf("Failed to get the UUID of %q: %w", device, err)
+		}
+
+		uuids = append(uuids, strings.TrimSpace(out))
+	}
+
+	fstabPath := filepath.Join(target, "etc", "fstab")
+	if util.PathExists(fstabPath) {
+		err := os.Rename(fstabPath, fstabPath+".incus-migrate")
+		if err != nil {
+			return err
+		}
+	}
+
+	content := fmt.Sprintf("UUID=%s / ext4 errors=remount-ro 0 1\nUUID=%s /boot/efi vfat umask=0077 0 1\n", uuids[0], uuids[1])
+
+	return os.WriteFile(fstabPath, []byte(content), 0o644)
+}
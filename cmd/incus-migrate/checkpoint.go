@@ -0,0 +1,168 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// checkpointFileName is the name of the checkpoint file in the temporary directory of a migration.
+const checkpointFileName = "checkpoint.yaml"
+
+// checkpointInterval is the minimum time between two checkpoint writes caused by transfer progress.
+const checkpointInterval = 5 * time.Second
+
+// migrationCheckpoint records the progress of a migration. It is kept up to date on disk so
+// that the progress of a run which got killed can still be reported (see "incus-migrate status").
+type migrationCheckpoint struct {
+	PID              int           `yaml:"pid"`
+	Name             string        `yaml:"name"`
+	Project          string        `yaml:"project,omitempty"`
+	Type             MigrationType `yaml:"type"`
+	Source           string        `yaml:"source"`
+	Phase            string        `yaml:"phase"`
+	Mounts           []string      `yaml:"mounts_completed,omitempty"`
+	BytesTransferred int64         `yaml:"bytes_transferred"`
+	Started          time.Time     `yaml:"started"`
+	Updated          time.Time     `yaml:"updated"`
+
+	path     string
+	mu       sync.Mutex
+	lastSave time.Time
+}
+
+// newCheckpoint creates the checkpoint file in the provided directory.
+func newCheckpoint(dir string, name string, project string, migrationType MigrationType, source string) (*migrationCheckpoint, error) {
+	checkpoint := &migrationCheckpoint{
+		PID:     os.Getpid(),
+		Name:    name,
+		Project: project,
+		Type:    migrationType,
+		Source:  source,
+		Phase:   "setup",
+		Started: time.Now().UTC(),
+		path:    filepath.Join(dir, checkpointFileName),
+	}
+
+	err := checkpoint.save()
+	if err != nil {
+		return nil, err
+	}
+
+	return checkpoint, nil
+}
+
+// setPhase records the current phase of the migration.
+func (c *migrationCheckpoint) setPhase(phase string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.Phase = phase
+	_ = c.save()
+}
+
+// setMounts records the mounts which were set up.
+func (c *migrationCheckpoint) setMounts(mounts []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.Mounts = mounts
+	_ = c.save()
+}
+
+// addBytes records transferred data, only writing the checkpoint every so often.
+func (c *migrationCheckpoint) addBytes(n int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.BytesTransferred += n
+
+	if time.Since(c.lastSave) >= checkpointInterval {
+		_ = c.save()
+	}
+}
+
+// save atomically writes the checkpoint, the caller is responsible for locking.
+func (c *migrationCheckpoint) save() error {
+	c.Updated = time.Now().UTC()
+	c.lastSave = c.Updated
+
+	content, err := yaml.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	tmpPath := c.path + ".tmp"
+
+	err = os.WriteFile(tmpPath, content, 0o600)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, c.path)
+}
+
+// loadCheckpoints returns the checkpoints left in the temporary directory, indexed by path.
+func loadCheckpoints() (map[string]*migrationCheckpoint, error) {
+	paths, err := filepath.Glob(filepath.Join(os.TempDir(), "incus-migrate_mount_*", checkpointFileName))
+	if err != nil {
+		return nil, err
+	}
+
+	checkpoints := make(map[string]*migrationCheckpoint, len(paths))
+
+	// Unreadable and damaged checkpoints are skipped so that the other migrations still get listed.
+	for _, path := range paths {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+
+		checkpoint := &migrationCheckpoint{}
+
+		err = yaml.Unmarshal(content, checkpoint)
+		if err != nil {
+			continue
+		}
+
+		checkpoints[path] = checkpoint
+	}
+
+	return checkpoints, nil
+}
+
+// countingReader calls a function with the number of bytes read.
+type countingReader struct {
+	io.Reader
+
+	count func(int64)
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if n > 0 {
+		r.count(int64(n))
+	}
+
+	return n, err
+}
+
+// countingReadWriteCloser calls a function with the number of bytes read.
+type countingReadWriteCloser struct {
+	io.ReadWriteCloser
+
+	count func(int64)
+}
+
+func (rwc *countingReadWriteCloser) Read(p []byte) (int, error) {
+	n, err := rwc.ReadWriteCloser.Read(p)
+	if n > 0 {
+		rwc.count(int64(n))
+	}
+
+	return n, err
+}
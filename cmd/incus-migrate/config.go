@@ -0,0 +1,229 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"gopkg.in/yaml.v2"
+
+	incus "github.com/lxc/incus/v6/client"
+	"github.com/lxc/incus/v6/shared/api"
+	"github.com/lxc/incus/v6/shared/util"
+)
+
+func (c *cmdMigrate) askConfig(config *cmdMigrateData) error {
+	configs, err := c.global.asker.AskString("Please specify config keys and values (key=value ... or @file.yaml): ", "", func(s string) error {
+		if s == "" {
+			return nil
+		}
+
+		// Load the keys from a YAML file.
+		path, ok := strings.CutPrefix(s, "@")
+		if ok {
+			_, err := readConfigFile(path)
+			return err
+		}
+
+		for _, entry := range strings.Split(s, " ") {
+			if !strings.Contains(entry, "=") {
+				return fmt.Errorf("Bad key=value configuration: %v", entry)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	path, ok := strings.CutPrefix(configs, "@")
+	if ok {
+		// Checked above, but the file may have changed since.
+		keys, err := readConfigFile(path)
+		if err != nil {
+			return err
+		}
+
+		maps.Copy(config.InstanceArgs.Config, keys)
+
+		return nil
+	}
+
+	for _, entry := range strings.Split(configs, " ") {
+		key, value, _ := strings.Cut(entry, "=")
+		config.InstanceArgs.Config[key] = value
+	}
+
+	return nil
+}
+
+// readConfigFile reads instance configuration keys from a YAML file holding a map of strings.
+func readConfigFile(path string) (map[string]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := map[string]string{}
+
+	err = yaml.UnmarshalStrict(content, &keys)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse %q as a map of configuration keys: %w", path, err)
+	}
+
+	return keys, nil
+}
+
+// parseKeyValues parses a list of KEY=VALUE strings.
+func parseKeyValues(values []string) (map[string]string, error) {
+	result := map[string]string{}
+
+	for _, entry := range values {
+		key, value, found := strings.Cut(entry, "=")
+		if !found || key == "" {
+			return nil, fmt.Errorf("Bad KEY=VALUE pair: %q", entry)
+		}
+
+		result[key] = value
+	}
+
+	return result, nil
+}
+
+// applyAfterCreateConfig updates the configuration of the newly created instance.
+func applyAfterCreateConfig(server incus.InstanceServer, name string, config map[string]string) error {
+	inst, etag, err := server.GetInstance(name)
+	if err != nil {
+		return err
+	}
+
+	writable := inst.Writable()
+	maps.Copy(writable.Config, config)
+
+	op, err := server.UpdateInstance(name, writable, etag)
+	if err != nil {
+		return fmt.Errorf("Failed to apply configuration after creation: %w", err)
+	}
+
+	err = op.Wait()
+	if err != nil {
+		return fmt.Errorf("Failed to apply configuration after creation: %w", err)
+	}
+
+	return nil
+}
+
+// applyVolatile sets the volatile keys to preserve, the ones from --volatile taking precedence
+// over those of the source.
+func (c *cmdMigrate) applyVolatile(config *cmdMigrateData) error {
+	volatile := map[string]string{}
+
+	if c.flagVolatileFromSource {
+		sourceVolatile, err := sourceVolatileConfig(config.SourcePath)
+		if err != nil {
+			return fmt.Errorf("Failed to read the volatile keys of the source: %w", err)
+		}
+
+		for key, value := range sourceVolatile {
+			if validateVolatileKey(key, value) == nil {
+				volatile[key] = value
+			}
+		}
+
+		if len(volatile) == 0 {
+			fmt.Fprintln(c.out, "WARNING: The source has no volatile key that can be preserved")
+		}
+	}
+
+	values, err := parseKeyValues(c.flagVolatile)
+	if err != nil {
+		return err
+	}
+
+	maps.Copy(volatile, values)
+
+	for key, value := range volatile {
+		err := validateVolatileKey(key, value)
+		if err != nil {
+			return err
+		}
+
+		config.InstanceArgs.Config[key] = value
+	}
+
+	return nil
+}
+
+// validateVolatileKey checks that a volatile key can be preserved and that its value is valid.
+// Other volatile keys either get regenerated by the server on creation (volatile.uuid.generation)
+// or only make sense on the server which set them (idmaps, host interface names, last state).
+func validateVolatileKey(key string, value string) error {
+	switch {
+	case key == "volatile.uuid" || key == "volatile.cloud-init.instance-id":
+		_, err := uuid.Parse(value)
+		if err != nil {
+			return fmt.Errorf("Invalid UUID %q for %s", value, key)
+		}
+
+	case strings.HasPrefix(key, "volatile.") && strings.HasSuffix(key, ".hwaddr") && strings.Count(key, ".") == 2:
+		_, err := net.ParseMAC(value)
+		if err != nil {
+			return fmt.Errorf("Invalid MAC address %q for %s", value, key)
+		}
+
+	default:
+		return fmt.Errorf("The volatile key %q can't be preserved (only volatile.uuid, volatile.cloud-init.instance-id and volatile.<nic>.hwaddr can)", key)
+	}
+
+	return nil
+}
+
+// applyIdmap sets the idmap configuration of the container.
+func (c *cmdMigrate) applyIdmap(config *cmdMigrateData) error {
+	if !c.flagIdmapIsolated && c.flagIdmapBase == "" && c.flagIdmapSize == "" {
+		return nil
+	}
+
+	if config.InstanceArgs.Type != api.InstanceTypeContainer {
+		return errors.New("An idmap can only be set for containers")
+	}
+
+	if util.IsTrue(config.InstanceArgs.Config["security.privileged"]) {
+		return errors.New("An idmap can't be set for privileged containers")
+	}
+
+	if c.flagIdmapBase != "" {
+		_, err := strconv.ParseUint(c.flagIdmapBase, 10, 32)
+		if err != nil {
+			return fmt.Errorf("Invalid idmap base %q: %w", c.flagIdmapBase, err)
+		}
+
+		config.InstanceArgs.Config["security.idmap.base"] = c.flagIdmapBase
+	}
+
+	if c.flagIdmapSize != "" {
+		size, err := strconv.ParseUint(c.flagIdmapSize, 10, 32)
+		if err != nil {
+			return fmt.Errorf("Invalid idmap size %q: %w", c.flagIdmapSize, err)
+		}
+
+		// Below 65536 IDs, most distributions fail to boot.
+		if size < 65536 {
+			fmt.Fprintf(c.out, "WARNING: An idmap size of %d is smaller than the 65536 IDs most distributions expect\n", size)
+		}
+
+		config.InstanceArgs.Config["security.idmap.size"] = c.flagIdmapSize
+	}
+
+	if c.flagIdmapIsolated {
+		config.InstanceArgs.Config["security.idmap.isolated"] = "true"
+	}
+
+	return nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+
+	incus "github.com/lxc/incus/v6/client"
+	cli "github.com/lxc/incus/v6/internal/cmd"
+	"github.com/lxc/incus/v6/shared/api"
+	"github.com/lxc/incus/v6/shared/revert"
+	"github.com/lxc/incus/v6/shared/util"
+)
+
+// diskVolume represents an additional disk of a virtual machine transferred to its own custom volume.
+type diskVolume struct {
+	// Path of the disk on the source.
+	Source string
+
+	// Storage pool and name of the custom volume.
+	Pool string
+	Name string
+
+	// Path to transfer the data from, once the source is set up.
+	transferPath string
+}
+
+// askDisks sets the additional disks of a virtual machine, from --disk, the --config file or by asking.
+func (c *cmdMigrate) askDisks(config *cmdMigrateData) error {
+	disks := c.flagDisks
+	if c.preseed != nil {
+		disks = append(slices.Clone(disks), c.preseed.Disks...)
+	}
+
+	if config.InstanceArgs.Type != api.InstanceTypeVM {
+		if len(disks) > 0 {
+			return errors.New("Additional disks can only be set for virtual machines")
+		}
+
+		return nil
+	}
+
+	for _, disk := range disks {
+		if !util.PathExists(disk) {
+			return fmt.Errorf("Disk %q doesn't exist", disk)
+		}
+	}
+
+	if len(disks) == 0 && c.preseed == nil {
+		addDisks, err := c.global.asker.AskBool("Do you want to add additional disks? [default=no]: ", "no")
+		if err != nil {
+			return err
+		}
+
+		for addDisks {
+			disk, err := c.global.asker.AskString("Please provide the path to an additional disk [empty value to continue]: ", "", func(s string) error {
+				if s != "" && !util.PathExists(s) {
+					return errors.New("Path does not exist")
+				}
+
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			if disk == "" {
+				break
+			}
+
+			disks = append(disks, disk)
+		}
+	}
+
+	for _, disk := range disks {
+		if filepath.Clean(disk) == filepath.Clean(config.SourcePath) {
+			return fmt.Errorf("The disk %q is already the source", disk)
+		}
+
+		config.Disks = append(config.Disks, diskVolume{Source: disk})
+	}
+
+	return nil
+}
+
+// applyDisks places the additional disks in the storage pool of the root disk and attaches them.
+func (c *cmdMigrate) applyDisks(server incus.InstanceServer, config *cmdMigrateData) error {
+	if len(config.Disks) == 0 {
+		return nil
+	}
+
+	pool, err := rootDiskPool(server, config)
+	if err != nil {
+		return err
+	}
+
+	for i := range config.Disks {
+		deviceName := fmt.Sprintf("disk%d", i+1)
+
+		config.Disks[i].Pool = pool
+		config.Disks[i].Name = config.InstanceArgs.Name + "-" + deviceName
+
+		// Resuming refreshes the volumes left behind by the previous attempt.
+		_, _, err = server.GetStoragePoolVolume(pool, "custom", config.Disks[i].Name)
+		if err == nil && !config.Resume {
+			return fmt.Errorf("Storage volume %q for the disk %q already exists in storage pool %q", config.Disks[i].Name, config.Disks[i].Source, pool)
+		}
+
+		config.InstanceArgs.Devices[deviceName] = map[string]string{
+			"type":   "disk",
+			"pool":   pool,
+			"source": config.Disks[i].Name,
+		}
+	}
+
+	return nil
+}
+
+// transferDiskVolume creates the custom volume for an additional disk and transfers its data.
+// When refresh is set, the data is transferred into the volume left behind by an interrupted migration if there is one.
+// The deletion of the volume is added to the reverter as soon as it's created.
+func (c *cmdMigrate) transferDiskVolume(ctx context.Context, server incus.InstanceServer, reverter *revert.Reverter, disk diskVolume, refresh bool) error {
+	op, err := server.CreateStoragePoolVolumeFromMigration(disk.Pool, api.StorageVolumesPost{
+		Name:        disk.Name,
+		Type:        "custom",
+		ContentType: "block",
+		Source: api.StorageVolumeSource{
+			Type:    "migration",
+			Mode:    "push",
+			Refresh: refresh,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to create volume %q for %q: %w", disk.Name, disk.Source, err)
+	}
+
+	reverter.Add(func() {
+		_ = server.DeleteStoragePoolVolume(disk.Pool, "custom", disk.Name)
+	})
+
+	progress := cli.ProgressRenderer{Format: fmt.Sprintf("Transferring %s: %%s", disk.Source), Quiet: c.flagFormat == "json"}
+	_, err = op.AddHandler(progress.UpdateOp)
+	if err != nil {
+		progress.Done("")
+		return err
+	}
+
+	if c.progress != nil {
+		_, _ = op.AddHandler(c.progress.updateOp)
+	}
+
+	err = transferRootfs(ctx, op, disk.transferPath, c.transferArgs(), MigrationTypeVolumeBlock)
+	if err != nil {
+		progress.Done("")
+		return transferError{err}
+	}
+
+	progress.Done(fmt.Sprintf("Volume %s successfully created", disk.Name))
+
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/lxc/incus/v6/shared/api"
+)
+
+// dryRunSteps describes what the migration would do, for --dry-run.
+func (c *cmdMigrate) dryRunSteps(config *cmdMigrateData, migrationType MigrationType) []string {
+	steps := []string{}
+
+	project := config.Project
+	if project == "" {
+		project = api.ProjectDefaultName
+	}
+
+	if c.dryRunProject != "" {
+		steps = append(steps, fmt.Sprintf("Created project %q", c.dryRunProject))
+	}
+
+	for _, volume := range config.MountVolumes {
+		steps = append(steps, fmt.Sprintf("Transferred %q to custom volume %q in storage pool %q", volume.Source, volume.Name, volume.Pool))
+	}
+
+	for _, disk := range config.Disks {
+		steps = append(steps, fmt.Sprintf("Transferred the disk %q to custom volume %q in storage pool %q", disk.Source, disk.Name, disk.Pool))
+	}
+
+	action := "Created"
+	if config.Resume {
+		action = "Resumed the transfer into the existing"
+	}
+
+	if migrationType == MigrationTypeVolumeBlock || migrationType == MigrationTypeVolumeFilesystem {
+		steps = append(steps, fmt.Sprintf("%s %s custom volume %q in storage pool %q of project %q", action, config.CustomVolumeArgs.ContentType, config.CustomVolumeArgs.Name, config.Pool, project))
+	} else {
+		steps = append(steps, fmt.Sprintf("%s %s %q in project %q", action, config.InstanceArgs.Type, config.InstanceArgs.Name, project))
+	}
+
+	format := c.imageFormat(config.SourcePath)
+	if (migrationType == MigrationTypeVM || migrationType == MigrationTypeVolumeBlock) && format != imageFormatRaw {
+		steps = append(steps, fmt.Sprintf("Converted the %s image %q to raw format", format, config.SourcePath))
+	}
+
+	steps = append(steps, fmt.Sprintf("Transferred %q", config.SourcePath))
+
+	for _, mount := range config.Mounts {
+		if mount != config.SourcePath {
+			steps = append(steps, fmt.Sprintf("Transferred the mount %q", mount))
+		}
+	}
+
+	if migrationType == MigrationTypeContainer || migrationType == MigrationTypeVM {
+		// Validated in migrateInstance.
+		afterCreateConfig, _ := parseKeyValues(c.flagAfterCreateConfig)
+
+		for _, key := range slices.Sorted(maps.Keys(afterCreateConfig)) {
+			steps = append(steps, fmt.Sprintf("Applied %s after creation", key))
+		}
+
+		if config.Netplan != "" {
+			steps = append(steps, fmt.Sprintf("Written the netplan configuration to %q", netplanPath))
+		}
+	}
+
+	if len(config.Excludes) > 0 {
+		steps = append(steps, fmt.Sprintf("Left %s out of the transfer", strings.Join(config.Excludes, ", ")))
+	}
+
+	if migrationType == MigrationTypeContainer {
+		if c.flagDisableTimers {
+			steps = append(steps, "Masked the scheduled jobs")
+		}
+
+		if c.flagContainerFixups {
+			steps = append(steps, "Applied the container fixups")
+		}
+
+		if !c.flagKeepMachineID {
+			steps = append(steps, "Cleared the machine ID")
+		}
+	}
+
+	if c.flagCreatePaused && (migrationType == MigrationTypeContainer || migrationType == MigrationTypeVM) {
+		steps = append(steps, "Paused the instance")
+	}
+
+	for _, target := range c.additionalTargets {
+		steps = append(steps, fmt.Sprintf("Migrated to additional target %q", target.url))
+	}
+
+	return steps
+}
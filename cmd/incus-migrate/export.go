@@ -0,0 +1,259 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	incus "github.com/lxc/incus/v6/client"
+	"github.com/lxc/incus/v6/shared/api"
+	"github.com/lxc/incus/v6/shared/subprocess"
+	"github.com/lxc/incus/v6/shared/util"
+)
+
+// exportManifestName is the name of the manifest, always the first entry of an export archive.
+const exportManifestName = "manifest.yaml"
+
+// exportFormatVersion is the version of the export archive format.
+const exportFormatVersion = 1
+
+// exportManifest describes the content of an export archive.
+//
+// An export archive is an uncompressed tar archive holding the manifest followed by either
+// the root filesystem in "rootfs/" (containers and filesystem volumes) or the raw disk
+// image as "root.img" (virtual machines and block volumes).
+type exportManifest struct {
+	Version      int           `yaml:"version"`
+	Type         MigrationType `yaml:"type"`
+	Architecture string        `yaml:"architecture"`
+	Hostname     string        `yaml:"hostname,omitempty"`
+	Source       string        `yaml:"source"`
+	SourceFormat string        `yaml:"source_format,omitempty"`
+	Mounts       []string      `yaml:"mounts,omitempty"`
+	Created      time.Time     `yaml:"created"`
+}
+
+// exportSource sets up the source and writes it to an archive rather than transferring it to a server.
+func (c *cmdMigrate) exportSource(ctx context.Context) error {
+	if util.PathExists(c.flagExport) {
+		return fmt.Errorf("Export archive %q already exists", c.flagExport)
+	}
+
+	if len(c.flagAdditionalTargets) > 0 {
+		return errors.New("Additional targets can't be used when exporting")
+	}
+
+	exportType, err := c.global.asker.AskInt(`
+What would you like to export?
+1) Container
+2) Virtual Machine
+3) Custom Volume (from filesystem)
+4) Custom Volume (from disk)
+
+Please enter the number of your choice: `, 1, 4, "", nil)
+	if err != nil {
+		return err
+	}
+
+	migrationType := []MigrationType{MigrationTypeContainer, MigrationTypeVM, MigrationTypeVolumeFilesystem, MigrationTypeVolumeBlock}[exportType-1]
+
+	config := cmdMigrateData{}
+	config.InstanceArgs.Config = map[string]string{}
+
+	err = c.askSourcePath(&config, migrationType)
+	if err != nil {
+		return err
+	}
+
+	architecture, err := c.instanceArchitecture()
+	if err != nil {
+		return err
+	}
+
+	if migrationType == MigrationTypeContainer {
+		config.InstanceArgs.Type = api.InstanceTypeContainer
+
+		if !isPlainDirectory(config.SourcePath) {
+			config.Mounts, err = c.askSelectMounts(config.SourcePath)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = c.excludeFSTypes(&config)
+		if err != nil {
+			return err
+		}
+	}
+
+	hostname, _ := os.Hostname()
+
+	manifest := exportManifest{
+		Version:      exportFormatVersion,
+		Type:         migrationType,
+		Architecture: architecture,
+		Hostname:     hostname,
+		Source:       config.SourcePath,
+		SourceFormat: config.SourceFormat,
+		Mounts:       config.Mounts,
+		Created:      time.Now().UTC(),
+	}
+
+	return c.runMigration(ctx, nil, &config, migrationType, func(ctx context.Context, server incus.InstanceServer, config *cmdMigrateData, path string, migrationType MigrationType) error {
+		fmt.Fprintf(c.out, "Exporting to %q\n", c.flagExport)
+
+		err := writeExportArchive(c.flagExport, manifest, path, migrationType)
+		if err != nil {
+			_ = os.Remove(c.flagExport)
+			return fmt.Errorf("Failed to export to %q: %w", c.flagExport, err)
+		}
+
+		fmt.Fprintf(c.out, "Source exported to %q\n", c.flagExport)
+
+		return nil
+	})
+}
+
+// writeExportArchive writes the manifest and the set up source to the archive.
+func writeExportArchive(archivePath string, manifest exportManifest, path string, migrationType MigrationType) error {
+	content, err := yaml.Marshal(&manifest)
+	if err != nil {
+		return err
+	}
+
+	// Filesystems are archived by tar itself to preserve ownership, ACLs and extended attributes.
+	if migrationType == MigrationTypeContainer || migrationType == MigrationTypeVolumeFilesystem {
+		manifestDir, err := os.MkdirTemp("", "incus-migrate_export_")
+		if err != nil {
+			return err
+		}
+
+		defer func() { _ = os.RemoveAll(manifestDir) }()
+
+		err = os.WriteFile(filepath.Join(manifestDir, exportManifestName), content, 0o644)
+		if err != nil {
+			return err
+		}
+
+		_, err = subprocess.RunCommand("tar", "--create", "--file", archivePath, "--numeric-owner", "--xattrs", "--xattrs-include=*", "--acls",
+			"-C", manifestDir, exportManifestName, "-C", filepath.Dir(path), filepath.Base(path))
+
+		return err
+	}
+
+	// Disks may be block devices, so the image content is copied into the archive directly.
+	imagePath := filepath.Join(path, "root.img")
+
+	size, err := diskSize(imagePath)
+	if err != nil {
+		return err
+	}
+
+	image, err := os.Open(imagePath)
+	if err != nil {
+		return err
+	}
+
+	defer func() { _ = image.Close() }()
+
+	f, err := os.OpenFile(archivePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+
+	defer func() { _ = f.Close() }()
+
+	tw := tar.NewWriter(f)
+
+	err = tw.WriteHeader(&tar.Header{Name: exportManifestName, Mode: 0o644, Size: int64(len(content)), ModTime: manifest.Created})
+	if err != nil {
+		return err
+	}
+
+	_, err = tw.Write(content)
+	if err != nil {
+		return err
+	}
+
+	err = tw.WriteHeader(&tar.Header{Name: "root.img", Mode: 0o600, Size: size, ModTime: manifest.Created})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(tw, image)
+	if err != nil {
+		return err
+	}
+
+	err = tw.Close()
+	if err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+// readExportManifest reads the manifest at the start of an export archive.
+func readExportManifest(archivePath string) (*exportManifest, error) {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() { _ = f.Close() }()
+
+	tr := tar.NewReader(f)
+
+	hdr, err := tr.Next()
+	if err != nil || hdr.Name != exportManifestName {
+		return nil, fmt.Errorf("%q isn't an incus-migrate export archive", archivePath)
+	}
+
+	content, err := io.ReadAll(io.LimitReader(tr, 1024*1024))
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &exportManifest{}
+
+	err = yaml.Unmarshal(content, manifest)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse the manifest of %q: %w", archivePath, err)
+	}
+
+	if manifest.Version != exportFormatVersion {
+		return nil, fmt.Errorf("Unsupported export archive version %d", manifest.Version)
+	}
+
+	return manifest, nil
+}
+
+// extractExportArchive extracts an export archive next to it and returns the directory
+// it was extracted to along with the path of the source in it.
+func extractExportArchive(out io.Writer, archivePath string, manifest *exportManifest) (string, string, error) {
+	dir, err := os.MkdirTemp(filepath.Dir(archivePath), ".incus-migrate_import_")
+	if err != nil {
+		return "", "", err
+	}
+
+	fmt.Fprintf(out, "Extracting %q\n", archivePath)
+
+	_, err = subprocess.RunCommand("tar", "--extract", "--file", archivePath, "--directory", dir, "--numeric-owner", "--xattrs", "--xattrs-include=*", "--acls")
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return "", "", fmt.Errorf("Failed to extract %q: %w", archivePath, err)
+	}
+
+	if manifest.Type == MigrationTypeContainer || manifest.Type == MigrationTypeVolumeFilesystem {
+		return dir, filepath.Join(dir, "rootfs"), nil
+	}
+
+	return dir, filepath.Join(dir, "root.img"), nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Firmware choices for virtual machines.
+const (
+	firmwareBIOS           = "bios"
+	firmwareUEFI           = "uefi"
+	firmwareUEFISecureBoot = "uefi-secureboot"
+)
+
+// applyFirmware sets the configuration keys selecting the virtual machine firmware.
+func applyFirmware(config *cmdMigrateData, firmware string) error {
+	switch firmware {
+	case firmwareBIOS:
+		if config.InstanceArgs.Architecture != "x86_64" {
+			return fmt.Errorf("BIOS firmware isn't available on %s", config.InstanceArgs.Architecture)
+		}
+
+		config.InstanceArgs.Config["security.csm"] = "true"
+		config.InstanceArgs.Config["security.secureboot"] = "false"
+	case firmwareUEFI:
+		config.InstanceArgs.Config["security.csm"] = "false"
+		config.InstanceArgs.Config["security.secureboot"] = "false"
+	case firmwareUEFISecureBoot:
+		if !slices.Contains([]string{"x86_64", "aarch64"}, config.InstanceArgs.Architecture) {
+			return fmt.Errorf("UEFI Secure Boot isn't available on %s", config.InstanceArgs.Architecture)
+		}
+
+		config.InstanceArgs.Config["security.csm"] = "false"
+		config.InstanceArgs.Config["security.secureboot"] = "true"
+	default:
+		return fmt.Errorf("Invalid firmware %q (must be one of %q, %q or %q)", firmware, firmwareBIOS, firmwareUEFI, firmwareUEFISecureBoot)
+	}
+
+	return nil
+}
+
+// askVMOptions checks the source of a virtual machine and asks about its boot firmware and
+// security policies. It returns warnings about the source.
+func (c *cmdMigrate) askVMOptions(config *cmdMigrateData) ([]string, error) {
+	var err error
+
+	var warnings []string
+
+	// The disk is built from the root filesystem, see buildVMImage.
+	if c.flagAsVM {
+		err = checkAsVMSource(config.SourcePath, config.InstanceArgs.Architecture)
+		if err != nil {
+			return nil, err
+		}
+
+		warnings = append(warnings, "The root filesystem is packed into a new disk image with its own partitions and fstab (the original one is kept as /etc/fstab.incus-migrate), review the result before relying on it")
+	}
+
+	// Virtual machines need a whole disk, not a partition or a filesystem image.
+	if c.imageFormat(config.SourcePath) == imageFormatRaw && !isStream(config.SourcePath) && !c.flagAsVM {
+		table, err := detectPartitionTableFromPath(config.SourcePath)
+		if err == nil && partitionTableIssue(table) != "" {
+			warnings = append(warnings, partitionTableIssue(table))
+		}
+
+		// A guest of a different architecture won't boot.
+		guestArchitecture, err := detectImageArchitectureFromPath(config.SourcePath)
+		if err == nil && guestArchitecture != "" && !architecturesCompatible(guestArchitecture, config.InstanceArgs.Architecture) {
+			warnings = append(warnings, fmt.Sprintf("The source disk looks like a %s system but the instance architecture is %s, the VM will not boot (use --architecture to change it)", guestArchitecture, config.InstanceArgs.Architecture))
+		}
+	}
+
+	// Security labels live inside the guest filesystems and are transferred along with the disk,
+	// but the guest policy itself may refer to things which change with the migration. The
+	// policy of a root filesystem can be looked up, that of a disk can't without mounting it.
+	var hasMAC bool
+	if c.flagAsVM {
+		hasMAC = sourceEnforcesMAC(config.SourcePath)
+	} else if c.preseed == nil {
+		hasMAC, err = c.global.asker.AskBool("Does the VM enforce SELinux or AppArmor policies? [default=no]: ", "no")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if hasMAC {
+		warnings = append(warnings, "The guest SELinux/AppArmor policy is transferred as-is, rules referring to disk identifiers, device paths or network interface names may need adjusting after the migration")
+	}
+
+	// GRUB is installed without signed binaries.
+	if c.flagAsVM {
+		err = applyFirmware(config, firmwareUEFI)
+		if err != nil {
+			return nil, err
+		}
+	} else if c.flagFirmware != "" {
+		err = applyFirmware(config, c.flagFirmware)
+		if err != nil {
+			return nil, err
+		}
+	} else if c.preseed == nil && slices.Contains([]string{"x86_64", "aarch64"}, config.InstanceArgs.Architecture) {
+		// Only x86_64 has a BIOS firmware.
+		hasUEFI := true
+		if config.InstanceArgs.Architecture == "x86_64" {
+			hasUEFI, err = c.global.asker.AskBool("Does the VM support UEFI booting? [default=yes]: ", "yes")
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if hasUEFI {
+			hasSecureBoot, err := c.global.asker.AskBool("Does the VM support UEFI Secure Boot? [default=yes]: ", "yes")
+			if err != nil {
+				return nil, err
+			}
+
+			if !hasSecureBoot {
+				config.InstanceArgs.Config["security.secureboot"] = "false"
+			}
+		} else {
+			config.InstanceArgs.Config["security.csm"] = "true"
+			config.InstanceArgs.Config["security.secureboot"] = "false"
+		}
+	}
+
+	return warnings, nil
+}
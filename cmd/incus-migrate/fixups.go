@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	incus "github.com/lxc/incus/v6/client"
+	"github.com/lxc/incus/v6/shared/util"
+)
+
+// containerFixup is a change to a file of a transferred container.
+type containerFixup struct {
+	// Path of the file in the container.
+	path string
+
+	// New content of the file.
+	content string
+
+	// Mode of the file.
+	mode os.FileMode
+
+	// Description of the change, as reported to the user.
+	description string
+
+	// Whether the file is a symlink to replace, the server following symlinks when writing files.
+	replaceLink bool
+}
+
+// fstabDeviceSources are the fstab source prefixes referring to block devices, which containers don't have.
+var fstabDeviceSources = []string{"UUID=", "LABEL=", "PARTUUID=", "PARTLABEL=", "/dev/"}
+
+// inittabGetty matches the inittab entries spawning a getty on a virtual terminal.
+var inittabGetty = regexp.MustCompile(`^[^#:]*:[^:]*:respawn:.*getty.*\btty[0-9]+\b`)
+
+// sourceContainerFixups returns the changes making a root filesystem captured from a full
+// system behave in a container named as provided.
+func sourceContainerFixups(rootfs string, name string) []containerFixup {
+	fixups := []containerFixup{}
+
+	// The resolver configuration is often a link to a file generated at runtime by a service
+	// which may not run in the container, leaving it dangling.
+	target, err := os.Readlink(filepath.Join(rootfs, "etc", "resolv.conf"))
+	if err == nil {
+		if !filepath.IsAbs(target) {
+			target = filepath.Join("/etc", target)
+		}
+
+		target = filepath.Clean(target)
+
+		resolved := strings.HasPrefix(target, "/run/systemd/resolve/")
+		resolvedEnabled := len(globRootfs(rootfs, "/etc/systemd/system/*.wants/systemd-resolved.service")) > 0
+
+		if (resolved && !resolvedEnabled) || (!resolved && !util.PathExists(filepath.Join(rootfs, target))) {
+			content := fmt.Sprintf("# Written by incus-migrate, this used to be a link to %s.\n", target)
+
+			// Keep the upstream servers known to systemd-resolved rather than its local stub.
+			upstream, err := os.ReadFile(filepath.Join(rootfs, "run", "systemd", "resolve", "resolv.conf"))
+			if err == nil {
+				content += string(upstream)
+			}
+
+			fixups = append(fixups, containerFixup{
+				path:        "/etc/resolv.conf",
+				content:     content,
+				mode:        0o644,
+				description: fmt.Sprintf("Replaced the /etc/resolv.conf link to %s with a regular file", target),
+				replaceLink: true,
+			})
+		}
+	}
+
+	// The hostname would otherwise override the one set from the instance name.
+	hostnamePath := filepath.Join(rootfs, "etc", "hostname")
+	if isRegularFile(hostnamePath) {
+		content, err := os.ReadFile(hostnamePath)
+		if err == nil && strings.TrimSpace(string(content)) != name {
+			fixups = append(fixups, containerFixup{
+				path:        "/etc/hostname",
+				content:     name + "\n",
+				mode:        0o644,
+				description: fmt.Sprintf("Changed /etc/hostname from %q to %q", strings.TrimSpace(string(content)), name),
+			})
+		}
+	}
+
+	// Block device and swap entries fail to mount and delay the boot.
+	fstabPath := filepath.Join(rootfs, "etc", "fstab")
+	if isRegularFile(fstabPath) {
+		content, err := os.ReadFile(fstabPath)
+		if err == nil {
+			lines := strings.Split(string(content), "\n")
+			disabled := []string{}
+
+			for i, line := range lines {
+				fields := strings.Fields(line)
+				if len(fields) < 3 || strings.HasPrefix(fields[0], "#") {
+					continue
+				}
+
+				isDevice := fields[2] == "swap"
+				for _, prefix := range fstabDeviceSources {
+					if strings.HasPrefix(fields[0], prefix) {
+						isDevice = true
+					}
+				}
+
+				if isDevice {
+					lines[i] = "#" + line
+					disabled = append(disabled, fields[1])
+				}
+			}
+
+			if len(disabled) > 0 {
+				fixups = append(fixups, containerFixup{
+					path:        "/etc/fstab",
+					content:     strings.Join(lines, "\n"),
+					mode:        fileMode(fstabPath),
+					description: fmt.Sprintf("Commented out the block device entries of /etc/fstab: %s", strings.Join(disabled, ", ")),
+				})
+			}
+		}
+	}
+
+	// Containers don't have virtual terminals for gettys to run on.
+	inittabPath := filepath.Join(rootfs, "etc", "inittab")
+	if isRegularFile(inittabPath) {
+		content, err := os.ReadFile(inittabPath)
+		if err == nil {
+			lines := strings.Split(string(content), "\n")
+			var count int
+
+			for i, line := range lines {
+				if inittabGetty.MatchString(line) {
+					lines[i] = "#" + line
+					count++
+				}
+			}
+
+			if count > 0 {
+				fixups = append(fixups, containerFixup{
+					path:        "/etc/inittab",
+					content:     strings.Join(lines, "\n"),
+					mode:        fileMode(inittabPath),
+					description: fmt.Sprintf("Commented out %d getty entries of /etc/inittab", count),
+				})
+			}
+		}
+	}
+
+	return fixups
+}
+
+// sourceGettyUnits returns the enabled systemd getty units bound to virtual terminals.
+func sourceGettyUnits(rootfs string) []string {
+	units := []string{}
+
+	for _, path := range globRootfs(rootfs, "/etc/systemd/system/getty.target.wants/getty@tty*.service") {
+		units = append(units, filepath.Base(path))
+	}
+
+	return units
+}
+
+// applyContainerFixups normalizes the files of a transferred container known to break in containers.
+func applyContainerFixups(out io.Writer, server incus.InstanceServer, name string, rootfs string) error {
+	fixups := sourceContainerFixups(rootfs, name)
+
+	for _, fixup := range fixups {
+		if fixup.replaceLink {
+			err := server.DeleteInstanceFile(name, fixup.path)
+			if err != nil {
+				return fmt.Errorf("Failed to remove %q: %w", fixup.path, err)
+			}
+		}
+
+		err := server.CreateInstanceFile(name, fixup.path, incus.InstanceFileArgs{
+			Content:   strings.NewReader(fixup.content),
+			Mode:      int(fixup.mode.Perm()),
+			Type:      "file",
+			WriteMode: "overwrite",
+		})
+		if err != nil {
+			return fmt.Errorf("Failed to write %q: %w", fixup.path, err)
+		}
+
+		fmt.Fprintln(out, fixup.description)
+	}
+
+	gettyUnits := sourceGettyUnits(rootfs)
+	for _, unit := range gettyUnits {
+		err := maskUnit(out, server, name, rootfs, unit)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(fixups) == 0 && len(gettyUnits) == 0 {
+		fmt.Fprintln(out, "No container fixups needed")
+	}
+
+	return nil
+}
+
+// globRootfs returns the paths matching the pattern in a root filesystem.
+func globRootfs(rootfs string, pattern string) []string {
+	matches, _ := filepath.Glob(filepath.Join(rootfs, pattern))
+
+	return matches
+}
+
+// isRegularFile returns whether the path is a regular file, not following symlinks as
+// those may be absolute and so point outside of the root filesystem.
+func isRegularFile(path string) bool {
+	info, err := os.Lstat(path)
+
+	return err == nil && info.Mode().IsRegular()
+}
+
+// fileMode returns the mode of a file, defaulting to 0644.
+func fileMode(path string) os.FileMode {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return 0o644
+	}
+
+	return info.Mode()
+}
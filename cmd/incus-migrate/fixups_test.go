@@ -0,0 +1,68 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	incus "github.com/lxc/incus/v6/client"
+)
+
+// fileServer records the file operations made on the instances of a server.
+type fileServer struct {
+	incus.InstanceServer
+
+	calls []string
+}
+
+// DeleteInstanceFile records the removal of a file.
+func (s *fileServer) DeleteInstanceFile(instanceName string, filePath string) error {
+	s.calls = append(s.calls, "delete "+filePath)
+
+	return nil
+}
+
+// CreateInstanceFile records the creation of a file along with its content.
+func (s *fileServer) CreateInstanceFile(instanceName string, filePath string, args incus.InstanceFileArgs) error {
+	content, err := io.ReadAll(args.Content)
+	if err != nil {
+		return err
+	}
+
+	s.calls = append(s.calls, "create "+filePath+": "+string(content))
+
+	return nil
+}
+
+func TestApplyContainerFixupsResolvConfLink(t *testing.T) {
+	rootfs := t.TempDir()
+
+	require.NoError(t, os.MkdirAll(filepath.Join(rootfs, "etc"), 0o755))
+	require.NoError(t, os.Symlink("../run/systemd/resolve/stub-resolv.conf", filepath.Join(rootfs, "etc", "resolv.conf")))
+
+	server := &fileServer{}
+
+	require.NoError(t, applyContainerFixups(io.Discard, server, "c1", rootfs))
+
+	// The link must be removed first, or the file would get written to its dangling target.
+	assert.Equal(t, []string{
+		"delete /etc/resolv.conf",
+		"create /etc/resolv.conf: # Written by incus-migrate, this used to be a link to /run/systemd/resolve/stub-resolv.conf.\n",
+	}, server.calls)
+}
+
+func TestApplyContainerFixupsResolvConfFile(t *testing.T) {
+	rootfs := t.TempDir()
+
+	require.NoError(t, os.MkdirAll(filepath.Join(rootfs, "etc"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(rootfs, "etc", "resolv.conf"), []byte("nameserver 192.0.2.1\n"), 0o644))
+
+	server := &fileServer{}
+
+	require.NoError(t, applyContainerFixups(io.Discard, server, "c1", rootfs))
+	assert.Empty(t, server.calls)
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/lxc/incus/v6/shared/units"
+)
+
+// validateFlags checks the values of the command line options and rejects the combinations which
+// don't make sense. Options only valid for some instance types are checked once the type is known.
+// validateFlags checks the options before anything gets asked or connected to, loading the
+// configuration file along the way.
+func (c *cmdMigrate) validateFlags(cmd *cobra.Command) error {
+	flags := cmd.Flags()
+
+	err := c.validateFlagScopes(flags)
+	if err != nil {
+		return err
+	}
+
+	err = c.validateFlagValues()
+	if err != nil {
+		return err
+	}
+
+	err = c.validateSSHFlags()
+	if err != nil {
+		return err
+	}
+
+	// The configuration file is checked up front so that nothing fails half-way.
+	if c.flagConfig != "" {
+		c.preseed, err = loadPreseed(c.flagConfig, c.flagSourceStdin)
+		if err != nil {
+			return err
+		}
+
+		if c.flagAsVM && c.preseed.Type != MigrationTypeVM {
+			return errors.New("--as-vm can only be used with a configuration file for a virtual machine")
+		}
+
+		if c.preseed.Network != "" && c.flagNetworkNone {
+			return errors.New("--network-none can't be used with a network in the configuration file")
+		}
+
+		// Options take precedence over the configuration file.
+		if c.flagFirmware == "" {
+			c.flagFirmware = c.preseed.Firmware
+		}
+	}
+
+	return c.validateFlagCombinations(flags)
+}
+
+// validateFlagScopes checks that no option of the target is used when exporting, and no option
+// picking the source is used when importing.
+func (c *cmdMigrate) validateFlagScopes(flags *pflag.FlagSet) error {
+	// Options which only apply to the target, exporting doesn't involve any.
+	if c.flagExport != "" {
+		for _, name := range []string{"additional-target", "after-create-config", "netplan", "remote", "final-checksum-pass"} {
+			if flags.Changed(name) {
+				return fmt.Errorf("--%s can't be used with --export, pass it along with --import instead", name)
+			}
+		}
+	}
+
+	// Options which pick the source, importing takes it from the archive.
+	if c.flagImport != "" {
+		for _, name := range []string{"select-mounts", "mounts-from-fstab", "exclude-fstype", "include-removable", "source-offset", "source-format", "qemu-img-args", "source-lv", "snapshot", "readonly-source", "capture-facts"} {
+			if flags.Changed(name) {
+				return fmt.Errorf("--%s can't be used with --import, pass it along with --export instead", name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateFlagValues checks the values of the options.
+func (c *cmdMigrate) validateFlagValues() error {
+	if c.flagSourceOffset != "" {
+		offset, err := units.ParseByteSizeString(c.flagSourceOffset)
+		if err != nil {
+			return fmt.Errorf("Invalid source offset %q: %w", c.flagSourceOffset, err)
+		}
+
+		if offset <= 0 || offset%512 != 0 {
+			return fmt.Errorf("Invalid source offset %q: must be a positive multiple of 512 bytes", c.flagSourceOffset)
+		}
+	}
+
+	for name, value := range map[string]string{"source size": c.flagSourceSize, "volume size": c.flagVolumeSize, "state size": c.flagStateSize} {
+		if value == "" {
+			continue
+		}
+
+		_, err := units.ParseByteSizeString(value)
+		if err != nil {
+			return fmt.Errorf("Invalid %s %q: %w", name, value, err)
+		}
+	}
+
+	if c.flagBWLimit != "" {
+		limit, err := units.ParseByteSizeString(c.flagBWLimit)
+		if err != nil || limit <= 0 {
+			return fmt.Errorf("Invalid bandwidth limit %q: must be a positive size (for example 10MB)", c.flagBWLimit)
+		}
+	}
+
+	if c.flagStallTimeout != "" {
+		timeout, err := time.ParseDuration(c.flagStallTimeout)
+		if err != nil || timeout <= 0 {
+			return fmt.Errorf("Invalid stall timeout %q: must be a positive duration (for example 5m)", c.flagStallTimeout)
+		}
+	}
+
+	if c.flagTimeout != "" {
+		timeout, err := time.ParseDuration(c.flagTimeout)
+		if err != nil || timeout <= 0 {
+			return fmt.Errorf("Invalid timeout %q: must be a positive duration (for example 6h)", c.flagTimeout)
+		}
+	}
+
+	if c.flagIOPriority != "" {
+		_, err := ioniceArgs(c.flagIOPriority)
+		if err != nil {
+			return err
+		}
+	}
+
+	if c.flagCompress != "" {
+		_, _, err := parseCompression(c.flagCompress)
+		if err != nil {
+			return err
+		}
+	}
+
+	if c.flagRetries < 0 {
+		return fmt.Errorf("Invalid number of retries %d (must be positive)", c.flagRetries)
+	}
+
+	for name, hook := range map[string]string{"pre-hook": c.flagPreHook, "post-hook": c.flagPostHook} {
+		if hook == "" {
+			continue
+		}
+
+		info, err := os.Stat(hook)
+		if err != nil || info.IsDir() || info.Mode()&0o111 == 0 {
+			return fmt.Errorf("Invalid --%s %q: must be an executable file", name, hook)
+		}
+	}
+
+	if c.flagFirmware != "" && !slices.Contains([]string{firmwareBIOS, firmwareUEFI, firmwareUEFISecureBoot}, c.flagFirmware) {
+		return fmt.Errorf("Invalid firmware %q (must be one of %q, %q or %q)", c.flagFirmware, firmwareBIOS, firmwareUEFI, firmwareUEFISecureBoot)
+	}
+
+	volatile, err := parseKeyValues(c.flagVolatile)
+	if err != nil {
+		return err
+	}
+
+	for key, value := range volatile {
+		err := validateVolatileKey(key, value)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, label := range c.flagLabels {
+		_, _, err := parseLabel(label)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, exclude := range c.flagExclude {
+		err := validateExclude(exclude)
+		if err != nil {
+			return err
+		}
+	}
+
+	if c.flagQemuImgArgs != "" {
+		_, err := qemuImgArgs(c.flagQemuImgArgs)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Block devices hold raw data.
+	if c.flagSourceFormat == "block" {
+		c.flagSourceFormat = imageFormatRaw
+	}
+
+	if c.flagSourceFormat != "auto" && c.flagSourceFormat != imageFormatRaw && !slices.Contains(imageFormats, c.flagSourceFormat) {
+		return fmt.Errorf("Invalid source format %q (must be one of auto, raw, block, qcow2, vmdk, vhd or vhdx)", c.flagSourceFormat)
+	}
+
+	if !slices.Contains([]string{"text", "json"}, c.flagFormat) {
+		return fmt.Errorf("Invalid format %q (must be text or json)", c.flagFormat)
+	}
+
+	// Nothing gets created otherwise.
+	if c.flagFormat == "json" && (c.flagExport != "" || c.flagScanOnly || c.flagDumpServerInfo || c.flagDryRun) {
+		return errors.New("--format json can't be used with --export, --scan-only, --dump-server-info or --dry-run")
+	}
+
+	// The questions would be printed along with the result.
+	if c.flagFormat == "json" && c.flagConfig == "" {
+		return errors.New("--format json requires --config, as no question can be asked")
+	}
+
+	if c.flagFSType != "" && !slices.Contains([]string{"ext4", "xfs", "btrfs"}, c.flagFSType) {
+		return fmt.Errorf("Invalid filesystem type %q (must be one of ext4, xfs or btrfs)", c.flagFSType)
+	}
+
+	return nil
+}
+
+// validateSSHFlags checks the options of --rsync-ssh.
+func (c *cmdMigrate) validateSSHFlags() error {
+	if c.flagRsyncSSH == "" {
+		return nil
+	}
+
+	if strings.ContainsAny(c.flagRsyncSSH, ":/ ") || strings.HasPrefix(c.flagRsyncSSH, "-") {
+		return fmt.Errorf("Invalid SSH destination %q: must be [USER@]HOST, the directory of the container being found out from the target server", c.flagRsyncSSH)
+	}
+
+	// rsync can't pass single quotes through its remote shell command.
+	if strings.Contains(c.flagSSHIdentity, "'") {
+		return fmt.Errorf("Invalid SSH identity %q: must not contain single quotes", c.flagSSHIdentity)
+	}
+
+	if strings.Contains(c.flagSSHKnownHosts, "'") {
+		return fmt.Errorf("Invalid SSH known hosts file %q: must not contain single quotes", c.flagSSHKnownHosts)
+	}
+
+	if !slices.Contains([]string{sshHostKeyStrict, sshHostKeyAcceptNew, sshHostKeyInsecure}, c.flagSSHHostKeyPolicy) {
+		return fmt.Errorf("Invalid SSH host key policy %q: must be %s, %s or %s", c.flagSSHHostKeyPolicy, sshHostKeyStrict, sshHostKeyAcceptNew, sshHostKeyInsecure)
+	}
+
+	if c.flagSSHHostKeyPolicy == sshHostKeyInsecure && c.flagSSHKnownHosts != "" {
+		return errors.New("--ssh-known-hosts can't be used with the insecure SSH host key policy, which doesn't check the host key")
+	}
+
+	if c.flagSSHTargetPath != "" && (!filepath.IsAbs(c.flagSSHTargetPath) || filepath.Clean(c.flagSSHTargetPath) == "/") {
+		return fmt.Errorf("Invalid SSH target path %q: must be an absolute path to the directory of the container", c.flagSSHTargetPath)
+	}
+
+	return checkCommand("ssh")
+}
+
+// validateFlagCombinations checks the options against flagConflicts and flagDependencies.
+func (c *cmdMigrate) validateFlagCombinations(flags *pflag.FlagSet) error {
+	// Whether an option is in effect, set to a value other than false or empty, either as a
+	// flag or through the configuration file.
+	isSet := func(name string) bool {
+		if c.preseed != nil {
+			switch name {
+			case "firmware":
+				return c.flagFirmware != ""
+			case "source-stdin":
+				return c.flagSourceStdin || c.preseed.Source == "-"
+			}
+		}
+
+		return flags.Changed(name) && !slices.Contains([]string{"", "false", "0", "[]"}, flags.Lookup(name).Value.String())
+	}
+
+	for _, conflict := range flagConflicts {
+		if isSet(conflict[0]) && isSet(conflict[1]) {
+			return fmt.Errorf("--%s can't be used with --%s", conflict[0], conflict[1])
+		}
+	}
+
+	for _, dependency := range flagDependencies {
+		if isSet(dependency[0]) && !isSet(dependency[1]) {
+			return fmt.Errorf("--%s requires --%s", dependency[0], dependency[1])
+		}
+	}
+
+	return nil
+}
+
+// flagConflicts lists the options which can't be used together.
+var flagConflicts = [][2]string{
+	{"export", "import"},
+	{"select-mounts", "mounts-from-fstab"},
+	{"source-size", "skip-size-checks"},
+	{"description", "target-description-from-source"},
+	{"dump-server-info", "export"},
+	{"dump-server-info", "import"},
+	{"scan-only", "export"},
+	{"scan-only", "import"},
+	{"scan-only", "dump-server-info"},
+	{"config", "export"},
+	{"config", "import"},
+	{"config", "scan-only"},
+	{"config", "remote"},
+	{"config", "source-lv"},
+	{"config", "select-mounts"},
+	{"config", "mounts-from-fstab"},
+	{"config", "pause-before-transfer"},
+	{"dry-run", "export"},
+	{"exclude", "export"},
+	{"resume", "export"},
+	{"disk", "export"},
+	{"target", "export"},
+	{"resume", "additional-target"},
+	{"source-stdin", "source-lv"},
+	{"source-stdin", "source-offset"},
+	{"source-stdin", "verify"},
+	{"source-stdin", "resume"},
+	{"source-stdin", "export"},
+	{"source-stdin", "additional-target"},
+	{"compress", "export"},
+	{"pre-hook", "export"},
+	{"post-hook", "export"},
+	{"retries", "export"},
+	{"retries", "source-stdin"},
+	{"verify", "export"},
+	{"timeout", "export"},
+	{"rsync-ssh", "export"},
+	{"rsync-ssh", "additional-target"},
+	{"client-cert", "remote"},
+	{"post-migrate-snapshot", "export"},
+	{"btrfs-send", "export"},
+	{"btrfs-send", "rsync-ssh"},
+	{"btrfs-send", "resume"},
+	{"btrfs-send", "snapshot"},
+	{"as-vm", "export"},
+	{"as-vm", "import"},
+	{"as-vm", "source-lv"},
+	{"as-vm", "source-offset"},
+	{"as-vm", "source-stdin"},
+	{"as-vm", "source-format"},
+	{"as-vm", "firmware"},
+	{"dry-run", "scan-only"},
+	{"dry-run", "dump-server-info"},
+}
+
+// flagDependencies lists the options which need another one.
+var flagDependencies = [][2]string{
+	{"idmap-base", "idmap-isolated"},
+	{"ssh-identity", "rsync-ssh"},
+	{"ssh-known-hosts", "rsync-ssh"},
+	{"ssh-host-key-policy", "rsync-ssh"},
+	{"ssh-target-path", "rsync-ssh"},
+	{"final-checksum-pass", "rsync-ssh"},
+	{"as-vm-dir", "as-vm"},
+	{"client-cert", "client-key"},
+	{"client-key", "client-cert"},
+	{"source-stdin", "config"},
+	{"source-stdin", "source-size"},
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// runHook runs a hook executable, passing the details of the migration through the environment.
+func runHook(ctx context.Context, out io.Writer, path string, env []string) error {
+	cmd := exec.CommandContext(ctx, path)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = out
+	cmd.Stderr = os.Stderr
+
+	return cmd.Run()
+}
+
+// migrationHookEnv returns the environment passed to the hooks. They get the source as provided,
+// before it's replaced by a snapshot or loop device.
+func migrationHookEnv(name string, config *cmdMigrateData, migrationType MigrationType) []string {
+	return []string{
+		"INCUS_MIGRATE_NAME=" + name,
+		"INCUS_MIGRATE_PROJECT=" + config.Project,
+		"INCUS_MIGRATE_TYPE=" + string(migrationType),
+		"INCUS_MIGRATE_SOURCE=" + config.SourcePath,
+	}
+}
+
+// runPreHook runs the pre-migration hook, if any, a failure aborting the migration.
+func (c *cmdMigrate) runPreHook(ctx context.Context, env []string) error {
+	if c.flagPreHook == "" {
+		return nil
+	}
+
+	if c.flagDryRun {
+		fmt.Fprintf(c.out, "Skipping the pre-migration hook %q in dry run mode\n", c.flagPreHook)
+		return nil
+	}
+
+	fmt.Fprintf(c.out, "Running the pre-migration hook %q\n", c.flagPreHook)
+
+	err := runHook(ctx, c.out, c.flagPreHook, env)
+	if err != nil {
+		return fmt.Errorf("Pre-migration hook failed: %w", err)
+	}
+
+	return nil
+}
+
+// runPostHook runs the post-migration hook, if any. The data is already transferred, a failure is
+// left for the operator to deal with. The hook is told which additional targets failed, so that
+// it doesn't switch over to those.
+func (c *cmdMigrate) runPostHook(ctx context.Context, env []string, succeededTargets []string, failedTargets []string) {
+	if c.flagPostHook == "" {
+		return
+	}
+
+	fmt.Fprintf(c.out, "Running the post-migration hook %q\n", c.flagPostHook)
+
+	env = append(env,
+		"INCUS_MIGRATE_SUCCEEDED_TARGETS="+strings.Join(succeededTargets, " "),
+		"INCUS_MIGRATE_FAILED_TARGETS="+strings.Join(failedTargets, " "),
+	)
+
+	err := runHook(ctx, c.out, c.flagPostHook, env)
+	if err != nil {
+		fmt.Fprintf(c.out, "WARNING: Post-migration hook failed: %v\n", err)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/lxc/incus/v6/internal/linux"
+)
+
+// setupDiskImage exposes a disk source read-only as root.img in the directory, converting it to
+// a raw image in there first when needed. It returns the path of the raw disk.
+func (c *cmdMigrate) setupDiskImage(dir string, sourcePath string, format string) (string, error) {
+	if format != imageFormatRaw && c.flagDryRun {
+		// Converting may take a while and needs as much space as the disk, the size
+		// check then uses the size of the image file.
+		fmt.Fprintf(c.out, "Skipping the conversion of %s image %q in dry run mode\n", format, sourcePath)
+	} else if format != imageFormatRaw {
+		destImg := filepath.Join(dir, "converted-raw-image.img")
+
+		fmt.Fprintf(c.out, "Converting %s image %q to raw format before importing\n", format, sourcePath)
+		c.setPhase("converting")
+
+		extraArgs, err := qemuImgArgs(c.flagQemuImgArgs)
+		if err != nil {
+			return "", err
+		}
+
+		err = convertImage(sourcePath, destImg, format, extraArgs)
+		if err != nil {
+			return "", fmt.Errorf("Failed to convert image %q for importing: %w", sourcePath, err)
+		}
+
+		sourcePath = destImg
+	}
+
+	target := filepath.Join(dir, "root.img")
+
+	err := os.WriteFile(target, nil, 0o644)
+	if err != nil {
+		return "", fmt.Errorf("Failed to create %q: %w", target, err)
+	}
+
+	// Mount the path
+	err = unix.Mount(sourcePath, target, "none", unix.MS_BIND, "")
+	if err != nil {
+		return "", fmt.Errorf("Failed to mount %s: %w", sourcePath, err)
+	}
+
+	// Make it read-only
+	err = unix.Mount("", target, "none", unix.MS_BIND|unix.MS_RDONLY|unix.MS_REMOUNT, "")
+	if err != nil {
+		return "", fmt.Errorf("Failed to make %s read-only: %w", sourcePath, err)
+	}
+
+	return sourcePath, nil
+}
+
+// cleanupDiskImage removes what setupDiskImage left in the directory.
+func cleanupDiskImage(dir string) {
+	_ = unix.Unmount(filepath.Join(dir, "root.img"), unix.MNT_DETACH)
+	_ = os.Remove(filepath.Join(dir, "converted-raw-image.img"))
+	_ = os.Remove(filepath.Join(dir, "converted-raw-image.img.partial"))
+	_ = os.Remove(filepath.Join(dir, "root.img"))
+}
+
+// checkStream checks that a stream, like a named pipe, can be used as the source.
+func (c *cmdMigrate) checkStream(path string, migrationType MigrationType) error {
+	if !isStream(path) {
+		return nil
+	}
+
+	if migrationType != MigrationTypeVM && migrationType != MigrationTypeVolumeBlock {
+		return errors.New("A stream can only be used as the source of virtual machines and block volumes")
+	}
+
+	// Nothing can be read ahead of the transfer, the size must be known.
+	if c.flagSourceSize == "" {
+		return errors.New("Streaming the source requires --source-size to be set to the exact size of the disk")
+	}
+
+	if c.flagSourceFormat != "" && c.flagSourceFormat != "auto" && c.flagSourceFormat != imageFormatRaw {
+		return errors.New("Only raw disks can be streamed")
+	}
+
+	return nil
+}
+
+// imageFormat returns the format of a disk source, as set with --source-format or detected.
+func (c *cmdMigrate) imageFormat(path string) string {
+	if c.flagSourceFormat != "" && c.flagSourceFormat != "auto" {
+		return c.flagSourceFormat
+	}
+
+	// Detecting the format would consume the start of the stream.
+	if isStream(path) {
+		return imageFormatRaw
+	}
+
+	format, _ := detectImageFormat(path)
+
+	return format
+}
+
+// reportSourceFormat returns the description of a disk source format, printing how it was
+// detected with --verbose and pointing out when --source-format overrides the detection.
+func (c *cmdMigrate) reportSourceFormat(path string) string {
+	if isStream(path) {
+		return "raw (stream)"
+	}
+
+	detected, evidence := detectImageFormat(path)
+	if c.flagVerbose {
+		fmt.Fprintf(c.out, "Detected source format %s: %s\n", detected, evidence)
+	}
+
+	description := detectSourceFormat(path)
+
+	format := c.imageFormat(path)
+	if format != detected {
+		fmt.Fprintf(c.out, "Using source format %s as requested rather than the detected %s\n", format, detected)
+		return fmt.Sprintf("%s (set by --source-format, detected: %s)", format, description)
+	}
+
+	return description
+}
+
+// detectSourceFormat returns the format of a disk source.
+func detectSourceFormat(path string) string {
+	format, _ := detectImageFormat(path)
+	if format != imageFormatRaw {
+		return format
+	}
+
+	if linux.IsBlockdevPath(path) {
+		format = "Block device"
+	}
+
+	// Report the partition table to tell whole disks from partitions and filesystem images.
+	table, err := detectPartitionTableFromPath(path)
+	if err != nil {
+		return format
+	}
+
+	return fmt.Sprintf("%s, partition table: %s", format, table)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/lxc/incus/v6/internal/version"
+)
+
+// parseLabel parses a KEY=VALUE label, the key being that of a user.* configuration key.
+// The migrate.* keys are reserved for the provenance labels and the facts of the source. Only the
+// key is checked, the server accepting any value for user.* keys.
+func parseLabel(label string) (string, string, error) {
+	key, value, found := strings.Cut(label, "=")
+	if !found || key == "" {
+		return "", "", fmt.Errorf("Bad KEY=VALUE label: %q", label)
+	}
+
+	if key == "migrate" || strings.HasPrefix(key, "migrate.") {
+		return "", "", fmt.Errorf("Invalid label %q: the migrate.* keys are reserved for incus-migrate", label)
+	}
+
+	if strings.HasPrefix(key, ".") || strings.HasSuffix(key, ".") || strings.Contains(key, "..") || strings.ContainsFunc(key, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune("._-", r)
+	}) {
+		return "", "", fmt.Errorf("Invalid label %q: the key must be made of letters, digits, dots, dashes and underscores", label)
+	}
+
+	return key, value, nil
+}
+
+// applyLabels sets the user provided labels as well as the provenance ones as user.* configuration keys.
+func (c *cmdMigrate) applyLabels(config *cmdMigrateData) error {
+	for _, label := range c.flagLabels {
+		key, value, err := parseLabel(label)
+		if err != nil {
+			return err
+		}
+
+		config.InstanceArgs.Config["user."+key] = value
+	}
+
+	if c.flagNoProvenance {
+		return nil
+	}
+
+	// The source path stands for sources without a hostname, like disk images.
+	origin := sourceHostname(config.SourcePath)
+	if origin == "" {
+		origin = config.SourcePath
+	}
+
+	config.InstanceArgs.Config["user.migrate.source"] = origin
+	config.InstanceArgs.Config["user.migrate.date"] = time.Now().UTC().Format(time.RFC3339)
+	config.InstanceArgs.Config["user.migrate.tool-version"] = version.Version
+
+	return nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/lxc/incus/v6/shared/api"
+	"github.com/lxc/incus/v6/shared/units"
+	"github.com/lxc/incus/v6/shared/util"
+	"github.com/lxc/incus/v6/shared/validate"
+)
+
+// instanceLimitKeys are the configuration keys set from the resource limits step of the menu.
+var instanceLimitKeys = []string{"limits.cpu", "limits.memory", "limits.memory.swap"}
+
+// askLimits sets the CPU and memory limits of the instance, along with the use of swap for
+// containers.
+func (c *cmdMigrate) askLimits(config *cmdMigrateData) error {
+	// Both --cpu-pin and the number of CPUs are stored in limits.cpu, the last one set wins.
+	if c.flagCPUPin != "" && config.InstanceArgs.Config["limits.cpu"] == c.flagCPUPin {
+		fmt.Fprintf(c.out, "WARNING: The CPUs are pinned to %q by --cpu-pin, specifying a number of CPUs replaces the pinning\n", c.flagCPUPin)
+	}
+
+	err := c.askLimit(config, "limits.cpu", "Please specify the number of CPUs", func(s string) error {
+		count, err := strconv.Atoi(s)
+		if err != nil || count < 1 {
+			return errors.New("Number of CPUs must be a positive integer")
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = c.askLimit(config, "limits.memory", "Please specify the memory limit, like 4GiB", func(s string) error {
+		size, err := units.ParseByteSizeString(s)
+		if err != nil {
+			return err
+		}
+
+		if size <= 0 {
+			return errors.New("Memory limit must be positive")
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Virtual machines have no control over swap.
+	if config.InstanceArgs.Type != api.InstanceTypeContainer {
+		return nil
+	}
+
+	return c.askLimit(config, "limits.memory.swap", "Please specify whether the container may use swap (true or false), or how much", func(s string) error {
+		if util.IsTrue(s) || util.IsFalse(s) {
+			return nil
+		}
+
+		_, err := units.ParseByteSizeString(s)
+		return err
+	})
+}
+
+// askLimit asks for the value of a limit, an empty answer keeping the current value and "-"
+// removing it.
+func (c *cmdMigrate) askLimit(config *cmdMigrateData, key string, question string, validate func(string) error) error {
+	current, ok := config.InstanceArgs.Config[key]
+	if !ok {
+		current = "unlimited"
+	}
+
+	value, err := c.global.asker.AskString(fmt.Sprintf("%s [current=%s, \"-\" for unlimited]: ", question, current), "", func(s string) error {
+		if s == "" || s == "-" {
+			return nil
+		}
+
+		return validate(s)
+	})
+	if err != nil {
+		return err
+	}
+
+	switch value {
+	case "":
+	case "-":
+		delete(config.InstanceArgs.Config, key)
+	default:
+		config.InstanceArgs.Config[key] = value
+	}
+
+	return nil
+}
+
+// cpuLimitValidators validate the CPU scheduling options the same way the server does.
+var cpuLimitValidators = map[string]func(value string) error{
+	"limits.cpu.allowance": validateCPUAllowance,
+	"limits.cpu":           validate.IsValidCPUSet,
+	"limits.cpu.nodes":     validate.Or(validate.IsValidCPUSet, validate.IsOneOf("0", "balanced")),
+}
+
+// validateCPUAllowance checks a CPU allowance, either a percentage or a time based one
+// (like 25ms/100ms).
+func validateCPUAllowance(value string) error {
+	if strings.HasSuffix(value, "%") {
+		_, err := strconv.Atoi(strings.TrimSuffix(value, "%"))
+		return err
+	}
+
+	fields := strings.SplitN(value, "/", 2)
+	if len(fields) != 2 {
+		return fmt.Errorf("Invalid allowance: %s", value)
+	}
+
+	for _, field := range fields {
+		_, err := strconv.Atoi(strings.TrimSuffix(field, "ms"))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// applyCPULimits validates and sets the CPU scheduling options provided on the command line.
+func (c *cmdMigrate) applyCPULimits(config *cmdMigrateData) error {
+	for key, value := range map[string]string{
+		"limits.cpu.allowance": c.flagCPUAllowance,
+		"limits.cpu":           c.flagCPUPin,
+		"limits.cpu.nodes":     c.flagCPUNodes,
+	} {
+		if value == "" {
+			continue
+		}
+
+		if key == "limits.cpu.allowance" && config.InstanceArgs.Type != api.InstanceTypeContainer {
+			return errors.New("A CPU allowance can only be set for containers")
+		}
+
+		err := cpuLimitValidators[key](value)
+		if err != nil {
+			return fmt.Errorf("Invalid value %q for %q: %w", value, key, err)
+		}
+
+		config.InstanceArgs.Config[key] = value
+	}
+
+	return nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lxc/incus/v6/shared/api"
+)
+
+func TestApplyCPULimits(t *testing.T) {
+	tests := []struct {
+		name         string
+		instanceType api.InstanceType
+		allowance    string
+		pin          string
+		nodes        string
+		want         map[string]string
+		wantErr      string
+	}{
+		{
+			name:         "No limits",
+			instanceType: api.InstanceTypeContainer,
+			want:         map[string]string{},
+		},
+		{
+			name:         "Percentage allowance",
+			instanceType: api.InstanceTypeContainer,
+			allowance:    "50%",
+			want:         map[string]string{"limits.cpu.allowance": "50%"},
+		},
+		{
+			name:         "Time based allowance",
+			instanceType: api.InstanceTypeContainer,
+			allowance:    "25ms/100ms",
+			want:         map[string]string{"limits.cpu.allowance": "25ms/100ms"},
+		},
+		{
+			name:         "Invalid allowance",
+			instanceType: api.InstanceTypeContainer,
+			allowance:    "25ms",
+			wantErr:      `Invalid value "25ms" for "limits.cpu.allowance"`,
+		},
+		{
+			name:         "Allowance of a virtual machine",
+			instanceType: api.InstanceTypeVM,
+			allowance:    "50%",
+			wantErr:      "A CPU allowance can only be set for containers",
+		},
+		{
+			name:         "Pinning and nodes",
+			instanceType: api.InstanceTypeVM,
+			pin:          "0-3,6",
+			nodes:        "balanced",
+			want:         map[string]string{"limits.cpu": "0-3,6", "limits.cpu.nodes": "balanced"},
+		},
+		{
+			name:         "Invalid pinning",
+			instanceType: api.InstanceTypeContainer,
+			pin:          "0-",
+			wantErr:      `Invalid value "0-" for "limits.cpu"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &cmdMigrate{flagCPUAllowance: tt.allowance, flagCPUPin: tt.pin, flagCPUNodes: tt.nodes}
+			config := &cmdMigrateData{}
+			config.InstanceArgs.Type = tt.instanceType
+			config.InstanceArgs.Config = map[string]string{}
+
+			err := c.applyCPULimits(config)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, config.InstanceArgs.Config)
+		})
+	}
+}
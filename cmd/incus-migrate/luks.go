@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/lxc/incus/v6/shared/subprocess"
+	"github.com/lxc/incus/v6/shared/termios"
+)
+
+// luksMagic is the signature at the start of LUKS (version 1 and 2) headers.
+var luksMagic = []byte{'L', 'U', 'K', 'S', 0xba, 0xbe}
+
+// luksMaxAttempts is the number of passphrases tried before giving up on a LUKS source.
+const luksMaxAttempts = 3
+
+// isLUKS returns whether a disk source starts with a LUKS header.
+func isLUKS(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil || info.IsDir() || isStream(path) {
+		return false
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+
+	defer func() { _ = f.Close() }()
+
+	header := make([]byte, len(luksMagic))
+
+	_, err = io.ReadFull(f, header)
+	if err != nil {
+		return false
+	}
+
+	return bytes.Equal(header, luksMagic)
+}
+
+// openLUKS unlocks a LUKS source read-only into a temporary device mapper device, asking for
+// its passphrase. It returns the path of the decrypted device along with a function closing it.
+func (c *cmdMigrate) openLUKS(path string) (string, func(), error) {
+	err := checkCommand("cryptsetup")
+	if err != nil {
+		return "", nil, err
+	}
+
+	if c.flagFormat == "json" {
+		return "", nil, fmt.Errorf("%q is encrypted with LUKS but its passphrase can't be asked for with --format json", path)
+	}
+
+	if !termios.IsTerminal(unix.Stdin) {
+		return "", nil, fmt.Errorf("%q is encrypted with LUKS but its passphrase can't be asked for, the standard input isn't a terminal", path)
+	}
+
+	name := fmt.Sprintf("incus-migrate-%d", os.Getpid())
+
+	for attempt := 1; ; attempt++ {
+		passphrase := c.global.asker.AskPasswordOnce(fmt.Sprintf("Passphrase of the LUKS encrypted source %q: ", path))
+
+		err = subprocess.RunCommandWithFds(context.Background(), strings.NewReader(passphrase), nil, "cryptsetup", "open", "--type", "luks", "--readonly", "--key-file", "-", path, name)
+		if err == nil {
+			break
+		}
+
+		if attempt >= luksMaxAttempts {
+			return "", nil, fmt.Errorf("Failed to unlock %q: %w", path, err)
+		}
+
+		fmt.Fprintln(c.out, "Failed to unlock the source, please try again")
+	}
+
+	closeDevice := func() {
+		_, err := subprocess.RunCommand("cryptsetup", "close", name)
+		if err != nil {
+			fmt.Fprintf(c.out, "WARNING: Failed to close the decrypted device %q: %v\n", name, err)
+		}
+	}
+
+	return filepath.Join("/dev/mapper", name), closeDevice, nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/lxc/incus/v6/shared/subprocess"
+)
+
+// logicalVolume is an LVM logical volume used as a source.
+type logicalVolume struct {
+	vg   string
+	lv   string
+	path string
+	thin bool
+}
+
+// lookupLogicalVolume resolves a "VG/LV" name to the logical volume through lvs.
+func lookupLogicalVolume(name string) (*logicalVolume, error) {
+	vg, lv, found := strings.Cut(name, "/")
+	if !found || vg == "" || lv == "" || strings.Contains(lv, "/") {
+		return nil, fmt.Errorf("Invalid logical volume %q (must be VG/LV)", name)
+	}
+
+	err := checkCommand("lvs")
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := subprocess.RunCommand("lvs", "--noheadings", "--separator", ":", "-o", "lv_path,lv_attr", name)
+	if err != nil {
+		return nil, fmt.Errorf("Logical volume %q not found: %w", name, err)
+	}
+
+	path, attr, found := strings.Cut(strings.TrimSpace(out), ":")
+	if !found || path == "" {
+		return nil, fmt.Errorf("Failed to parse the lvs output for %q", name)
+	}
+
+	// The attributes start with the volume type ("V" for thin volumes) and hold the state
+	// ("a" when active) in the fifth position.
+	if len(attr) < 5 || attr[4] != 'a' {
+		return nil, fmt.Errorf("Logical volume %q isn't active", name)
+	}
+
+	return &logicalVolume{vg: vg, lv: lv, path: path, thin: attr[0] == 'V'}, nil
+}
+
+// createSnapshot creates a read-only snapshot of the logical volume, returning it along with
+// a function removing it.
+//
+// Thin volumes get a thin snapshot, others a classic one as large as the origin so that it
+// can't run out of space whatever gets written to the origin during the transfer.
+func (v *logicalVolume) createSnapshot(out io.Writer) (*logicalVolume, func(), error) {
+	name := v.lv + "-incus-migrate"
+
+	args := []string{"--snapshot", "--permission", "r", "--name", name}
+	if v.thin {
+		// Thin snapshots are skipped on activation by default.
+		args = append(args, "--setactivationskip", "n")
+	} else {
+		args = append(args, "--extents", "100%ORIGIN")
+	}
+
+	_, err := subprocess.RunCommand("lvcreate", append(args, v.vg+"/"+v.lv)...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to snapshot logical volume %s/%s: %w", v.vg, v.lv, err)
+	}
+
+	remove := func() {
+		_, err := subprocess.RunCommand("lvremove", "--force", v.vg+"/"+name)
+		if err != nil {
+			fmt.Fprintf(out, "WARNING: Failed to remove the snapshot %s/%s: %v\n", v.vg, name, err)
+			return
+		}
+
+		fmt.Fprintf(out, "Removed the snapshot %s/%s\n", v.vg, name)
+	}
+
+	snapshot, err := lookupLogicalVolume(v.vg + "/" + name)
+	if err != nil {
+		remove()
+		return nil, nil, err
+	}
+
+	return snapshot, remove, nil
+}
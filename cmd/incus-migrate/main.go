@@ -41,6 +41,22 @@ func main() {
 	netcatCmd := cmdNetcat{global: &globalCmd}
 	app.AddCommand(netcatCmd.command())
 
+	// doctor sub-command
+	doctorCmd := cmdDoctor{global: &globalCmd}
+	app.AddCommand(doctorCmd.command())
+
+	// remote sub-command
+	remoteCmd := cmdRemote{global: &globalCmd}
+	app.AddCommand(remoteCmd.command())
+
+	// convert sub-command
+	convertCmd := cmdConvert{global: &globalCmd}
+	app.AddCommand(convertCmd.command())
+
+	// status sub-command
+	statusCmd := cmdStatus{global: &globalCmd}
+	app.AddCommand(statusCmd.command())
+
 	// Run the main command and handle errors
 	err := app.Execute()
 	if err != nil {
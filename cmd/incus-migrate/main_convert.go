@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/sys/unix"
+
+	"github.com/lxc/incus/v6/shared/units"
+	"github.com/lxc/incus/v6/shared/util"
+)
+
+type cmdConvert struct {
+	global *cmdGlobal
+
+	flagQemuImgArgs string
+}
+
+func (c *cmdConvert) command() *cobra.Command {
+	cmd := &cobra.Command{}
+
+	cmd.Use = "convert <source> <destination>"
+	cmd.Short = "Convert a disk image to a raw image"
+	cmd.Long = `Description:
+  Convert a disk image to a raw image
+
+  This runs the same conversion as a migration from a qcow2, vmdk, vhd or vhdx image
+  would, without creating any instance.
+`
+	cmd.RunE = c.run
+
+	cmd.Flags().StringVar(&c.flagQemuImgArgs, "qemu-img-args", "", "Extra arguments to pass to qemu-img, like \"-m 8 -W\" for faster conversions, taking precedence over the default ones"+"``")
+
+	return cmd
+}
+
+func (c *cmdConvert) run(cmd *cobra.Command, args []string) error {
+	if len(args) != 2 {
+		_ = cmd.Help()
+		return errors.New("Missing required arguments")
+	}
+
+	sourcePath := args[0]
+	destPath := args[1]
+
+	if util.PathExists(destPath) {
+		return fmt.Errorf("Destination %q already exists", destPath)
+	}
+
+	extraArgs, err := qemuImgArgs(c.flagQemuImgArgs)
+	if err != nil {
+		return err
+	}
+
+	format, _ := detectImageFormat(sourcePath)
+	if !slices.Contains(imageFormats, format) {
+		return fmt.Errorf("Unsupported source format %q (only qcow2, vmdk, vhd and vhdx images can be converted)", format)
+	}
+
+	fmt.Printf("Converting %s image %q to %q\n", format, sourcePath, destPath)
+
+	start := time.Now()
+
+	err = convertImage(sourcePath, destPath, format, extraArgs)
+	if err != nil {
+		return fmt.Errorf("Failed to convert image %q: %w", sourcePath, err)
+	}
+
+	sourceInfo, err := os.Stat(sourcePath)
+	if err != nil {
+		return err
+	}
+
+	var destStat unix.Stat_t
+
+	err = unix.Stat(destPath, &destStat)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Conversion completed in %s\n", time.Since(start).Round(time.Second))
+	fmt.Printf("  Source size: %s\n", units.GetByteSizeStringIEC(sourceInfo.Size(), 2))
+	fmt.Printf("  Disk size: %s\n", units.GetByteSizeStringIEC(destStat.Size, 2))
+	fmt.Printf("  Space used: %s\n", units.GetByteSizeStringIEC(destStat.Blocks*512, 2))
+
+	return nil
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/sys/unix"
+
+	incus "github.com/lxc/incus/v6/client"
+	"github.com/lxc/incus/v6/internal/linux"
+	"github.com/lxc/incus/v6/internal/version"
+	"github.com/lxc/incus/v6/shared/subprocess"
+	"github.com/lxc/incus/v6/shared/units"
+)
+
+type cmdDoctor struct {
+	global *cmdGlobal
+
+	flagServer string
+}
+
+type doctorCheck struct {
+	name     string
+	guidance string
+	optional bool
+	run      func() (string, error)
+}
+
+func (c *cmdDoctor) command() *cobra.Command {
+	cmd := &cobra.Command{}
+
+	cmd.Use = "doctor"
+	cmd.Short = "Check the local environment for migration requirements"
+	cmd.Long = `Description:
+  Check the local environment for migration requirements
+
+  This runs the same checks as a migration would (root privileges,
+  required tools, temporary space, mount namespaces) and reports which
+  ones pass or fail, along with some guidance on how to fix failures.
+
+  When --server is provided, connectivity to the target server is also checked.
+`
+	cmd.RunE = c.run
+	cmd.Flags().StringVar(&c.flagServer, "server", "", "Incus server URL to check connectivity to"+"``")
+
+	return cmd
+}
+
+func (c *cmdDoctor) run(_ *cobra.Command, _ []string) error {
+	checks := []doctorCheck{
+		{
+			name:     "Running as root",
+			guidance: "Run the tool as root (for example through sudo)",
+			run: func() (string, error) {
+				return "", checkRoot()
+			},
+		},
+		{
+			name:     "rsync is available",
+			guidance: "Install rsync (for example with \"apt install rsync\")",
+			run: func() (string, error) {
+				return commandVersion("rsync", "--version")
+			},
+		},
+		{
+			name:     "qemu-img is available",
+			guidance: "Install qemu-img to import qcow2, vmdk, vhd or vhdx images (for example with \"apt install qemu-utils\")",
+			optional: true,
+			run: func() (string, error) {
+				return commandVersion("qemu-img", "--version")
+			},
+		},
+		{
+			name:     "Temporary space",
+			guidance: "Free up space in " + os.TempDir() + " or point TMPDIR to a larger filesystem",
+			run:      checkTempSpace,
+		},
+		{
+			name:     "Mount namespace support",
+			guidance: "Make sure the tool isn't run in a restricted environment (seccomp, unprivileged container)",
+			run: func() (string, error) {
+				return "", checkUnshare()
+			},
+		},
+	}
+
+	if c.flagServer != "" {
+		checks = append(checks, doctorCheck{
+			name:     "Server connectivity",
+			guidance: "Make sure the server is exposed to the network and reachable from this machine",
+			run: func() (string, error) {
+				return checkServer(c.flagServer)
+			},
+		})
+	}
+
+	failed := false
+	for _, check := range checks {
+		detail, err := check.run()
+		if err != nil {
+			status := "FAIL"
+			if check.optional {
+				status = "WARN"
+			} else {
+				failed = true
+			}
+
+			fmt.Printf("[%s] %s: %v\n", status, check.name, err)
+			fmt.Printf("       %s\n", check.guidance)
+			continue
+		}
+
+		if detail != "" {
+			fmt.Printf("[PASS] %s (%s)\n", check.name, detail)
+		} else {
+			fmt.Printf("[PASS] %s\n", check.name)
+		}
+	}
+
+	if failed {
+		return errors.New("Some required checks failed")
+	}
+
+	return nil
+}
+
+// commandVersion checks that the command exists and returns the first line of its version output.
+func commandVersion(name string, args ...string) (string, error) {
+	err := checkCommand(name)
+	if err != nil {
+		return "", err
+	}
+
+	out, err := subprocess.RunCommand(name, args...)
+	if err != nil {
+		return "", fmt.Errorf("Failed to get %q version: %w", name, err)
+	}
+
+	line, _, _ := strings.Cut(out, "\n")
+
+	return strings.TrimSpace(line), nil
+}
+
+// checkTempSpace reports the available space in the temporary directory.
+func checkTempSpace() (string, error) {
+	st, err := linux.StatVFS(os.TempDir())
+	if err != nil {
+		return "", err
+	}
+
+	available := int64(st.Bavail) * st.Bsize
+	if available == 0 {
+		return "", fmt.Errorf("No space left in %q", os.TempDir())
+	}
+
+	return fmt.Sprintf("%s available in %s", units.GetByteSizeStringIEC(available, 2), os.TempDir()), nil
+}
+
+// checkUnshare attempts to create a new mount namespace on a throw-away thread.
+func checkUnshare() error {
+	errCh := make(chan error, 1)
+
+	go func() {
+		// The thread is never unlocked so it gets discarded along with the new namespace.
+		runtime.LockOSThread()
+		errCh <- unix.Unshare(unix.CLONE_NEWNS)
+	}()
+
+	err := <-errCh
+	if err != nil {
+		return fmt.Errorf("Failed to unshare mount namespace: %w", err)
+	}
+
+	return nil
+}
+
+// checkServer checks that the server is reachable, without attempting to authenticate.
+func checkServer(serverURL string) (string, error) {
+	serverURL, err := parseURL(serverURL)
+	if err != nil {
+		return "", err
+	}
+
+	args := incus.ConnectionArgs{
+		UserAgent:          fmt.Sprintf("LXC-MIGRATE %s", version.Version),
+		InsecureSkipVerify: true,
+	}
+
+	server, err := incus.ConnectIncus(serverURL, &args)
+	if err != nil {
+		return "", fmt.Errorf("Failed to connect to %q: %w", serverURL, err)
+	}
+
+	apiServer, _, err := server.GetServer()
+	if err != nil {
+		return "", fmt.Errorf("Failed to get server information: %w", err)
+	}
+
+	return fmt.Sprintf("API version %s", apiServer.APIVersion), nil
+}
@@ -5,14 +5,17 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"maps"
 	"os"
-	"os/exec"
 	"os/signal"
 	"path/filepath"
 	"runtime"
 	"slices"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 	"golang.org/x/sys/unix"
@@ -20,13 +23,10 @@ import (
 
 	incus "github.com/lxc/incus/v6/client"
 	cli "github.com/lxc/incus/v6/internal/cmd"
-	"github.com/lxc/incus/v6/internal/linux"
-	"github.com/lxc/incus/v6/internal/version"
 	"github.com/lxc/incus/v6/shared/api"
-	"github.com/lxc/incus/v6/shared/archive"
-	"github.com/lxc/incus/v6/shared/osarch"
 	"github.com/lxc/incus/v6/shared/revert"
-	localtls "github.com/lxc/incus/v6/shared/tls"
+	"github.com/lxc/incus/v6/shared/subprocess"
+	"github.com/lxc/incus/v6/shared/termios"
 	"github.com/lxc/incus/v6/shared/units"
 	"github.com/lxc/incus/v6/shared/util"
 )
@@ -34,7 +34,96 @@ import (
 type cmdMigrate struct {
 	global *cmdGlobal
 
-	flagRsyncArgs string
+	flagRsyncArgs    string
+	flagArchitecture string
+	flagNetworkNone  bool
+
+	flagFinalChecksumPass   bool
+	flagLabels              []string
+	flagNoProvenance        bool
+	flagRemote              string
+	flagCPUAllowance        string
+	flagCPUPin              string
+	flagCPUNodes            string
+	flagIOPriority          string
+	flagCaptureFacts        bool
+	flagRawAppArmor         string
+	flagPauseBeforeTransfer bool
+	flagSourceSize          string
+	flagSkipSizeChecks      bool
+	flagReadOnlySource      bool
+	flagNetplan             string
+	flagAdditionalTargets   []string
+	flagAfterCreateConfig   []string
+	flagSourceOffset        string
+	flagVolumeSize          string
+	flagDumpServerInfo      bool
+	flagMountPools          []string
+	flagExcludeFSTypes      []string
+	flagStateSize           string
+	flagSelectMounts        bool
+	flagFirmware            string
+	flagKeepMachineID       bool
+	flagFSType              string
+	flagIncludeRemovable    bool
+	flagReportProgressTo    string
+	flagIdmapIsolated       bool
+	flagIdmapBase           string
+	flagIdmapSize           string
+	flagExport              string
+	flagImport              string
+	flagMountsFromFstab     bool
+	flagDisableTimers       bool
+	flagDescription         string
+	flagDescriptionSource   bool
+	flagStallTimeout        string
+	flagCreatePaused        bool
+	flagContainerFixups     bool
+	flagSourceFormat        string
+	flagVerbose             bool
+	flagQemuImgArgs         string
+	flagSourceLV            string
+	flagSnapshot            bool
+	flagNotify              bool
+	flagVolatile            []string
+	flagVolatileFromSource  bool
+	flagScanOnly            bool
+	flagConfig              string
+	flagDryRun              bool
+	flagExclude             []string
+	flagBWLimit             string
+	flagResume              bool
+	flagDisks               []string
+	flagTarget              string
+	flagSourceStdin         bool
+	flagFormat              string
+	flagKeepMounts          bool
+	flagCompress            string
+	flagPreHook             string
+	flagPostHook            string
+	flagRetries             int
+	flagAsVM                bool
+	flagVerify              bool
+	flagTimeout             string
+	flagRsyncSSH            string
+	flagSSHTargetPath       string
+	flagSSHIdentity         string
+	flagSSHKnownHosts       string
+	flagSSHHostKeyPolicy    string
+	flagClientCert          string
+	flagClientKey           string
+	flagPostMigrateSnapshot bool
+	flagBtrfsSend           bool
+	flagAsVMDir             string
+
+	additionalTargets []migrateTarget
+	checkpoint        *migrationCheckpoint
+	progress          *progressReporter
+	out               io.Writer
+	importSource      string
+	preseed           *migratePreseed
+	createdProject    string
+	dryRunProject     string
 }
 
 func (c *cmdMigrate) command() *cobra.Command {
@@ -55,6 +144,85 @@ func (c *cmdMigrate) command() *cobra.Command {
 `
 	cmd.RunE = c.run
 	cmd.Flags().StringVar(&c.flagRsyncArgs, "rsync-args", "", "Extra arguments to pass to rsync (for file transfers)"+"``")
+	cmd.Flags().StringVar(&c.flagArchitecture, "architecture", "", "Architecture of the new instance (defaults to the local architecture)"+"``")
+	cmd.Flags().BoolVar(&c.flagNetworkNone, "network-none", false, "Create the instance without any network device")
+	cmd.Flags().BoolVar(&c.flagFinalChecksumPass, "final-checksum-pass", false, "Send again the files which differ from the source once transferred, comparing their checksums (requires --rsync-ssh)")
+	cmd.Flags().StringArrayVar(&c.flagLabels, "label", nil, "Label to set on the instance as a user.KEY configuration key (KEY=VALUE)"+"``")
+	cmd.Flags().BoolVar(&c.flagNoProvenance, "no-provenance", false, "Don't record the migration source, date and tool version on the instance")
+	cmd.Flags().StringVar(&c.flagCPUAllowance, "cpu-allowance", "", "CPU allowance for the instance (limits.cpu.allowance, containers only)"+"``")
+	cmd.Flags().StringVar(&c.flagCPUPin, "cpu-pin", "", "Set of CPUs to pin the instance to (limits.cpu)"+"``")
+	cmd.Flags().StringVar(&c.flagCPUNodes, "cpu-nodes", "", "NUMA nodes to place the instance CPUs on (limits.cpu.nodes)"+"``")
+	cmd.Flags().StringVar(&c.flagIOPriority, "io-priority", "", "IO priority of the transfer through ionice (\"idle\" or a best-effort level between 0 and 7)"+"``")
+	cmd.Flags().BoolVar(&c.flagCaptureFacts, "capture-facts", false, "Record hardware facts of this machine (CPU, memory, disks, network interfaces, kernel) and the hostname of the source as user.migrate.facts.* configuration keys")
+	cmd.Flags().StringVar(&c.flagRawAppArmor, "raw-apparmor", "", "Extra AppArmor rules for the instance (raw.apparmor)"+"``")
+	cmd.Flags().BoolVar(&c.flagPauseBeforeTransfer, "pause-before-transfer", false, "Pause once the source is set up to allow inspecting it before the transfer starts")
+	cmd.Flags().StringVar(&c.flagSourceSize, "source-size", "", "Size of the source, skips scanning it for the size checks"+"``")
+	cmd.Flags().BoolVar(&c.flagSkipSizeChecks, "skip-size-checks", false, "Don't check that the source fits on the target storage")
+	cmd.Flags().BoolVar(&c.flagReadOnlySource, "readonly-source", false, "Expose filesystem sources through an overlay so that any write is discarded (block sources are always read-only)")
+	cmd.Flags().StringVar(&c.flagNetplan, "netplan", "", "Netplan configuration file for the instance (written to /etc/netplan for containers, passed through cloud-init for VMs)"+"``")
+	cmd.Flags().StringArrayVar(&c.flagAdditionalTargets, "additional-target", nil, "URL of an additional Incus server to migrate the same source to (can be repeated)"+"``")
+	cmd.Flags().StringArrayVar(&c.flagAfterCreateConfig, "after-create-config", nil, "Configuration key to apply once the instance is created and transferred (KEY=VALUE)"+"``")
+	cmd.Flags().StringVar(&c.flagSourceOffset, "source-offset", "", "Offset at which the data starts in a raw image or block device source (for example to use a partition of a whole disk image)"+"``")
+	cmd.Flags().StringVar(&c.flagVolumeSize, "volume-size", "", "Size of the new custom volume"+"``")
+	cmd.Flags().BoolVar(&c.flagDumpServerInfo, "dump-server-info", false, "Print what the target server reports about itself (version, API extensions, ...) and exit")
+	cmd.Flags().StringArrayVar(&c.flagMountPools, "mount-pool", nil, "Transfer an additional mount to its own custom volume on a storage pool (MOUNT=POOL, containers only)"+"``")
+	cmd.Flags().StringArrayVar(&c.flagExcludeFSTypes, "exclude-fstype", nil, "Skip the additional mounts of this filesystem type (for example fuse, which covers fuse.* types, or nfs, which covers nfs4, can be repeated)"+"``")
+	cmd.Flags().StringVar(&c.flagStateSize, "state-size", "", "Size of the state space on the root disk for stateful snapshots and stops (size.state, VMs only)"+"``")
+	cmd.Flags().BoolVar(&c.flagSelectMounts, "select-mounts", false, "Pick the additional mounts from the filesystems mounted below the source (containers only)")
+	cmd.Flags().StringVar(&c.flagFirmware, "firmware", "", "Firmware of the virtual machine (\"bios\", \"uefi\" or \"uefi-secureboot\"), skips the firmware questions"+"``")
+	cmd.Flags().BoolVar(&c.flagKeepMachineID, "keep-machine-id", false, "Keep the machine ID of the source in the new container rather than clearing it so that it generates a fresh one on boot (containers only)")
+	cmd.Flags().StringVar(&c.flagFSType, "fs-type", "", "Filesystem to format the new container root disk or custom volume with (block.filesystem, one of ext4, xfs or btrfs)"+"``")
+	cmd.Flags().BoolVar(&c.flagIncludeRemovable, "include-removable", false, "Also offer the discovered mounts which look like removable media or virtual mounts (USB drives, snaps, overlays)")
+	cmd.Flags().StringVar(&c.flagReportProgressTo, "report-progress-to", "", "File or file descriptor number to write progress events to as newline-delimited JSON"+"``")
+	cmd.Flags().BoolVar(&c.flagIdmapIsolated, "idmap-isolated", false, "Use an idmap unique to the container (security.idmap.isolated, containers only)")
+	cmd.Flags().StringVar(&c.flagIdmapBase, "idmap-base", "", "Host ID to start the isolated idmap at (security.idmap.base, containers only)"+"``")
+	cmd.Flags().StringVar(&c.flagIdmapSize, "idmap-size", "", "Size of the idmap (security.idmap.size, containers only)"+"``")
+	cmd.Flags().StringVar(&c.flagExport, "export", "", "Write the source to an archive instead of transferring it to a server (see --import)"+"``")
+	cmd.Flags().StringVar(&c.flagImport, "import", "", "Transfer the source from an archive created with --export"+"``")
+	cmd.Flags().BoolVar(&c.flagMountsFromFstab, "mounts-from-fstab", false, "Pick the additional mounts from the filesystems listed in the fstab of the source (containers only)")
+	cmd.Flags().BoolVar(&c.flagDisableTimers, "disable-timers", false, "Mask the cron daemon and the systemd timers of the new container so that no scheduled job runs on first boot (containers only)")
+	cmd.Flags().StringVar(&c.flagDescription, "description", "", "Description of the new instance"+"``")
+	cmd.Flags().BoolVar(&c.flagDescriptionSource, "target-description-from-source", true, "Describe the new instance from its source (hostname, distribution and date) unless --description is set")
+	cmd.Flags().StringVar(&c.flagStallTimeout, "stall-timeout", "", "Abort file transfers when no data was exchanged for that long (for example 5m), unlike a limit on the duration of the whole migration"+"``")
+	cmd.Flags().BoolVar(&c.flagCreatePaused, "create-paused", false, "Start the new instance and immediately freeze it, rather than leaving it stopped, so that it can be inspected before processing anything")
+	cmd.Flags().BoolVar(&c.flagContainerFixups, "container-fixups", false, "Adapt the files of the new container which break outside of a full system (resolv.conf link, hostname, fstab devices, gettys) (containers only)")
+	cmd.Flags().StringVar(&c.flagSourceFormat, "source-format", "auto", "Format of the disk source (\"raw\" or \"block\", \"qcow2\", \"vmdk\", \"vhd\" or \"vhdx\"), \"auto\" detects it from the image header"+"``")
+	cmd.Flags().BoolVar(&c.flagVerbose, "verbose", false, "Report the evidence behind detected settings, like the source format, and list the files as they get transferred")
+	cmd.Flags().StringVar(&c.flagQemuImgArgs, "qemu-img-args", "", "Extra arguments to pass to qemu-img when converting qcow2, vmdk, vhd and vhdx images, like \"-m 8 -W\" for faster conversions, taking precedence over the default ones"+"``")
+	cmd.Flags().StringVar(&c.flagSourceLV, "source-lv", "", "LVM logical volume to use as the source, as VG/LV (virtual machines and block volumes only)"+"``")
+	cmd.Flags().BoolVar(&c.flagSnapshot, "snapshot", false, "Transfer temporary read-only snapshots of the source, for a consistent copy of a system in use (btrfs subvolumes and LVM logical volumes, --source-lv for virtual machines and block volumes)")
+	cmd.Flags().BoolVar(&c.flagNotify, "notify", false, "Send a desktop notification (or ring the terminal bell) when the migration completes or fails")
+	cmd.Flags().StringArrayVar(&c.flagVolatile, "volatile", nil, "Volatile key to preserve on the new instance (KEY=VALUE, one of volatile.uuid, volatile.cloud-init.instance-id or volatile.<nic>.hwaddr)"+"``")
+	cmd.Flags().BoolVar(&c.flagVolatileFromSource, "volatile-from-source", false, "Preserve the volatile keys of the Incus instance being migrated, read from the backup.yaml file next to the source")
+	cmd.Flags().BoolVar(&c.flagScanOnly, "scan-only", false, "Analyze the source and print a readiness report without connecting to any server")
+	cmd.Flags().StringVar(&c.flagConfig, "config", "", "YAML file answering all the questions, for non-interactive migrations"+"``")
+	cmd.Flags().BoolVar(&c.flagDryRun, "dry-run", false, "Check the target and set up the source without creating or transferring anything")
+	cmd.Flags().StringArrayVar(&c.flagExclude, "exclude", nil, "Path to leave out of the transfer, as an absolute path within the container which may hold wildcards (can be repeated)"+"``")
+	cmd.Flags().StringVar(&c.flagBWLimit, "bwlimit", "", "Maximum rate of file transfers per second, like 10MB (unlimited by default)"+"``")
+	cmd.Flags().BoolVar(&c.flagResume, "resume", false, "Resume the transfer into an instance or volume left behind by an incomplete migration (marked with user.incus-migrate.partial), and keep what was created if the transfer fails")
+	cmd.Flags().StringArrayVar(&c.flagDisks, "disk", nil, "Additional disk of the virtual machine, transferred to its own custom volume (can be repeated)"+"``")
+	cmd.Flags().StringVar(&c.flagTarget, "target", "", "Cluster member to create the instance or volume on, when the target server is a cluster"+"``")
+	cmd.Flags().BoolVar(&c.flagSourceStdin, "source-stdin", false, "Read the raw disk of the virtual machine or block volume from the standard input (requires --config and --source-size)")
+	cmd.Flags().StringVar(&c.flagFormat, "format", "text", "Format of the result printed once the migration completes (text or json), with json the rest of the output goes to stderr and --config is required"+"``")
+	cmd.Flags().BoolVar(&c.flagKeepMounts, "keep-mounts", false, "Keep the temporary directory and its mounts once the migration is over, until confirmed (for debugging)")
+	cmd.Flags().StringVar(&c.flagCompress, "compress", "", "Compression of file transfers, as an algorithm supported by rsync with an optional level like zstd:3, or none (zlib:2 by default)"+"``")
+	cmd.Flags().StringVar(&c.flagPreHook, "pre-hook", "", "Executable to run before setting up the source, the migration being aborted if it fails"+"``")
+	cmd.Flags().StringVar(&c.flagPostHook, "post-hook", "", "Executable to run once the source was transferred"+"``")
+	cmd.Flags().IntVar(&c.flagRetries, "retries", 0, "Number of times to retry a failed transfer, waiting longer each time (deleting what was transferred once the last one fails)"+"``")
+	cmd.Flags().BoolVar(&c.flagAsVM, "as-vm", false, "Migrate a root filesystem as a virtual machine, packing it into a bootable disk image (experimental, the source needs a kernel and GRUB)")
+	cmd.Flags().BoolVar(&c.flagVerify, "verify", false, "Compare the checksums of a sample of the transferred files with the source, failing the migration on mismatches (containers only)")
+	cmd.Flags().StringVar(&c.flagTimeout, "timeout", "", "Abort the transfer when it didn't complete within that long (for example 6h), deleting the partially transferred instance or volume"+"``")
+	cmd.Flags().StringVar(&c.flagRsyncSSH, "rsync-ssh", "", "Send the files of containers with rsync over SSH straight to the directory of the new container on the target server [USER@]HOST, writing to the storage of the server rather than going through the API (advanced, dir and btrfs storage pools only)"+"``")
+	cmd.Flags().StringVar(&c.flagSSHTargetPath, "ssh-target-path", "", "Directory of the root filesystem of the new container on the --rsync-ssh server (defaults to its location in a storage pool under "+rsyncSSHDir+")"+"``")
+	cmd.Flags().StringVar(&c.flagSSHIdentity, "ssh-identity", "", "SSH private key to authenticate with for --rsync-ssh"+"``")
+	cmd.Flags().StringVar(&c.flagSSHKnownHosts, "ssh-known-hosts", "", "Known hosts file to check the host key of the --rsync-ssh server against (defaults to the one of the user)"+"``")
+	cmd.Flags().StringVar(&c.flagSSHHostKeyPolicy, "ssh-host-key-policy", sshHostKeyStrict, "How to handle the host key of the --rsync-ssh server: strict (refuse unknown and changed keys), accept-new (record unknown keys, refuse changed ones) or insecure (accept any key)"+"``")
+	cmd.Flags().StringVar(&c.flagClientCert, "client-cert", "", "Client certificate to authenticate with, rather than asking for the authentication method (trusted with a token on first use)"+"``")
+	cmd.Flags().StringVar(&c.flagClientKey, "client-key", "", "Key of the --client-cert certificate"+"``")
+	cmd.Flags().BoolVar(&c.flagPostMigrateSnapshot, "post-migrate-snapshot", false, "Snapshot the new instance as \""+postMigrateSnapshotName+"\" once transferred, as a rollback point (instances only)")
+	cmd.Flags().BoolVar(&c.flagBtrfsSend, "btrfs-send", false, "Send containers whose root filesystem is a btrfs subvolume as a btrfs send stream when the target storage pool is btrfs too, preserving reflinks and compression (falls back to rsync otherwise)")
+	cmd.Flags().StringVar(&c.flagAsVMDir, "as-vm-dir", "", "Directory to build the --as-vm disk image in, which needs room for the whole root filesystem (defaults to a temporary directory)"+"``")
+	cmd.Flags().StringVar(&c.flagRemote, "remote", "", "Name of a saved target server to use (see \"incus-migrate remote\")"+"``")
 
 	return cmd
 }
@@ -67,235 +235,168 @@ type cmdMigrateData struct {
 	CustomVolumeArgs api.StorageVolumesPost
 	Pool             string
 	Project          string
+	Netplan          string
+	MountVolumes     []mountVolume
+	Excludes         []string
+	Resume           bool
+	Disks            []diskVolume
+	Target           string
+
+	// Number of times a failed transfer is still retried (see retryTransfer).
+	RetriesLeft int
+
+	// Read-only btrfs subvolumes sent rather than the files of the source (see --btrfs-send).
+	BtrfsVolume string
+	BtrfsRootfs string
 }
 
 func (c *cmdMigrateData) renderInstance() string {
 	data := struct {
 		Name         string            `yaml:"Name"`
+		Description  string            `yaml:"Description,omitempty"`
 		Project      string            `yaml:"Project"`
+		Target       string            `yaml:"Cluster member,omitempty"`
 		Type         api.InstanceType  `yaml:"Type"`
+		Architecture string            `yaml:"Architecture,omitempty"`
+		Firmware     string            `yaml:"Firmware,omitempty"`
 		Source       string            `yaml:"Source"`
 		SourceFormat string            `yaml:"Source format,omitempty"`
 		Mounts       []string          `yaml:"Mounts,omitempty"`
+		MountVolumes map[string]string `yaml:"Mount volumes,omitempty"`
+		Excludes     []string          `yaml:"Excluded paths,omitempty"`
+		Disks        []string          `yaml:"Additional disks,omitempty"`
 		Profiles     []string          `yaml:"Profiles,omitempty"`
 		StoragePool  string            `yaml:"Storage pool,omitempty"`
 		StorageSize  string            `yaml:"Storage pool size,omitempty"`
+		StateSize    string            `yaml:"Storage state size,omitempty"`
+		FSType       string            `yaml:"Storage filesystem,omitempty"`
 		Network      string            `yaml:"Network name,omitempty"`
+		Networks     map[string]string `yaml:"Additional networks,omitempty"`
+		Limits       map[string]string `yaml:"Resource limits,omitempty"`
 		Config       map[string]string `yaml:"Config,omitempty"`
 	}{
 		c.InstanceArgs.Name,
+		c.InstanceArgs.Description,
 		c.Project,
+		c.Target,
 		c.InstanceArgs.Type,
+		c.InstanceArgs.Architecture,
+		"",
 		c.SourcePath,
 		c.SourceFormat,
 		c.Mounts,
+		nil,
+		c.Excludes,
+		nil,
 		c.InstanceArgs.Profiles,
 		"",
 		"",
 		"",
+		"",
+		"",
+		nil,
+		nil,
 		c.InstanceArgs.Config,
 	}
 
-	disk, ok := c.InstanceArgs.Devices["root"]
-	if ok {
-		data.StoragePool = disk["pool"]
-
-		size, ok := disk["size"]
-		if ok {
-			data.StorageSize = size
+	if c.InstanceArgs.Type == api.InstanceTypeVM {
+		if util.IsTrue(c.InstanceArgs.Config["security.csm"]) {
+			data.Firmware = firmwareBIOS
+		} else if util.IsFalse(c.InstanceArgs.Config["security.secureboot"]) {
+			data.Firmware = firmwareUEFI
+		} else {
+			data.Firmware = firmwareUEFISecureBoot
 		}
 	}
 
-	network, ok := c.InstanceArgs.Devices["eth0"]
-	if ok {
-		data.Network = network["parent"]
-	}
-
-	out, err := yaml.Marshal(&data)
-	if err != nil {
-		return ""
+	for _, disk := range c.Disks {
+		data.Disks = append(data.Disks, disk.Source)
 	}
 
-	return string(out)
-}
-
-func (c *cmdMigrateData) renderCustomVolume() string {
-	data := struct {
-		Name         string `yaml:"Name"`
-		Project      string `yaml:"Project"`
-		Type         string `yaml:"Type"`
-		Source       string `yaml:"Source"`
-		SourceFormat string `yaml:"Source format,omitempty"`
-	}{
-		c.CustomVolumeArgs.Name,
-		c.Project,
-		c.CustomVolumeArgs.ContentType,
-		c.SourcePath,
-		c.SourceFormat,
+	// No profiles at all isn't the same as the default one.
+	if c.InstanceArgs.Profiles != nil && len(c.InstanceArgs.Profiles) == 0 {
+		data.Profiles = []string{"(none)"}
 	}
 
-	out, err := yaml.Marshal(&data)
-	if err != nil {
-		return ""
+	if len(c.MountVolumes) > 0 {
+		data.MountVolumes = map[string]string{}
+		for _, volume := range c.MountVolumes {
+			data.MountVolumes[volume.Path] = fmt.Sprintf("%s (pool %q, volume %q)", volume.Source, volume.Pool, volume.Name)
+		}
 	}
 
-	return string(out)
-}
-
-func (c *cmdMigrate) askServer() (incus.InstanceServer, string, error) {
-	// Detect local server.
-	local, err := c.connectLocal()
-	if err == nil {
-		useLocal, err := c.global.asker.AskBool("The local Incus server is the target [default=yes]: ", "yes")
-		if err != nil {
-			return nil, "", err
-		}
+	disk, ok := c.InstanceArgs.Devices["root"]
+	if ok {
+		data.StoragePool = disk["pool"]
 
-		if useLocal {
-			return local, "", nil
+		size, ok := disk["size"]
+		if ok {
+			data.StorageSize = size
 		}
 	}
 
-	// Server address
-	serverURL, err := c.global.asker.AskString("Please provide Incus server URL: ", "", nil)
-	if err != nil {
-		return nil, "", err
-	}
-
-	serverURL, err = parseURL(serverURL)
-	if err != nil {
-		return nil, "", err
+	for _, device := range c.InstanceArgs.Devices {
+		if device["type"] == "disk" && device["path"] == "/" {
+			data.StateSize = device["size.state"]
+			data.FSType = device["initial.block.filesystem"]
+		}
 	}
 
-	args := incus.ConnectionArgs{
-		UserAgent: fmt.Sprintf("LXC-MIGRATE %s", version.Version),
+	network, ok := c.InstanceArgs.Devices["eth0"]
+	if ok {
+		if network["type"] == "none" {
+			data.Network = "none"
+		} else {
+			data.Network = nicDescription(network)
+		}
 	}
 
-	// Attempt to connect
-	server, err := incus.ConnectIncus(serverURL, &args)
-	if err != nil {
-		// Failed to connect using the system CA, so retrieve the remote certificate.
-		certificate, err := localtls.GetRemoteCertificate(serverURL, args.UserAgent)
-		if err != nil {
-			return nil, "", fmt.Errorf("Failed to get remote certificate: %w", err)
+	for name, device := range c.InstanceArgs.Devices {
+		if name == "eth0" || device["type"] != "nic" {
+			continue
 		}
 
-		digest := localtls.CertFingerprint(certificate)
+		if data.Networks == nil {
+			data.Networks = map[string]string{}
+		}
 
-		fmt.Println("Certificate fingerprint:", digest)
-		fmt.Print("ok (y/n)? ")
+		data.Networks[name] = nicDescription(device)
+	}
 
-		buf := bufio.NewReader(os.Stdin)
-		line, _, err := buf.ReadLine()
-		if err != nil {
-			return nil, "", err
+	// The resource limits are listed on their own rather than along with the other keys.
+	for _, key := range instanceLimitKeys {
+		value, ok := c.InstanceArgs.Config[key]
+		if !ok {
+			continue
 		}
 
-		if len(line) < 1 || line[0] != 'y' && line[0] != 'Y' {
-			return nil, "", fmt.Errorf("Server certificate rejected by user")
+		if data.Limits == nil {
+			data.Limits = map[string]string{}
+			data.Config = maps.Clone(c.InstanceArgs.Config)
 		}
 
-		args.InsecureSkipVerify = true
-		server, err = incus.ConnectIncus(serverURL, &args)
-		if err != nil {
-			return nil, "", fmt.Errorf("Failed to connect to server: %w", err)
-		}
+		data.Limits[key] = value
+		delete(data.Config, key)
 	}
 
-	apiServer, _, err := server.GetServer()
+	out, err := yaml.Marshal(&data)
 	if err != nil {
-		return nil, "", fmt.Errorf("Failed to get server: %w", err)
-	}
-
-	fmt.Println("")
-
-	type AuthMethod int
-
-	const (
-		authMethodTLSCertificate AuthMethod = iota
-		authMethodTLSTemporaryCertificate
-		authMethodTLSCertificateToken
-	)
-
-	// TLS is always available
-	var availableAuthMethods []AuthMethod
-	var authMethod AuthMethod
-
-	i := 1
-
-	if slices.Contains(apiServer.AuthMethods, api.AuthenticationMethodTLS) {
-		fmt.Printf("%d) Use a certificate token\n", i)
-		availableAuthMethods = append(availableAuthMethods, authMethodTLSCertificateToken)
-		i++
-		fmt.Printf("%d) Use an existing TLS authentication certificate\n", i)
-		availableAuthMethods = append(availableAuthMethods, authMethodTLSCertificate)
-		i++
-		fmt.Printf("%d) Generate a temporary TLS authentication certificate\n", i)
-		availableAuthMethods = append(availableAuthMethods, authMethodTLSTemporaryCertificate)
-	}
-
-	if len(apiServer.AuthMethods) > 1 || slices.Contains(apiServer.AuthMethods, api.AuthenticationMethodTLS) {
-		authMethodInt, err := c.global.asker.AskInt("Please pick an authentication mechanism above: ", 1, int64(i), "", nil)
-		if err != nil {
-			return nil, "", err
-		}
-
-		authMethod = availableAuthMethods[authMethodInt-1]
+		return ""
 	}
 
-	var certPath string
-	var keyPath string
-	var token string
-
-	switch authMethod {
-	case authMethodTLSCertificate:
-		certPath, err = c.global.asker.AskString("Please provide the certificate path: ", "", func(path string) error {
-			if !util.PathExists(path) {
-				return errors.New("File does not exist")
-			}
-
-			return nil
-		})
-		if err != nil {
-			return nil, "", err
-		}
-
-		keyPath, err = c.global.asker.AskString("Please provide the keyfile path: ", "", func(path string) error {
-			if !util.PathExists(path) {
-				return errors.New("File does not exist")
-			}
-
-			return nil
-		})
-		if err != nil {
-			return nil, "", err
-		}
-
-	case authMethodTLSCertificateToken:
-		token, err = c.global.asker.AskString("Please provide the certificate token: ", "", func(token string) error {
-			_, err := localtls.CertificateTokenDecode(token)
-			if err != nil {
-				return err
-			}
-
-			return nil
-		})
-		if err != nil {
-			return nil, "", err
-		}
+	return string(out)
+}
 
-	case authMethodTLSTemporaryCertificate:
-		// Intentionally ignored
+// printWarnings prints the provided warnings below the rendered configuration.
+func printWarnings(out io.Writer, warnings []string) {
+	if len(warnings) == 0 {
+		return
 	}
 
-	var authType string
-
-	switch authMethod {
-	case authMethodTLSCertificate, authMethodTLSTemporaryCertificate, authMethodTLSCertificateToken:
-		authType = api.AuthenticationMethodTLS
+	fmt.Fprintln(out, "\nWarnings:")
+	for _, warning := range warnings {
+		fmt.Fprintf(out, "  - %s\n", warning)
 	}
-
-	return c.connectTarget(serverURL, certPath, keyPath, authType, token)
 }
 
 func (c *cmdMigrate) gatherInstanceInfo(server incus.InstanceServer, migrationType MigrationType) (cmdMigrateData, error) {
@@ -326,28 +427,31 @@ func (c *cmdMigrate) gatherInstanceInfo(server incus.InstanceServer, migrationTy
 	}
 
 	if config.Project != "" {
-		server = server.UseProject(config.Project)
+		server = c.useProject(server, config.Project)
+	}
+
+	err = checkTargetResources(server, !c.flagNetworkNone)
+	if err != nil {
+		return cmdMigrateData{}, err
+	}
+
+	// Cluster member
+	err = c.askTarget(server, &config)
+	if err != nil {
+		return cmdMigrateData{}, err
 	}
 
 	// Instance name
-	instanceNames, err := server.GetInstanceNames(api.InstanceTypeAny)
+	err = c.askInstanceName(server, &config)
 	if err != nil {
 		return cmdMigrateData{}, err
 	}
 
-	for {
-		instanceName, err := c.global.asker.AskString("Name of the new instance: ", "", nil)
+	if c.preseed != nil {
+		err = c.applyPreseedProfiles(server, &config)
 		if err != nil {
 			return cmdMigrateData{}, err
 		}
-
-		if slices.Contains(instanceNames, instanceName) {
-			fmt.Printf("Instance %q already exists\n", instanceName)
-			continue
-		}
-
-		config.InstanceArgs.Name = instanceName
-		break
 	}
 
 	// Provide source path
@@ -356,93 +460,158 @@ func (c *cmdMigrate) gatherInstanceInfo(server incus.InstanceServer, migrationTy
 		return cmdMigrateData{}, err
 	}
 
+	// Labels
+	err = c.applyLabels(&config)
+	if err != nil {
+		return cmdMigrateData{}, err
+	}
+
+	// Instance options
+	err = c.applyInstanceOptions(&config, migrationType)
+	if err != nil {
+		return cmdMigrateData{}, err
+	}
+
+	var warnings []string
+
+	// Virtual machine firmware and source checks
 	if config.InstanceArgs.Type == api.InstanceTypeVM {
-		architectureName, _ := osarch.ArchitectureGetLocal()
+		warnings, err = c.askVMOptions(&config)
+		if err != nil {
+			return cmdMigrateData{}, err
+		}
+	}
 
-		if slices.Contains([]string{"x86_64", "aarch64"}, architectureName) {
-			hasUEFI, err := c.global.asker.AskBool("Does the VM support UEFI booting? [default=yes]: ", "yes")
-			if err != nil {
-				return cmdMigrateData{}, err
-			}
+	// Additional disks
+	err = c.askDisks(&config)
+	if err != nil {
+		return cmdMigrateData{}, err
+	}
 
-			if hasUEFI {
-				hasSecureBoot, err := c.global.asker.AskBool("Does the VM support UEFI Secure Boot? [default=yes]: ", "yes")
-				if err != nil {
-					return cmdMigrateData{}, err
-				}
+	if c.flagFirmware != "" && config.InstanceArgs.Type != api.InstanceTypeVM {
+		return cmdMigrateData{}, errors.New("A firmware can only be set for virtual machines")
+	}
 
-				if !hasSecureBoot {
-					config.InstanceArgs.Config["security.secureboot"] = "false"
-				}
-			} else {
-				config.InstanceArgs.Config["security.csm"] = "true"
-				config.InstanceArgs.Config["security.secureboot"] = "false"
-			}
+	// State size
+	err = c.applyStateSize(server, &config)
+	if err != nil {
+		return cmdMigrateData{}, err
+	}
+
+	// Network
+	if c.flagNetworkNone {
+		err = c.removeNetwork(server, &config)
+		if err != nil {
+			return cmdMigrateData{}, err
 		}
 	}
 
-	var mounts []string
+	// Paths left out of the transfer
+	if len(c.flagExclude) > 0 {
+		if config.InstanceArgs.Type != api.InstanceTypeContainer {
+			return cmdMigrateData{}, errors.New("Paths can only be excluded from containers")
+		}
+
+		config.Excludes = slices.Clone(c.flagExclude)
+	}
+
+	if c.preseed != nil {
+		config.Excludes = append(config.Excludes, c.preseed.Exclude...)
+	}
 
-	// Additional mounts for containers
+	// Additional mounts and excluded paths
 	if config.InstanceArgs.Type == api.InstanceTypeContainer {
-		addMounts, err := c.global.asker.AskBool("Do you want to add additional filesystem mounts? [default=no]: ", "no")
+		err = c.askContainerMounts(&config)
 		if err != nil {
 			return cmdMigrateData{}, err
 		}
+	}
+
+	// Mounts excluded by filesystem type
+	err = c.excludeFSTypes(&config)
+	if err != nil {
+		return cmdMigrateData{}, err
+	}
+
+	// Mounts transferred to their own volumes
+	err = c.applyMountPools(server, &config)
+	if err != nil {
+		return cmdMigrateData{}, err
+	}
 
-		if addMounts {
-			for {
-				path, err := c.global.asker.AskString("Please provide a path the filesystem mount path [empty value to continue]: ", "", func(s string) error {
-					if s != "" {
-						if util.PathExists(s) {
-							return nil
-						}
+	// Source features which won't survive the migration
+	if config.InstanceArgs.Type == api.InstanceTypeContainer {
+		warnings = c.sourceWarnings(&config)
+	}
 
-						return errors.New("Path does not exist")
-					}
+	// Root disk filesystem
+	fsWarnings, err := c.applyFSType(server, &config)
+	if err != nil {
+		return cmdMigrateData{}, err
+	}
 
-					return nil
-				})
-				if err != nil {
-					return cmdMigrateData{}, err
-				}
+	warnings = append(warnings, fsWarnings...)
 
-				if path == "" {
-					break
-				}
+	// Nothing left to ask with a --config file.
+	if c.preseed != nil {
+		err = c.applyPreseedStorage(server, &config)
+		if err != nil {
+			return cmdMigrateData{}, err
+		}
 
-				mounts = append(mounts, path)
-			}
+		if c.preseed.Description != "" {
+			config.InstanceArgs.Description = c.preseed.Description
+		}
 
-			config.Mounts = append(config.Mounts, mounts...)
+		err = c.applyDisks(server, &config)
+		if err != nil {
+			return cmdMigrateData{}, err
 		}
+
+		c.printInstance(&config, warnings)
+
+		return config, nil
 	}
 
 	for {
-		fmt.Println("\nInstance to be created:")
+		c.printInstance(&config, warnings)
 
-		scanner := bufio.NewScanner(strings.NewReader(config.renderInstance()))
-		for scanner.Scan() {
-			fmt.Printf("  %s\n", scanner.Text())
-		}
-
-		fmt.Print(`
+		fmt.Fprint(c.out, `
 Additional overrides can be applied at this stage:
 1) Begin the migration with the above configuration
 2) Override profile list
 3) Set additional configuration options
 4) Change instance storage pool or volume size
 5) Change instance network
+6) Remove instance network
+7) Change instance description
+8) Change transfer bandwidth limit
+9) Set resource limits (CPU and memory)
 
 `)
 
-		choice, err := c.global.asker.AskInt("Please pick one of the options above [default=1]: ", 1, 5, "1", nil)
+		choice, err := c.global.asker.AskInt("Please pick one of the options above [default=1]: ", 1, 9, "1", nil)
 		if err != nil {
 			return cmdMigrateData{}, err
 		}
 
 		switch choice {
 		case 1:
+			// Make sure that profile changes didn't bring back a network device.
+			network, ok := config.InstanceArgs.Devices["eth0"]
+			if ok && network["type"] == "none" {
+				err = c.removeNetwork(server, &config)
+				if err != nil {
+					return cmdMigrateData{}, err
+				}
+			}
+
+			// The additional disks go to the storage pool of the root disk.
+			err = c.applyDisks(server, &config)
+			if err != nil {
+				return cmdMigrateData{}, err
+			}
+
 			return config, nil
 		case 2:
 			err = c.askProfiles(server, &config)
@@ -452,121 +621,173 @@ Additional overrides can be applied at this stage:
 			err = c.askStorage(server, &config)
 		case 5:
 			err = c.askNetwork(server, &config)
+		case 6:
+			err = c.removeNetwork(server, &config)
+		case 7:
+			config.InstanceArgs.Description, err = c.global.asker.AskString("Please provide the instance description [empty for none]: ", "", func(string) error { return nil })
+		case 8:
+			err = c.askBWLimit()
+		case 9:
+			err = c.askLimits(&config)
 		}
 
 		if err != nil {
-			fmt.Println(err)
+			fmt.Fprintln(c.out, err)
 		}
 	}
 }
 
-func (c *cmdMigrate) gatherCustomVolumeInfo(server incus.InstanceServer, migrationType MigrationType) (cmdMigrateData, error) {
-	var err error
+// askInstanceName asks for the name of the new instance, offering to resume a partial migration
+// when an instance of that name exists already.
+func (c *cmdMigrate) askInstanceName(server incus.InstanceServer, config *cmdMigrateData) error {
+	instanceNames, err := server.GetInstanceNames(api.InstanceTypeAny)
+	if err != nil {
+		return err
+	}
 
-	config := cmdMigrateData{}
+	for c.preseed == nil {
+		instanceName, err := c.global.asker.AskString("Name of the new instance: ", "", nil)
+		if err != nil {
+			return err
+		}
 
-	config.CustomVolumeArgs = api.StorageVolumesPost{
-		Type: "custom",
-		Source: api.StorageVolumeSource{
-			Type: "migration",
-			Mode: "push",
-		},
-	}
+		if slices.Contains(instanceNames, instanceName) {
+			config.Resume, err = c.askResumeInstance(server, instanceName, config.InstanceArgs.Type)
+			if err != nil {
+				return err
+			}
 
-	if migrationType == MigrationTypeVolumeFilesystem {
-		config.CustomVolumeArgs.ContentType = "filesystem"
-	} else {
-		config.CustomVolumeArgs.ContentType = "block"
-	}
+			if !config.Resume {
+				fmt.Fprintf(c.out, "Instance %q already exists\n", instanceName)
+				continue
+			}
+		}
 
-	// Project
-	err = c.askProject(server, &config)
-	if err != nil {
-		return cmdMigrateData{}, err
+		config.InstanceArgs.Name = instanceName
+		break
 	}
 
-	if config.Project != "" {
-		server = server.UseProject(config.Project)
-	}
+	if c.preseed != nil {
+		if slices.Contains(instanceNames, c.preseed.Name) {
+			config.Resume, err = c.askResumeInstance(server, c.preseed.Name, config.InstanceArgs.Type)
+			if err != nil {
+				return err
+			}
 
-	// Pool
-	pools, err := server.GetStoragePools()
-	if err != nil {
-		return cmdMigrateData{}, err
-	}
+			if !config.Resume {
+				return fmt.Errorf("Instance %q already exists", c.preseed.Name)
+			}
+		}
 
-	poolNames := []string{}
-	for _, p := range pools {
-		poolNames = append(poolNames, p.Name)
+		config.InstanceArgs.Name = c.preseed.Name
 	}
 
-	for {
-		poolName, err := c.global.asker.AskString("Name of the pool: ", "", nil)
+	return nil
+}
+
+// applyInstanceOptions applies the instance options given as flags, along with the facts and
+// identifiers gathered from the source.
+func (c *cmdMigrate) applyInstanceOptions(config *cmdMigrateData, migrationType MigrationType) error {
+	var err error
+
+	// Hardware facts
+	if c.flagCaptureFacts {
+		facts, err := sourceFacts(config.SourcePath)
 		if err != nil {
-			return cmdMigrateData{}, err
+			return fmt.Errorf("Failed to gather hardware facts: %w", err)
 		}
 
-		if !slices.Contains(poolNames, poolName) {
-			fmt.Printf("Pool %q doesn't exists\n", poolName)
-			continue
-		}
+		maps.Copy(config.InstanceArgs.Config, facts)
+	}
 
-		config.Pool = poolName
-		break
+	// Instance description
+	if c.flagDescription != "" {
+		config.InstanceArgs.Description = c.flagDescription
+	} else if c.flagDescriptionSource {
+		config.InstanceArgs.Description = sourceDescription(config.SourcePath, migrationType)
 	}
 
-	// Custom volume name
-	volumes, err := server.GetStoragePoolVolumes(config.Pool)
+	// Instance architecture
+	config.InstanceArgs.Architecture, err = c.instanceArchitecture()
 	if err != nil {
-		return cmdMigrateData{}, err
+		return err
 	}
 
-	volumeNames := []string{}
-	for _, v := range volumes {
-		if v.Type != "custom" {
-			continue
-		}
-
-		volumeNames = append(volumeNames, v.Name)
+	// CPU scheduling
+	err = c.applyCPULimits(config)
+	if err != nil {
+		return err
 	}
 
-	for {
-		volumeName, err := c.global.asker.AskString("Name of the new custom volume: ", "", nil)
+	// Network configuration
+	if c.flagNetplan != "" {
+		netplan, err := readNetplan(c.flagNetplan)
 		if err != nil {
-			return cmdMigrateData{}, err
+			return err
 		}
 
-		if slices.Contains(volumeNames, volumeName) {
-			fmt.Printf("Storage volume %q already exists\n", volumeName)
-			continue
+		if config.InstanceArgs.Type == api.InstanceTypeVM {
+			config.InstanceArgs.Config["cloud-init.network-config"] = netplan
+		} else {
+			config.Netplan = netplan
 		}
+	}
 
-		config.CustomVolumeArgs.Name = volumeName
-		break
+	// Extra AppArmor rules
+	if c.flagRawAppArmor != "" {
+		config.InstanceArgs.Config["raw.apparmor"] = c.flagRawAppArmor
 	}
 
-	err = c.askSourcePath(&config, migrationType)
+	// Container idmap (the server shifts the transferred files on first start)
+	err = c.applyIdmap(config)
 	if err != nil {
-		return cmdMigrateData{}, err
+		return err
 	}
 
-	fmt.Println("\nCustom volume to be created:")
+	// Identifiers preserved from the source
+	err = c.applyVolatile(config)
+	if err != nil {
+		return err
+	}
 
-	scanner := bufio.NewScanner(strings.NewReader(config.renderCustomVolume()))
-	for scanner.Scan() {
-		fmt.Printf("  %s\n", scanner.Text())
+	return nil
+}
+
+// sourceWarnings returns the features of the source of a container which won't survive the
+// migration or change behavior in the new instance.
+func (c *cmdMigrate) sourceWarnings(config *cmdMigrateData) []string {
+	// Check for source filesystem features which won't survive the transfer.
+	warnings := sourceFilesystemWarnings(append([]string{config.SourcePath}, config.Mounts...))
+
+	// Scheduled jobs start running as soon as the new instance boots.
+	cronJobs, timers := sourceScheduledJobs(config.SourcePath)
+
+	outcome := "will run in the new instance (use --disable-timers to prevent it)"
+	if c.flagDisableTimers {
+		outcome = "will be masked in the new instance"
 	}
 
-	shouldMigrate, err := c.global.asker.AskBool("Do you want to continue? [default=yes]: ", "yes")
-	if err != nil {
-		return cmdMigrateData{}, err
+	if len(cronJobs) > 0 {
+		warnings = append(warnings, fmt.Sprintf("Cron jobs %s: %s", outcome, strings.Join(cronJobs, ", ")))
 	}
 
-	if !shouldMigrate {
-		return cmdMigrateData{}, nil
+	if len(timers) > 0 {
+		warnings = append(warnings, fmt.Sprintf("Systemd timers %s: %s", outcome, strings.Join(timers, ", ")))
+	}
+
+	return warnings
+}
+
+// printInstance prints the instance to be created along with the warnings about it.
+func (c *cmdMigrate) printInstance(config *cmdMigrateData, warnings []string) {
+	fmt.Fprintln(c.out, "\nInstance to be created:")
+
+	scanner := bufio.NewScanner(strings.NewReader(config.renderInstance()))
+	for scanner.Scan() {
+		fmt.Fprintf(c.out, "  %s\n", scanner.Text())
 	}
 
-	return config, nil
+	printWarnings(c.out, warnings)
 }
 
 func (c *cmdMigrate) migrateInstance(ctx context.Context, server incus.InstanceServer, migrationType MigrationType) error {
@@ -574,106 +795,282 @@ func (c *cmdMigrate) migrateInstance(ctx context.Context, server incus.InstanceS
 		return fmt.Errorf("Wrong migration type for migrateInstance")
 	}
 
+	if c.flagFinalChecksumPass && migrationType != MigrationTypeContainer {
+		return errors.New("The final checksum pass is only supported for containers")
+	}
+
+	if c.flagRsyncSSH != "" && migrationType != MigrationTypeContainer {
+		return errors.New("Only the files of containers can be sent over SSH (--rsync-ssh)")
+	}
+
+	if c.flagBtrfsSend && migrationType != MigrationTypeContainer {
+		return errors.New("Only containers can be sent as btrfs send streams (--btrfs-send)")
+	}
+
+	if c.flagVolumeSize != "" {
+		return errors.New("A volume size can only be provided for custom volumes")
+	}
+
+	afterCreateConfig, err := parseKeyValues(c.flagAfterCreateConfig)
+	if err != nil {
+		return err
+	}
+
 	config, err := c.gatherInstanceInfo(server, migrationType)
 	if err != nil {
 		return err
 	}
 
-	return c.runMigration(ctx, server, &config, migrationType, func(ctx context.Context, server incus.InstanceServer, config *cmdMigrateData, path string, migrationType MigrationType) error {
-		// System architecture
-		architectureName, err := osarch.ArchitectureGetLocal()
-		if err != nil {
-			return err
+	err = c.runMigration(ctx, server, &config, migrationType, func(ctx context.Context, server incus.InstanceServer, config *cmdMigrateData, path string, migrationType MigrationType) (err error) {
+		reverter := revert.New()
+		defer func() {
+			if err != nil && c.keepPartial(ctx, config, err) {
+				reverter.Success()
+			}
+
+			reverter.Fail()
+		}()
+
+		// Transfer the mounts which get their own volume, the instance refers to those.
+		for _, volume := range config.MountVolumes {
+			err := c.transferMountVolume(ctx, server, reverter, volume, config.Resume)
+			if err != nil {
+				return err
+			}
 		}
 
-		config.InstanceArgs.Architecture = architectureName
+		// Transfer the additional disks, the instance refers to those.
+		for _, disk := range config.Disks {
+			err := c.transferDiskVolume(ctx, server, reverter, disk, config.Resume)
+			if err != nil {
+				return err
+			}
+		}
 
-		reverter := revert.New()
-		defer reverter.Fail()
+		transferArgs := c.sourceTransferArgs(config)
+		transferArgs.Excludes = config.Excludes
+
+		// Files modified past this point may differ from what was sent, they can't be verified.
+		transferStart := time.Now()
+
+		progress := cli.ProgressRenderer{Format: "Transferring instance: %s", Quiet: c.flagFormat == "json"}
+
+		if c.flagRsyncSSH != "" {
+			err = c.sendInstanceSSH(ctx, server, reverter, config, path, transferArgs)
+		} else {
+			// Create the instance, or refresh the one left behind by an interrupted migration.
+			args := config.InstanceArgs
+			args.Config = maps.Clone(args.Config)
+			args.Config[migratePartialKey] = "true"
+			args.Source.Refresh = config.Resume
+
+			// The transfer error is checked below, along with the one of the transfer over SSH.
+			var op incus.Operation
+
+			op, err = server.CreateInstance(args)
+			if err != nil {
+				return err
+			}
+
+			reverter.Add(func() {
+				_, _ = server.DeleteInstance(config.InstanceArgs.Name)
+			})
+
+			_, err = op.AddHandler(progress.UpdateOp)
+			if err != nil {
+				progress.Done("")
+				return err
+			}
+
+			if c.progress != nil {
+				_, _ = op.AddHandler(c.progress.updateOp)
+			}
+
+			err = transferRootfs(ctx, op, path, transferArgs, migrationType)
+		}
 
-		// Create the instance
-		op, err := server.CreateInstance(config.InstanceArgs)
 		if err != nil {
-			return err
+			// The server deletes instances whose migration failed, only those created empty remain.
+			_, _, getErr := server.GetInstance(config.InstanceArgs.Name)
+			if c.flagResume && getErr == nil {
+				fmt.Fprintf(c.out, "The partially transferred instance %q was kept, run again with --resume to continue the transfer\n", config.InstanceArgs.Name)
+			}
+
+			return transferError{err}
 		}
 
-		reverter.Add(func() {
-			_, _ = server.DeleteInstance(config.InstanceArgs.Name)
-		})
+		if c.flagVerify && migrationType == MigrationTypeContainer {
+			err = verifyContainerFiles(c.out, server, config.InstanceArgs.Name, path, config.Excludes, transferStart)
+			if err != nil {
+				progress.Done("")
+				return err
+			}
+		}
 
-		progress := cli.ProgressRenderer{Format: "Transferring instance: %s"}
-		_, err = op.AddHandler(progress.UpdateOp)
-		if err != nil {
-			progress.Done("")
-			return err
+		if len(afterCreateConfig) > 0 {
+			err = applyAfterCreateConfig(server, config.InstanceArgs.Name, afterCreateConfig)
+			if err != nil {
+				progress.Done("")
+				return err
+			}
 		}
 
-		err = transferRootfs(ctx, op, path, c.flagRsyncArgs, migrationType)
+		err = clearInstancePartial(server, config.InstanceArgs.Name)
 		if err != nil {
+			progress.Done("")
 			return err
 		}
 
 		progress.Done(fmt.Sprintf("Instance %s successfully created", config.InstanceArgs.Name))
 		reverter.Success()
 
+		for _, key := range slices.Sorted(maps.Keys(afterCreateConfig)) {
+			fmt.Fprintf(c.out, "Applied %s=%q after creation\n", key, afterCreateConfig[key])
+		}
+
+		c.finishInstance(server, config, path, migrationType)
+
 		return nil
 	})
+	if err != nil {
+		return err
+	}
+
+	c.printResult(server, &config, migrationType)
+
+	return nil
 }
 
-func (c *cmdMigrate) migrateCustomVolume(ctx context.Context, server incus.InstanceServer, migrationType MigrationType) error {
-	if migrationType != MigrationTypeVolumeBlock && migrationType != MigrationTypeVolumeFilesystem {
-		return fmt.Errorf("Wrong migration type for migrateCustomVolume")
+// sourceTransferArgs returns the transfer options of the main source, which is read directly
+// rather than through root.img when it's a stream.
+func (c *cmdMigrate) sourceTransferArgs(config *cmdMigrateData) transferArgs {
+	args := c.transferArgs()
+
+	if isStream(config.SourcePath) {
+		args.BlockSource = config.SourcePath
+
+		// Validated in run, streams can't be used without a size.
+		args.BlockSize, _ = units.ParseByteSizeString(c.flagSourceSize)
 	}
 
-	config, err := c.gatherCustomVolumeInfo(server, migrationType)
-	if err != nil {
-		return err
+	args.BtrfsVolume = config.BtrfsVolume
+	args.BtrfsRootfs = config.BtrfsRootfs
+
+	return args
+}
+
+// btrfsSendUnsupported returns why the source of a container can't be sent as a btrfs send
+// stream, the subvolume holding its whole root filesystem being sent as is.
+func (c *cmdMigrate) btrfsSendUnsupported(config *cmdMigrateData) string {
+	if len(config.Mounts) != 1 || len(config.MountVolumes) > 0 {
+		return "it's made of multiple mounts"
 	}
 
-	// User decided not to migrate.
-	if config.CustomVolumeArgs.Name == "" {
-		return nil
+	if len(config.Excludes) > 0 {
+		return "paths are excluded"
 	}
 
-	return c.runMigration(ctx, server, &config, migrationType, func(ctx context.Context, server incus.InstanceServer, config *cmdMigrateData, path string, migrationType MigrationType) error {
-		reverter := revert.New()
-		defer reverter.Fail()
+	if c.flagReadOnlySource {
+		return "--readonly-source is set"
+	}
 
-		// Create the custom volume
-		op, err := server.CreateStoragePoolVolumeFromMigration(config.Pool, config.CustomVolumeArgs)
-		if err != nil {
-			return err
-		}
+	if config.Resume {
+		return "resuming a migration"
+	}
 
-		reverter.Add(func() {
-			_ = server.DeleteStoragePoolVolume(config.Pool, "custom", config.CustomVolumeArgs.Name)
-		})
+	return ""
+}
 
-		progress := cli.ProgressRenderer{Format: "Transferring custom volume: %s"}
-		_, err = op.AddHandler(progress.UpdateOp)
-		if err != nil {
-			progress.Done("")
-			return err
+// transferArgs returns the transfer options set through the command line.
+func (c *cmdMigrate) transferArgs() transferArgs {
+	args := transferArgs{
+		RsyncArgs:  c.flagRsyncArgs,
+		IOPriority: c.flagIOPriority,
+		Verbose:    c.flagVerbose,
+		Output:     c.out,
+	}
+
+	// Validated in run.
+	if c.flagStallTimeout != "" {
+		args.StallTimeout, _ = time.ParseDuration(c.flagStallTimeout)
+	}
+
+	if c.flagBWLimit != "" {
+		args.BandwidthLimit, _ = units.ParseByteSizeString(c.flagBWLimit)
+	}
+
+	if c.flagCompress != "" {
+		args.Compression, args.CompressionLevel, _ = parseCompression(c.flagCompress)
+	}
+
+	// The checkpoint and progress reporter only exist once the migration starts.
+	args.BytesSent = func(n int64) {
+		if c.checkpoint != nil {
+			c.checkpoint.addBytes(n)
 		}
 
-		err = transferRootfs(ctx, op, path, c.flagRsyncArgs, migrationType)
-		if err != nil {
-			return err
+		c.progress.addBytes(n)
+	}
+
+	return args
+}
+
+// notify tells the operator that the migration is over when --notify is set, through a
+// desktop notification if possible or the terminal bell otherwise.
+func (c *cmdMigrate) notify(err error) {
+	if !c.flagNotify {
+		return
+	}
+
+	name := "the source"
+	if c.checkpoint != nil {
+		name = c.checkpoint.Name
+	}
+
+	summary := fmt.Sprintf("Migration of %s completed", name)
+	body := "The migration completed successfully"
+	urgency := "normal"
+
+	if err != nil {
+		summary = fmt.Sprintf("Migration of %s failed", name)
+		body = err.Error()
+		urgency = "critical"
+	}
+
+	if checkCommand("notify-send") == nil {
+		_, err := subprocess.RunCommand("notify-send", "--app-name", "incus-migrate", "--urgency", urgency, summary, body)
+		if err == nil {
+			return
 		}
+	}
 
-		progress.Done(fmt.Sprintf("Custom volume %s successfully created", config.CustomVolumeArgs.Name))
-		reverter.Success()
+	fmt.Fprint(c.out, "\a")
+}
 
-		return nil
-	})
+// setPhase records the current phase of the migration in the checkpoint and progress report.
+func (c *cmdMigrate) setPhase(phase string) {
+	c.checkpoint.setPhase(phase)
+	c.progress.setPhase(phase)
 }
 
 func (c *cmdMigrate) runMigration(ctx context.Context, server incus.InstanceServer, config *cmdMigrateData, migrationType MigrationType, migrationHandler func(ctx context.Context, server incus.InstanceServer, config *cmdMigrateData, path string, migrationType MigrationType) error) error {
 	if config.Project != "" {
-		server = server.UseProject(config.Project)
+		server = c.useProject(server, config.Project)
+	}
+
+	// Additional targets are separate servers, the cluster member only applies to this one.
+	if config.Target != "" {
+		server = server.UseTarget(config.Target)
+	}
+
+	// Catch problems with the additional targets before transferring anything.
+	err := c.checkAdditionalTargets(config, migrationType)
+	if err != nil {
+		return err
 	}
 
 	config.Mounts = append(config.Mounts, config.SourcePath)
+	rootMount := config.SourcePath
 
 	// Get and sort the mounts
 	sort.Strings(config.Mounts)
@@ -683,7 +1080,7 @@ func (c *cmdMigrate) runMigration(ctx context.Context, server incus.InstanceServ
 	defer runtime.UnlockOSThread()
 
 	// Unshare a new mntns so our mounts don't leak
-	err := unix.Unshare(unix.CLONE_NEWNS)
+	err = unix.Unshare(unix.CLONE_NEWNS)
 	if err != nil {
 		return fmt.Errorf("Failed to unshare mount namespace: %w", err)
 	}
@@ -704,109 +1101,224 @@ func (c *cmdMigrate) runMigration(ctx context.Context, server incus.InstanceServ
 	defer func(path string) {
 		// Unmount the path if it's a mountpoint.
 		_ = unix.Unmount(path, unix.MNT_DETACH)
-		_ = unix.Unmount(filepath.Join(path, "root.img"), unix.MNT_DETACH)
 
 		// Cleanup VM image files.
-		_ = os.Remove(filepath.Join(path, "converted-raw-image.img"))
-		_ = os.Remove(filepath.Join(path, "root.img"))
+		cleanupDiskImage(path)
+
+		// The checkpoint is only useful if the tool gets killed.
+		_ = os.Remove(filepath.Join(path, checkpointFileName))
 
 		// Remove the directory itself.
 		_ = os.Remove(path)
 	}(path)
 
+	// Record the progress in case the tool gets killed.
+	name := config.InstanceArgs.Name
+	if migrationType == MigrationTypeVolumeBlock || migrationType == MigrationTypeVolumeFilesystem {
+		name = config.CustomVolumeArgs.Name
+	}
+
+	c.checkpoint, err = newCheckpoint(path, name, config.Project, migrationType, config.SourcePath)
+	if err != nil {
+		return fmt.Errorf("Failed to create checkpoint: %w", err)
+	}
+
+	hookEnv := migrationHookEnv(name, config, migrationType)
+
+	err = c.runPreHook(ctx, hookEnv)
+	if err != nil {
+		return err
+	}
+
+	luksDevice, cleanupSource, err := c.prepareSource(config, migrationType)
+	if err != nil {
+		return err
+	}
+
+	defer cleanupSource()
+
 	var fullPath string
 
 	if migrationType == MigrationTypeContainer || migrationType == MigrationTypeVolumeFilesystem {
-		// Create the rootfs directory
-		fullPath = fmt.Sprintf("%s/rootfs", path)
+		var cleanupMounts func()
 
-		err = os.Mkdir(fullPath, 0o755)
+		fullPath, cleanupMounts, err = c.setupFilesystemSource(path, config, migrationType, rootMount, luksDevice)
 		if err != nil {
 			return err
 		}
 
-		// Setup the source (mounts)
-		err = setupSource(fullPath, config.Mounts)
+		defer cleanupMounts()
+	} else {
+		fullPath = path
+
+		cleanupDisks, err := c.setupDiskSource(path, config)
 		if err != nil {
-			return fmt.Errorf("Failed to setup the source: %w", err)
+			return err
 		}
-	} else {
-		_, ext, convCmd, _ := archive.DetectCompression(config.SourcePath)
-		if ext == ".qcow2" || ext == ".vmdk" {
-			// COnfirm the command is available.
-			_, err := exec.LookPath(convCmd[0])
-			if err != nil {
-				return fmt.Errorf("Unable to find required command %q", convCmd[0])
-			}
 
-			destImg := filepath.Join(path, "converted-raw-image.img")
+		defer cleanupDisks()
+	}
 
-			cmd := []string{
-				"nice", "-n19", // Run with low priority to reduce CPU impact on other processes.
-			}
+	// Registered last so that it runs before any of the cleanups.
+	if c.flagKeepMounts {
+		defer c.keepMounts(path)
+	}
 
-			cmd = append(cmd, convCmd...)
-			cmd = append(cmd, "-p", "-t", "writeback")
+	// Without the disk image, the size of the root filesystem is what gets checked.
+	if c.flagAsVM && c.flagDryRun {
+		err = c.checkSourceSize(server, config, config.SourcePath, MigrationTypeContainer)
+	} else {
+		err = c.checkSourceSize(server, config, fullPath, migrationType)
+	}
 
-			// Check for Direct I/O support.
-			from, err := os.OpenFile(config.SourcePath, unix.O_DIRECT|unix.O_RDONLY, 0)
-			if err == nil {
-				cmd = append(cmd, "-T", "none")
-				_ = from.Close()
-			}
+	if err != nil {
+		return err
+	}
 
-			to, err := os.OpenFile(destImg, unix.O_DIRECT|unix.O_RDONLY, 0)
-			if err == nil {
-				cmd = append(cmd, "-t", "none")
-				_ = to.Close()
-			}
+	if migrationType == MigrationTypeContainer {
+		c.warnFileCapabilities(server, config, fullPath)
+	}
 
-			cmd = append(cmd, config.SourcePath, destImg)
+	if c.flagPauseBeforeTransfer {
+		// The mounts only exist in the namespace of the current thread.
+		fmt.Fprintf(c.out, "\nThe source is ready for inspection in %q\n", fullPath)
+		fmt.Fprintf(c.out, "To inspect it from another terminal, run: nsenter --mount=/proc/%d/task/%d/ns/mnt ls -la %s\n\n", os.Getpid(), unix.Gettid(), fullPath)
 
-			fmt.Printf("Converting image %q to raw format before importing\n", config.SourcePath)
+		proceed, err := c.global.asker.AskBool("Proceed with the transfer? [default=yes]: ", "yes")
+		if err != nil {
+			return err
+		}
 
-			c := exec.Command(cmd[0], cmd[1:]...)
-			err = c.Run()
-			if err != nil {
-				return fmt.Errorf("Failed to convert image %q for importing: %w", config.SourcePath, err)
-			}
+		if !proceed {
+			return errors.New("Migration aborted before the transfer")
+		}
+	}
 
-			config.SourcePath = destImg
+	if c.flagDryRun {
+		fmt.Fprintln(c.out, "\nDry run, nothing was created or transferred. The migration would have:")
+		for _, step := range c.dryRunSteps(config, migrationType) {
+			fmt.Fprintf(c.out, "  - %s\n", step)
 		}
 
-		fullPath = path
-		target := filepath.Join(path, "root.img")
+		return nil
+	}
+
+	if c.flagCompress != "" && (migrationType == MigrationTypeVM || migrationType == MigrationTypeVolumeBlock) {
+		fmt.Fprintln(c.out, "WARNING: --compress only applies to file transfers, the disk is sent uncompressed")
+	}
+
+	if c.flagVerify && migrationType != MigrationTypeContainer {
+		fmt.Fprintln(c.out, "WARNING: --verify only applies to containers, the server doesn't expose the content of virtual machines and custom volumes")
+	}
+
+	c.setPhase("transferring")
+
+	// The deadline covers the transfers to all the targets, not the hooks.
+	transferCtx := ctx
+	if c.flagTimeout != "" {
+		timeout, _ := time.ParseDuration(c.flagTimeout) // Validated in run.
+
+		var cancel context.CancelFunc
+		transferCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	err = c.retryTransfer(transferCtx, config, "transferring", func() error {
+		return migrationHandler(transferCtx, server, config, fullPath, migrationType)
+	})
+	if err != nil {
+		return c.transferTimeoutError(transferCtx, err)
+	}
+
+	// Reuse the same source for the additional targets.
+	succeededTargets, failedTargets := c.transferAdditionalTargets(transferCtx, config, fullPath, migrationType, migrationHandler)
+
+	c.runPostHook(ctx, hookEnv, succeededTargets, failedTargets)
+
+	if len(failedTargets) > 0 {
+		return fmt.Errorf("Migration failed on %d of %d additional targets", len(failedTargets), len(c.additionalTargets))
+	}
+
+	return nil
+}
 
-		err = os.WriteFile(target, nil, 0o644)
+// keepMounts holds on to the temporary directory of a migration and its mounts until the operator
+// is done inspecting them. The mounts only exist in the namespace of the current thread, they
+// can't outlive the process so everything is cleaned up afterwards.
+func (c *cmdMigrate) keepMounts(path string) {
+	fmt.Fprintf(c.out, "\nThe temporary directory %q and its mounts are kept for inspection (--keep-mounts)\n", path)
+	fmt.Fprintf(c.out, "To inspect it from another terminal, run: nsenter --mount=/proc/%d/task/%d/ns/mnt ls -la %s\n", os.Getpid(), unix.Gettid(), path)
+
+	_, _ = c.global.asker.AskString("Press enter to unmount and remove it: ", "", func(string) error { return nil })
+}
+
+func (c *cmdMigrate) run(cmd *cobra.Command, _ []string) error {
+	// Reject invalid and conflicting options before doing anything.
+	err := c.validateFlags(cmd)
+	if err != nil {
+		return err
+	}
+
+	// Only the result goes to stdout so that it can be parsed.
+	c.out = os.Stdout
+	if c.flagFormat == "json" {
+		c.out = os.Stderr
+	}
+
+	// Quick checks.
+	err = checkRoot()
+	if err != nil {
+		return err
+	}
+
+	// Scanning doesn't involve any server or transfer.
+	if c.flagScanOnly {
+		return c.scanSource()
+	}
+
+	err = checkCommand("rsync")
+	if err != nil {
+		return err
+	}
+
+	if c.flagSourceOffset != "" {
+		err = checkCommand("losetup")
 		if err != nil {
-			return fmt.Errorf("Failed to create %q: %w", target, err)
+			return err
 		}
+	}
 
-		// Mount the path
-		err = unix.Mount(config.SourcePath, target, "none", unix.MS_BIND, "")
-		if err != nil {
-			return fmt.Errorf("Failed to mount %s: %w", config.SourcePath, err)
+	if c.flagCompress != "" {
+		algorithm, _, _ := parseCompression(c.flagCompress)
+
+		algorithms := rsyncCompressAlgorithms()
+		if !slices.Contains(algorithms, algorithm) {
+			return fmt.Errorf("Compression algorithm %q isn't supported by rsync on this host (supported: %s)", algorithm, strings.Join(algorithms, ", "))
 		}
+	}
 
-		// Make it read-only
-		err = unix.Mount("", target, "none", unix.MS_BIND|unix.MS_RDONLY|unix.MS_REMOUNT, "")
+	if c.flagIOPriority != "" {
+		err = checkCommand("ionice")
 		if err != nil {
-			return fmt.Errorf("Failed to make %s read-only: %w", config.SourcePath, err)
+			fmt.Fprintf(c.out, "WARNING: %v, the transfer will use the default IO priority\n", err)
+			c.flagIOPriority = ""
 		}
 	}
 
-	return migrationHandler(ctx, server, config, fullPath, migrationType)
-}
-
-func (c *cmdMigrate) run(_ *cobra.Command, _ []string) error {
-	// Quick checks.
-	if os.Geteuid() != 0 {
-		return errors.New("This tool must be run as root")
+	if c.flagReportProgressTo != "" {
+		c.progress, err = newProgressReporter(c.flagReportProgressTo)
+		if err != nil {
+			return fmt.Errorf("Failed to open %q to report progress: %w", c.flagReportProgressTo, err)
+		}
 	}
 
-	_, err := exec.LookPath("rsync")
-	if err != nil {
-		return errors.New("Unable to find required command \"rsync\"")
+	// Exporting doesn't involve any server.
+	if c.flagExport != "" {
+		err = c.exportSource(context.Background())
+		c.progress.done(err)
+		c.notify(err)
+
+		return err
 	}
 
 	// Server
@@ -815,6 +1327,30 @@ func (c *cmdMigrate) run(_ *cobra.Command, _ []string) error {
 		return err
 	}
 
+	defer func() {
+		for _, target := range c.additionalTargets {
+			if target.clientFingerprint != "" {
+				_ = target.server.DeleteCertificate(target.clientFingerprint)
+			}
+		}
+	}()
+
+	for _, targetURL := range c.flagAdditionalTargets {
+		fmt.Fprintf(c.out, "Connecting to additional target %q\n", targetURL)
+
+		targetURL, err = parseURL(targetURL)
+		if err != nil {
+			return err
+		}
+
+		target, targetFingerprint, err := c.connectServer(targetURL)
+		if err != nil {
+			return fmt.Errorf("Failed to connect to additional target %q: %w", targetURL, err)
+		}
+
+		c.additionalTargets = append(c.additionalTargets, migrateTarget{url: targetURL, server: target, clientFingerprint: targetFingerprint})
+	}
+
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt)
 	ctx, cancel := context.WithCancel(context.Background())
@@ -826,6 +1362,12 @@ func (c *cmdMigrate) run(_ *cobra.Command, _ []string) error {
 			_ = server.DeleteCertificate(clientFingerprint)
 		}
 
+		for _, target := range c.additionalTargets {
+			if target.clientFingerprint != "" {
+				_ = target.server.DeleteCertificate(target.clientFingerprint)
+			}
+		}
+
 		cancel()
 
 		// The following nolint directive ignores the "deep-exit" rule of the revive linter.
@@ -838,8 +1380,52 @@ func (c *cmdMigrate) run(_ *cobra.Command, _ []string) error {
 		defer func() { _ = server.DeleteCertificate(clientFingerprint) }()
 	}
 
-	// Provide migration type
-	creationType, err := c.global.asker.AskInt(`
+	if c.flagDumpServerInfo {
+		return dumpServerInfo(server)
+	}
+
+	// Make sure we can see at least one project before asking anything else.
+	projectNames, err := server.GetProjectNames()
+	if err != nil {
+		return fmt.Errorf("Failed to list projects on the target server: %w", err)
+	}
+
+	if len(projectNames) == 0 {
+		return errors.New("This client isn't allowed to access any project on the target server")
+	}
+
+	var migrationType MigrationType
+
+	if c.flagImport != "" {
+		// The migration type comes from the archive.
+		manifest, err := readExportManifest(c.flagImport)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(c.out, "Importing %s exported from %q (%s) on %s\n", manifest.Type, manifest.Source, manifest.Hostname, manifest.Created.Local().Format(time.DateTime))
+
+		dir, sourcePath, err := extractExportArchive(c.out, c.flagImport, manifest)
+		if err != nil {
+			return err
+		}
+
+		defer func() { _ = os.RemoveAll(dir) }()
+
+		c.importSource = sourcePath
+		migrationType = manifest.Type
+
+		// The instance should match the exported machine rather than the one importing it.
+		if c.flagArchitecture == "" {
+			c.flagArchitecture = manifest.Architecture
+		}
+	} else if c.preseed != nil {
+		migrationType = c.preseed.Type
+	} else if c.flagAsVM {
+		migrationType = MigrationTypeVM
+	} else {
+		// Provide migration type
+		creationType, err := c.global.asker.AskInt(`
 What would you like to create?
 1) Container
 2) Virtual Machine
@@ -847,22 +1433,32 @@ What would you like to create?
 4) Custom Volume (from disk)
 
 Please enter the number of your choice: `, 1, 4, "", nil)
-	if err != nil {
-		return err
+		if err != nil {
+			return err
+		}
+
+		migrationType = []MigrationType{MigrationTypeContainer, MigrationTypeVM, MigrationTypeVolumeFilesystem, MigrationTypeVolumeBlock}[creationType-1]
 	}
 
-	switch creationType {
-	case 1:
-		return c.migrateInstance(ctx, server, MigrationTypeContainer)
-	case 2:
-		return c.migrateInstance(ctx, server, MigrationTypeVM)
-	case 3:
-		return c.migrateCustomVolume(ctx, server, MigrationTypeVolumeFilesystem)
-	case 4:
-		return c.migrateCustomVolume(ctx, server, MigrationTypeVolumeBlock)
+	switch migrationType {
+	case MigrationTypeContainer, MigrationTypeVM:
+		err = c.migrateInstance(ctx, server, migrationType)
+	case MigrationTypeVolumeFilesystem, MigrationTypeVolumeBlock:
+		err = c.migrateCustomVolume(ctx, server, migrationType)
+	default:
+		err = fmt.Errorf("Unknown migration type %q", migrationType)
 	}
 
-	return nil
+	c.deleteCreatedProject(server, c.createdProject)
+
+	for _, target := range c.additionalTargets {
+		c.deleteCreatedProject(target.server, target.createdProject)
+	}
+
+	c.progress.done(err)
+	c.notify(err)
+
+	return err
 }
 
 func (c *cmdMigrate) askProfiles(server incus.InstanceServer, config *cmdMigrateData) error {
@@ -871,43 +1467,105 @@ func (c *cmdMigrate) askProfiles(server incus.InstanceServer, config *cmdMigrate
 		return err
 	}
 
-	profiles, err := c.global.asker.AskString("Which profiles do you want to apply to the instance? (space separated) [default=default, \"-\" for none]: ", "default", func(s string) error {
-		// This indicates that no profiles should be applied.
-		if s == "-" {
-			return nil
+	// Start from the current selection, the default profile applying when none was chosen yet.
+	selected := slices.Clone(config.InstanceArgs.Profiles)
+	if selected == nil && slices.Contains(profileNames, "default") {
+		selected = []string{"default"}
+	}
+
+	for {
+		fmt.Fprintln(c.out, "\nProfiles (applied in the order they were selected):")
+		for i, profile := range profileNames {
+			mark := " "
+			if slices.Contains(selected, profile) {
+				mark = "x"
+			}
+
+			fmt.Fprintf(c.out, "%d) [%s] %s\n", i+1, mark, profile)
 		}
 
-		profiles := strings.Split(s, " ")
+		fmt.Fprintln(c.out, "")
+
+		var toggled []string
+
+		answer, err := c.global.asker.AskString("Profiles to toggle (comma separated numbers or names, \"-\" for none) [default=done]: ", "", func(s string) error {
+			toggled = nil
+
+			if s == "" || s == "-" {
+				return nil
+			}
+
+			for _, field := range strings.Split(s, ",") {
+				field = strings.TrimSpace(field)
+
+				index, err := strconv.Atoi(field)
+				if err == nil && index >= 1 && index <= len(profileNames) {
+					toggled = append(toggled, profileNames[index-1])
+					continue
+				}
+
+				if !slices.Contains(profileNames, field) {
+					return fmt.Errorf("Unknown profile %q", field)
+				}
 
-		for _, profile := range profiles {
-			if !slices.Contains(profileNames, profile) {
-				return fmt.Errorf("Unknown profile %q", profile)
+				toggled = append(toggled, field)
 			}
+
+			return nil
+		})
+		if err != nil {
+			return err
 		}
 
-		return nil
-	})
-	if err != nil {
-		return err
+		if answer == "" {
+			break
+		}
+
+		// This indicates that no profiles should be applied.
+		if answer == "-" {
+			selected = []string{}
+			continue
+		}
+
+		for _, profile := range toggled {
+			index := slices.Index(selected, profile)
+			if index >= 0 {
+				selected = slices.Delete(selected, index, index+1)
+			} else {
+				selected = append(selected, profile)
+			}
+		}
 	}
 
-	if profiles != "-" {
-		config.InstanceArgs.Profiles = strings.Split(profiles, " ")
+	// An empty list means no profiles at all.
+	if selected == nil {
+		selected = []string{}
 	}
 
+	config.InstanceArgs.Profiles = selected
+
 	return nil
 }
 
-func (c *cmdMigrate) askConfig(config *cmdMigrateData) error {
-	configs, err := c.global.asker.AskString("Please specify config keys and values (key=value ...): ", "", func(s string) error {
-		if s == "" {
+// askBWLimit sets the maximum rate of file transfers.
+func (c *cmdMigrate) askBWLimit() error {
+	current := c.flagBWLimit
+	if current == "" {
+		current = "unlimited"
+	}
+
+	limit, err := c.global.asker.AskString(fmt.Sprintf("Please specify the bandwidth limit per second, like 10MB [current=%s, \"-\" for unlimited]: ", current), "", func(s string) error {
+		if s == "-" {
 			return nil
 		}
 
-		for _, entry := range strings.Split(s, " ") {
-			if !strings.Contains(entry, "=") {
-				return fmt.Errorf("Bad key=value configuration: %v", entry)
-			}
+		size, err := units.ParseByteSizeString(s)
+		if err != nil {
+			return err
+		}
+
+		if size <= 0 {
+			return errors.New("Bandwidth limit must be positive")
 		}
 
 		return nil
@@ -916,137 +1574,206 @@ func (c *cmdMigrate) askConfig(config *cmdMigrateData) error {
 		return err
 	}
 
-	for _, entry := range strings.Split(configs, " ") {
-		key, value, _ := strings.Cut(entry, "=")
-		config.InstanceArgs.Config[key] = value
+	if limit == "-" {
+		limit = ""
 	}
 
+	c.flagBWLimit = limit
+
 	return nil
 }
 
-func (c *cmdMigrate) askStorage(server incus.InstanceServer, config *cmdMigrateData) error {
-	storagePools, err := server.GetStoragePoolNames()
-	if err != nil {
-		return err
-	}
+func (c *cmdMigrate) askSourcePath(config *cmdMigrateData, migrationType MigrationType) error {
+	var question string
+	var err error
 
-	if len(storagePools) == 0 {
-		return fmt.Errorf("No storage pools available")
+	// Disks can't be snapshotted like mounts.
+	if c.flagSnapshot && c.flagSourceLV == "" && (migrationType == MigrationTypeVM || migrationType == MigrationTypeVolumeBlock) {
+		return errors.New("Only logical volumes (--source-lv) can be snapshotted for virtual machines and block volumes")
 	}
 
-	storagePool, err := c.global.asker.AskChoice("Please provide the storage pool to use: ", storagePools, "")
-	if err != nil {
-		return err
+	// The virtual machine gets built from a root filesystem.
+	if c.flagAsVM {
+		migrationType = MigrationTypeContainer
 	}
 
-	config.InstanceArgs.Devices["root"] = map[string]string{
-		"type": "disk",
-		"pool": storagePool,
-		"path": "/",
-	}
+	// The source was extracted from an export archive.
+	if c.importSource != "" {
+		config.SourcePath = c.importSource
 
-	changeStorageSize, err := c.global.asker.AskBool("Do you want to change the storage size? [default=no]: ", "no")
-	if err != nil {
-		return err
+		if migrationType == MigrationTypeVM || migrationType == MigrationTypeVolumeBlock {
+			config.SourceFormat = c.reportSourceFormat(config.SourcePath)
+		}
+
+		return nil
 	}
 
-	if changeStorageSize {
-		size, err := c.global.asker.AskString("Please specify the storage size: ", "", func(s string) error {
-			_, err := units.ParseByteSizeString(s)
-			return err
-		})
+	// The source is an LVM logical volume.
+	if c.flagSourceLV != "" {
+		if migrationType != MigrationTypeVM && migrationType != MigrationTypeVolumeBlock {
+			return errors.New("A logical volume can only be used as the source of virtual machines and block volumes")
+		}
+
+		volume, err := lookupLogicalVolume(c.flagSourceLV)
 		if err != nil {
 			return err
 		}
 
-		config.InstanceArgs.Devices["root"]["size"] = size
+		config.SourcePath = volume.path
+		config.SourceFormat = c.reportSourceFormat(config.SourcePath)
+
+		return nil
 	}
 
-	return nil
-}
+	// The source comes from the --config file.
+	if c.preseed != nil {
+		config.SourcePath = c.preseed.Source
 
-func (c *cmdMigrate) askNetwork(server incus.InstanceServer, config *cmdMigrateData) error {
-	networks, err := server.GetNetworkNames()
-	if err != nil {
-		return err
-	}
+		// The disk is piped into the tool.
+		if c.flagSourceStdin || config.SourcePath == "-" {
+			if termios.IsTerminal(unix.Stdin) {
+				return errors.New("The source is the standard input but nothing is piped into it")
+			}
 
-	network, err := c.global.asker.AskChoice("Please specify the network to use for the instance: ", networks, "")
-	if err != nil {
-		return err
-	}
+			// The flags conflict with --source-stdin, but the source can also come from the file.
+			if c.flagResume || c.flagRetries > 0 {
+				return errors.New("The standard input can only be read once, it can't be used with --resume or --retries")
+			}
 
-	config.InstanceArgs.Devices["eth0"] = map[string]string{
-		"type":    "nic",
-		"nictype": "bridged",
-		"parent":  network,
-		"name":    "eth0",
-	}
+			config.SourcePath = "/dev/stdin"
+		}
 
-	return nil
-}
+		err = c.checkStream(config.SourcePath, migrationType)
+		if err != nil {
+			return err
+		}
 
-func (c *cmdMigrate) askProject(server incus.InstanceServer, config *cmdMigrateData) error {
-	projectNames, err := server.GetProjectNames()
-	if err != nil {
-		return err
-	}
+		if migrationType == MigrationTypeVM || migrationType == MigrationTypeVolumeBlock {
+			config.SourceFormat = c.reportSourceFormat(config.SourcePath)
+		}
 
-	if len(projectNames) > 1 {
-		project, err := c.global.asker.AskChoice("Project to create the instance in [default=default]: ", projectNames, api.ProjectDefaultName)
+		empty, err := isEmptySource(config.SourcePath)
 		if err != nil {
 			return err
 		}
 
-		config.Project = project
-		return nil
-	}
+		if empty != "" {
+			return fmt.Errorf("The source %s", empty)
+		}
 
-	config.Project = api.ProjectDefaultName
-	return nil
-}
+		if migrationType == MigrationTypeContainer {
+			reason := notRootfsReason(config.SourcePath)
+			if reason != "" {
+				fmt.Fprintf(c.out, "WARNING: The source %s, it may not be a root filesystem\n", reason)
+			}
+		}
 
-func (c *cmdMigrate) askSourcePath(config *cmdMigrateData, migrationType MigrationType) error {
-	var question string
-	var err error
+		return nil
+	}
 
 	// Provide source path
 	if migrationType == MigrationTypeVM || migrationType == MigrationTypeVolumeBlock {
-		question = "Please provide the path to a disk, partition, or qcow2/raw/vmdk image file: "
+		question = "Please provide the path to a disk, partition, or qcow2/raw/vmdk/vhd/vhdx image file: "
 	} else {
 		question = "Please provide the path to a root filesystem: "
 	}
 
-	config.SourcePath, err = c.global.asker.AskString(question, "", func(s string) error {
-		if !util.PathExists(s) {
-			return errors.New("Path does not exist")
+	for {
+		config.SourcePath, err = c.global.asker.AskString(question, "", func(s string) error {
+			if !util.PathExists(s) {
+				return errors.New("Path does not exist")
+			}
+
+			_, err := os.Stat(s)
+			if err != nil {
+				return err
+			}
+
+			err = c.checkStream(s, migrationType)
+			if err != nil {
+				return err
+			}
+
+			// When migrating a disk, report the detected source format
+			if migrationType == MigrationTypeVM || migrationType == MigrationTypeVolumeBlock {
+				config.SourceFormat = c.reportSourceFormat(s)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
 		}
 
-		_, err := os.Stat(s)
+		// An empty source is almost always the wrong path.
+		problem, err := isEmptySource(config.SourcePath)
 		if err != nil {
 			return err
 		}
 
-		// When migrating a disk, report the detected source format
-		if migrationType == MigrationTypeVM || migrationType == MigrationTypeVolumeBlock {
-			if linux.IsBlockdevPath(s) {
-				config.SourceFormat = "Block device"
-			} else if _, ext, _, _ := archive.DetectCompression(s); ext == ".qcow2" {
-				config.SourceFormat = "qcow2"
-			} else if _, ext, _, _ := archive.DetectCompression(s); ext == ".vmdk" {
-				config.SourceFormat = "vmdk"
-			} else {
-				// If the input isn't a block device or qcow2/vmdk image, assume it's raw.
-				// Positively identifying a raw image depends on parsing MBR/GPT partition tables.
-				config.SourceFormat = "raw"
+		// Catch a wrong directory, like /home rather than /, before transferring it.
+		if problem == "" && migrationType == MigrationTypeContainer {
+			reason := notRootfsReason(config.SourcePath)
+			if reason != "" {
+				problem = reason + " and may not be a root filesystem"
 			}
 		}
 
+		if problem == "" {
+			return nil
+		}
+
+		proceed, err := c.global.asker.AskBool(fmt.Sprintf("The source %s, continue anyway? [default=no]: ", problem), "no")
+		if err != nil {
+			return err
+		}
+
+		if proceed {
+			return nil
+		}
+	}
+}
+
+// askContentType checks that the source matches the selected custom volume content type,
+// offering to switch to the detected one otherwise.
+func (c *cmdMigrate) askContentType(config *cmdMigrateData) error {
+	info, err := os.Stat(config.SourcePath)
+	if err != nil {
+		return err
+	}
+
+	detected := "block"
+	if info.IsDir() {
+		detected = "filesystem"
+	}
+
+	if detected == config.CustomVolumeArgs.ContentType {
 		return nil
-	})
+	}
+
+	if c.preseed != nil {
+		return fmt.Errorf("The source looks like a %s volume but the configuration file is for a %s volume", detected, config.CustomVolumeArgs.ContentType)
+	}
+
+	question := fmt.Sprintf("The source looks like a %s volume but a %s volume was selected, switch to %s? [default=yes]: ", detected, config.CustomVolumeArgs.ContentType, detected)
+
+	switchType, err := c.global.asker.AskBool(question, "yes")
 	if err != nil {
 		return err
 	}
 
+	if !switchType {
+		fmt.Fprintf(c.out, "WARNING: Keeping the %s content type, the migration is likely to fail\n", config.CustomVolumeArgs.ContentType)
+		return nil
+	}
+
+	config.CustomVolumeArgs.ContentType = detected
+
+	if detected == "block" {
+		config.SourceFormat = c.reportSourceFormat(config.SourcePath)
+	} else {
+		config.SourceFormat = ""
+	}
+
 	return nil
 }
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+
+	localtls "github.com/lxc/incus/v6/shared/tls"
+	"github.com/lxc/incus/v6/shared/util"
+)
+
+// savedRemote represents a target server saved for later use.
+// Only references to the authentication material are stored, never the material itself.
+type savedRemote struct {
+	Addr        string `yaml:"addr"`
+	Fingerprint string `yaml:"fingerprint,omitempty"`
+	CertPath    string `yaml:"cert_path"`
+	KeyPath     string `yaml:"key_path"`
+}
+
+// remotesPath returns the path to the file holding the saved remotes.
+func remotesPath() (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(configDir, "incus-migrate", "remotes.yml"), nil
+}
+
+// loadRemotes returns the saved remotes, indexed by name.
+func loadRemotes() (map[string]savedRemote, error) {
+	path, err := remotesPath()
+	if err != nil {
+		return nil, err
+	}
+
+	remotes := map[string]savedRemote{}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return remotes, nil
+		}
+
+		return nil, err
+	}
+
+	err = yaml.Unmarshal(content, &remotes)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse %q: %w", path, err)
+	}
+
+	return remotes, nil
+}
+
+// saveRemotes writes the saved remotes back to disk.
+func saveRemotes(remotes map[string]savedRemote) error {
+	path, err := remotesPath()
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0o700)
+	if err != nil {
+		return err
+	}
+
+	content, err := yaml.Marshal(remotes)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, content, 0o600)
+}
+
+// newSavedRemote validates the provided information and records the fingerprint of serverCert,
+// the checked server certificate returned by getServerCertificate.
+func newSavedRemote(serverURL string, serverCert string, certPath string, keyPath string) (*savedRemote, error) {
+	serverURL, err := parseURL(serverURL)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range []string{certPath, keyPath} {
+		if !util.PathExists(path) {
+			return nil, fmt.Errorf("File %q does not exist", path)
+		}
+	}
+
+	certPath, err = filepath.Abs(certPath)
+	if err != nil {
+		return nil, err
+	}
+
+	keyPath, err = filepath.Abs(keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	fingerprint := ""
+	if serverCert != "" {
+		fingerprint, err = localtls.CertFingerprintStr(serverCert)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &savedRemote{
+		Addr:        serverURL,
+		Fingerprint: fingerprint,
+		CertPath:    certPath,
+		KeyPath:     keyPath,
+	}, nil
+}
+
+type cmdRemote struct {
+	global *cmdGlobal
+}
+
+func (c *cmdRemote) command() *cobra.Command {
+	cmd := &cobra.Command{}
+
+	cmd.Use = "remote"
+	cmd.Short = "Manage the saved target servers"
+	cmd.Long = `Description:
+  Manage the saved target servers
+
+  Saved servers can be selected with --remote, skipping the server and
+  authentication questions. Only the path to the client certificate and key
+  are stored, so those files must remain available.
+`
+
+	// Add
+	remoteAddCmd := cmdRemoteAdd{global: c.global}
+	cmd.AddCommand(remoteAddCmd.command())
+
+	// List
+	remoteListCmd := cmdRemoteList{global: c.global}
+	cmd.AddCommand(remoteListCmd.command())
+
+	// Remove
+	remoteRemoveCmd := cmdRemoteRemove{global: c.global}
+	cmd.AddCommand(remoteRemoveCmd.command())
+
+	// Workaround for subcommand usage errors. See: https://github.com/spf13/cobra/issues/706
+	cmd.Args = cobra.NoArgs
+	cmd.Run = func(cmd *cobra.Command, _ []string) { _ = cmd.Usage() }
+
+	return cmd
+}
+
+type cmdRemoteAdd struct {
+	global *cmdGlobal
+
+	flagCertPath string
+	flagKeyPath  string
+}
+
+func (c *cmdRemoteAdd) command() *cobra.Command {
+	cmd := &cobra.Command{}
+
+	cmd.Use = "add <name> <URL>"
+	cmd.Short = "Save a target server"
+	cmd.RunE = c.run
+	cmd.Flags().StringVar(&c.flagCertPath, "cert", "", "Path to the client certificate trusted by the server"+"``")
+	cmd.Flags().StringVar(&c.flagKeyPath, "key", "", "Path to the client key"+"``")
+
+	return cmd
+}
+
+func (c *cmdRemoteAdd) run(cmd *cobra.Command, args []string) error {
+	if len(args) != 2 {
+		_ = cmd.Help()
+		return errors.New("Missing required arguments")
+	}
+
+	if c.flagCertPath == "" || c.flagKeyPath == "" {
+		return errors.New("Both --cert and --key must be provided")
+	}
+
+	remotes, err := loadRemotes()
+	if err != nil {
+		return err
+	}
+
+	_, ok := remotes[args[0]]
+	if ok {
+		return fmt.Errorf("Remote %q already exists", args[0])
+	}
+
+	serverURL, err := parseURL(args[1])
+	if err != nil {
+		return err
+	}
+
+	serverCert, err := getServerCertificate(serverURL, "", func(digest string) error {
+		fmt.Println("Certificate fingerprint:", digest)
+
+		ok, err := c.global.asker.AskBool("ok (y/n)? ", "")
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			return errors.New("Server certificate rejected by user")
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	remote, err := newSavedRemote(serverURL, serverCert, c.flagCertPath, c.flagKeyPath)
+	if err != nil {
+		return err
+	}
+
+	remotes[args[0]] = *remote
+
+	return saveRemotes(remotes)
+}
+
+type cmdRemoteList struct {
+	global *cmdGlobal
+}
+
+func (c *cmdRemoteList) command() *cobra.Command {
+	cmd := &cobra.Command{}
+
+	cmd.Use = "list"
+	cmd.Aliases = []string{"ls"}
+	cmd.Short = "List the saved target servers"
+	cmd.RunE = c.run
+
+	return cmd
+}
+
+func (c *cmdRemoteList) run(_ *cobra.Command, _ []string) error {
+	remotes, err := loadRemotes()
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(remotes))
+	for name := range remotes {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Printf("%s: %s (certificate: %s)\n", name, remotes[name].Addr, remotes[name].CertPath)
+	}
+
+	return nil
+}
+
+type cmdRemoteRemove struct {
+	global *cmdGlobal
+}
+
+func (c *cmdRemoteRemove) command() *cobra.Command {
+	cmd := &cobra.Command{}
+
+	cmd.Use = "remove <name>"
+	cmd.Aliases = []string{"rm"}
+	cmd.Short = "Remove a saved target server"
+	cmd.RunE = c.run
+
+	return cmd
+}
+
+func (c *cmdRemoteRemove) run(cmd *cobra.Command, args []string) error {
+	if len(args) != 1 {
+		_ = cmd.Help()
+		return errors.New("Missing required argument")
+	}
+
+	remotes, err := loadRemotes()
+	if err != nil {
+		return err
+	}
+
+	_, ok := remotes[args[0]]
+	if !ok {
+		return fmt.Errorf("Remote %q doesn't exist", args[0])
+	}
+
+	delete(remotes, args[0])
+
+	return saveRemotes(remotes)
+}
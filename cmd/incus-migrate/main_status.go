@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/sys/unix"
+
+	"github.com/lxc/incus/v6/shared/units"
+	"github.com/lxc/incus/v6/shared/util"
+)
+
+type cmdStatus struct {
+	global *cmdGlobal
+}
+
+func (c *cmdStatus) command() *cobra.Command {
+	cmd := &cobra.Command{}
+
+	cmd.Use = "status"
+	cmd.Short = "Report on running and interrupted migrations"
+	cmd.Long = `Description:
+  Report on running and interrupted migrations
+
+  Migrations keep track of their progress in their temporary directory.
+  When a migration gets killed, its temporary directory is left behind and
+  this reports how far it went before being interrupted.
+`
+	cmd.RunE = c.run
+
+	return cmd
+}
+
+func (c *cmdStatus) run(_ *cobra.Command, _ []string) error {
+	checkpoints, err := loadCheckpoints()
+	if err != nil {
+		return err
+	}
+
+	if len(checkpoints) == 0 {
+		fmt.Println("No migration found")
+		return nil
+	}
+
+	paths := make([]string, 0, len(checkpoints))
+	for path := range checkpoints {
+		paths = append(paths, path)
+	}
+
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		checkpoint := checkpoints[path]
+
+		state := "interrupted"
+		if unix.Kill(checkpoint.PID, 0) == nil {
+			state = "running"
+		}
+
+		fmt.Printf("%s %q (%s, %s):\n", checkpoint.Type, checkpoint.Name, checkpoint.Source, state)
+		fmt.Printf("  Directory: %s\n", filepath.Dir(path))
+		fmt.Printf("  Started: %s\n", checkpoint.Started.Local().Format(time.DateTime))
+		fmt.Printf("  Last update: %s\n", checkpoint.Updated.Local().Format(time.DateTime))
+		fmt.Printf("  Phase: %s\n", checkpoint.Phase)
+
+		if len(checkpoint.Mounts) > 0 {
+			fmt.Println("  Mounts completed:")
+			for _, mount := range checkpoint.Mounts {
+				fmt.Printf("    - %s\n", mount)
+			}
+		}
+
+		fmt.Printf("  Data transferred: %s\n", units.GetByteSizeStringIEC(checkpoint.BytesTransferred, 2))
+
+		// Converted images are only renamed into place once complete.
+		if util.PathExists(filepath.Join(filepath.Dir(path), "converted-raw-image.img.partial")) {
+			fmt.Println("  Image conversion: incomplete")
+		} else if util.PathExists(filepath.Join(filepath.Dir(path), "converted-raw-image.img")) {
+			fmt.Println("  Image conversion: complete")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+
+	incus "github.com/lxc/incus/v6/client"
+	cli "github.com/lxc/incus/v6/internal/cmd"
+	"github.com/lxc/incus/v6/shared/api"
+	"github.com/lxc/incus/v6/shared/revert"
+	"github.com/lxc/incus/v6/shared/termios"
+	"github.com/lxc/incus/v6/shared/util"
+)
+
+// mountVolume represents an additional mount transferred to its own custom volume.
+type mountVolume struct {
+	// Path of the mount on the source.
+	Source string
+
+	// Path of the disk device in the instance.
+	Path string
+
+	// Storage pool and name of the custom volume.
+	Pool string
+	Name string
+
+	// Path to transfer the data from, once the source is set up.
+	transferPath string
+}
+
+// askExcludes lets the user pick paths to leave out of the transfer.
+func (c *cmdMigrate) askExcludes(config *cmdMigrateData) error {
+	addExcludes, err := c.global.asker.AskBool("Do you want to exclude paths from the transfer? [default=no]: ", "no")
+	if err != nil {
+		return err
+	}
+
+	if !addExcludes {
+		return nil
+	}
+
+	for {
+		exclude, err := c.global.asker.AskString("Please provide a path to exclude, like /var/cache [empty value to continue]: ", "", func(s string) error {
+			if s == "" {
+				return nil
+			}
+
+			return validateExclude(s)
+		})
+		if err != nil {
+			return err
+		}
+
+		if exclude == "" {
+			return nil
+		}
+
+		config.Excludes = append(config.Excludes, exclude)
+	}
+}
+
+// validateExclude checks a path to leave out of the transfer. Those are absolute paths within the
+// instance, which may hold rsync wildcards and end with a slash to only match directories.
+func validateExclude(exclude string) error {
+	if !strings.HasPrefix(exclude, "/") {
+		return fmt.Errorf("Invalid excluded path %q: must be an absolute path within the instance, like /var/cache", exclude)
+	}
+
+	if strings.TrimRight(exclude, "/") == "" {
+		return fmt.Errorf("Invalid excluded path %q: can't exclude the whole root filesystem", exclude)
+	}
+
+	if slices.Contains(strings.Split(exclude, "/"), "..") {
+		return fmt.Errorf("Invalid excluded path %q: must not contain \"..\"", exclude)
+	}
+
+	_, err := filepath.Match(exclude, "")
+	if err != nil {
+		return fmt.Errorf("Invalid excluded path %q: %w", exclude, err)
+	}
+
+	return nil
+}
+
+// askSelectMounts lets the user pick additional mounts among the filesystems mounted below the source.
+func (c *cmdMigrate) askSelectMounts(sourcePath string) ([]string, error) {
+	candidates, err := sourceSubMounts(sourcePath)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates = c.excludeRemovable(candidates)
+
+	if len(candidates) == 0 {
+		fmt.Fprintln(c.out, "No additional filesystem is mounted below the source")
+		return nil, nil
+	}
+
+	return c.askPickMounts("Filesystems mounted below the source", candidates)
+}
+
+// askMountsFromFstab lets the user pick additional mounts among the filesystems listed in the fstab of the source.
+func (c *cmdMigrate) askMountsFromFstab(sourcePath string) ([]string, error) {
+	candidates, skipped, err := fstabMounts(sourcePath)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get the mounts from fstab: %w", err)
+	}
+
+	for _, entry := range skipped {
+		fmt.Fprintf(c.out, "Skipping fstab entry %s\n", entry)
+	}
+
+	candidates = c.excludeRemovable(candidates)
+
+	if len(candidates) == 0 {
+		fmt.Fprintln(c.out, "No additional filesystem to transfer found in fstab")
+		return nil, nil
+	}
+
+	return c.askPickMounts("Filesystems listed in fstab", candidates)
+}
+
+// askPickMounts lets the user pick mounts among the candidates, all of them by default.
+func (c *cmdMigrate) askPickMounts(title string, candidates []mountInfo) ([]string, error) {
+	fmt.Fprintf(c.out, "\n%s:\n", title)
+	for i, mount := range candidates {
+		fmt.Fprintf(c.out, "%d) %s (%s, %s)\n", i+1, mount.MountPoint, mount.FSType, mount.Source)
+	}
+
+	fmt.Fprintln(c.out, "")
+
+	var selected []string
+
+	_, err := c.global.asker.AskString("Mounts to include (comma separated numbers, \"all\" or \"none\") [default=all]: ", "all", func(s string) error {
+		selected = nil
+
+		switch s {
+		case "all":
+			for _, mount := range candidates {
+				selected = append(selected, mount.MountPoint)
+			}
+
+			return nil
+		case "none":
+			return nil
+		}
+
+		for _, field := range strings.Split(s, ",") {
+			index, err := strconv.Atoi(strings.TrimSpace(field))
+			if err != nil || index < 1 || index > len(candidates) {
+				return fmt.Errorf("Invalid choice %q", field)
+			}
+
+			if !slices.Contains(selected, candidates[index-1].MountPoint) {
+				selected = append(selected, candidates[index-1].MountPoint)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return selected, nil
+}
+
+// excludeFSTypes drops the additional mounts on one of the excluded filesystem types.
+// Mounts are bound without their own submounts, so anything excluded below another mount
+// just shows up as its underlying (usually empty) directory and doesn't need an rsync exclude.
+func (c *cmdMigrate) excludeFSTypes(config *cmdMigrateData) error {
+	if len(c.flagExcludeFSTypes) == 0 || config.InstanceArgs.Type != api.InstanceTypeContainer {
+		return nil
+	}
+
+	_, excluded, err := excludeMountsByFSType([]string{config.SourcePath}, c.flagExcludeFSTypes)
+	if err != nil {
+		return err
+	}
+
+	if len(excluded) > 0 {
+		return fmt.Errorf("The source itself is on an excluded filesystem: %s", excluded[0])
+	}
+
+	config.Mounts, excluded, err = excludeMountsByFSType(config.Mounts, c.flagExcludeFSTypes)
+	if err != nil {
+		return err
+	}
+
+	for _, mount := range excluded {
+		fmt.Fprintf(c.out, "Excluding mount %s\n", mount)
+	}
+
+	return nil
+}
+
+// excludeRemovable drops the discovered mounts which look like removable media or virtual mounts,
+// those are rarely meant to be part of the instance. Mounts given explicitly are left alone.
+func (c *cmdMigrate) excludeRemovable(candidates []mountInfo) []mountInfo {
+	if c.flagIncludeRemovable {
+		return candidates
+	}
+
+	kept := []mountInfo{}
+	excluded := []string{}
+
+	for _, mount := range candidates {
+		reason := removableMountReason(mount)
+		if reason != "" {
+			excluded = append(excluded, fmt.Sprintf("%s (%s)", mount.MountPoint, reason))
+			continue
+		}
+
+		kept = append(kept, mount)
+	}
+
+	if len(excluded) > 0 {
+		fmt.Fprintln(c.out, "Excluding mounts which look like removable media or virtual mounts (use --include-removable to keep them):")
+		for _, mount := range excluded {
+			fmt.Fprintf(c.out, "  %s\n", mount)
+		}
+	}
+
+	return kept
+}
+
+// applyMountPools moves the additional mounts mapped to a storage pool out of the root filesystem
+// and onto their own custom volume, attached to the instance through a disk device.
+func (c *cmdMigrate) applyMountPools(server incus.InstanceServer, config *cmdMigrateData) error {
+	if len(c.flagMountPools) == 0 {
+		return nil
+	}
+
+	if config.InstanceArgs.Type != api.InstanceTypeContainer {
+		return errors.New("Mounts can only be mapped to storage pools for containers")
+	}
+
+	mapping, err := parseKeyValues(c.flagMountPools)
+	if err != nil {
+		return err
+	}
+
+	pools, err := server.GetStoragePoolNames()
+	if err != nil {
+		return err
+	}
+
+	for _, mount := range slices.Sorted(maps.Keys(mapping)) {
+		pool := mapping[mount]
+		if !slices.Contains(pools, pool) {
+			return fmt.Errorf("Storage pool %q doesn't exist", pool)
+		}
+
+		index := slices.IndexFunc(config.Mounts, func(s string) bool { return filepath.Clean(s) == filepath.Clean(mount) })
+		if index < 0 {
+			return fmt.Errorf("%q isn't one of the additional mounts", mount)
+		}
+
+		source := config.Mounts[index]
+		config.Mounts = slices.Delete(config.Mounts, index, index+1)
+
+		instancePath := "/" + strings.TrimPrefix(strings.TrimPrefix(filepath.Clean(source), config.SourcePath), "/")
+		suffix := strings.ReplaceAll(instancePath, "/", "-")
+
+		volume := mountVolume{
+			Source: source,
+			Path:   instancePath,
+			Pool:   pool,
+			Name:   config.InstanceArgs.Name + suffix,
+		}
+
+		// Paths like /var/lib and /var-lib end up with the same volume and device names.
+		for _, other := range config.MountVolumes {
+			if other.Name == volume.Name {
+				return fmt.Errorf("The mounts %q and %q would both be transferred to a storage volume named %q", other.Source, volume.Source, volume.Name)
+			}
+		}
+
+		// Resuming refreshes the volumes left behind by the previous attempt.
+		_, _, err = server.GetStoragePoolVolume(volume.Pool, "custom", volume.Name)
+		if err == nil && !config.Resume {
+			return fmt.Errorf("Storage volume %q for the mount %q already exists in storage pool %q", volume.Name, volume.Source, volume.Pool)
+		}
+
+		config.InstanceArgs.Devices["mount"+suffix] = map[string]string{
+			"type":   "disk",
+			"pool":   volume.Pool,
+			"source": volume.Name,
+			"path":   volume.Path,
+		}
+
+		config.MountVolumes = append(config.MountVolumes, volume)
+	}
+
+	return nil
+}
+
+// transferMountVolume creates the custom volume for an additional mount and transfers its data.
+// When refresh is set, the data is transferred into the volume left behind by an interrupted migration if there is one.
+// The deletion of the volume is added to the reverter as soon as it's created.
+func (c *cmdMigrate) transferMountVolume(ctx context.Context, server incus.InstanceServer, reverter *revert.Reverter, volume mountVolume, refresh bool) error {
+	op, err := server.CreateStoragePoolVolumeFromMigration(volume.Pool, api.StorageVolumesPost{
+		Name:        volume.Name,
+		Type:        "custom",
+		ContentType: "filesystem",
+		Source: api.StorageVolumeSource{
+			Type:    "migration",
+			Mode:    "push",
+			Refresh: refresh,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to create volume %q for %q: %w", volume.Name, volume.Source, err)
+	}
+
+	reverter.Add(func() {
+		_ = server.DeleteStoragePoolVolume(volume.Pool, "custom", volume.Name)
+	})
+
+	progress := cli.ProgressRenderer{Format: fmt.Sprintf("Transferring %s: %%s", volume.Source), Quiet: c.flagFormat == "json"}
+	_, err = op.AddHandler(progress.UpdateOp)
+	if err != nil {
+		progress.Done("")
+		return err
+	}
+
+	if c.progress != nil {
+		_, _ = op.AddHandler(c.progress.updateOp)
+	}
+
+	err = transferRootfs(ctx, op, volume.transferPath, c.transferArgs(), MigrationTypeVolumeFilesystem)
+	if err != nil {
+		progress.Done("")
+		return transferError{err}
+	}
+
+	progress.Done(fmt.Sprintf("Volume %s successfully created", volume.Name))
+
+	return nil
+}
+
+// askContainerMounts asks for the additional filesystem mounts and the paths to exclude from the
+// transfer of a container.
+func (c *cmdMigrate) askContainerMounts(config *cmdMigrateData) error {
+	var err error
+
+	var mounts []string
+
+	// There are no mounts to add below a plain directory.
+	if c.preseed != nil {
+		config.Mounts = append(config.Mounts, c.preseed.Mounts...)
+	} else if c.flagMountsFromFstab {
+		mounts, err = c.askMountsFromFstab(config.SourcePath)
+		if err != nil {
+			return err
+		}
+
+		config.Mounts = append(config.Mounts, mounts...)
+	} else if isPlainDirectory(config.SourcePath) {
+		fmt.Fprintln(c.out, "The source is a plain directory with nothing mounted below it, there are no mounts to add")
+
+		if len(config.Excludes) == 0 {
+			err = c.askExcludes(config)
+			if err != nil {
+				return err
+			}
+		}
+	} else if c.flagSelectMounts && termios.IsTerminal(unix.Stdin) {
+		mounts, err = c.askSelectMounts(config.SourcePath)
+		if err != nil {
+			return err
+		}
+
+		config.Mounts = append(config.Mounts, mounts...)
+	} else {
+		addMounts, err := c.global.asker.AskBool("Do you want to add additional filesystem mounts? [default=no]: ", "no")
+		if err != nil {
+			return err
+		}
+
+		if addMounts {
+			for {
+				path, err := c.global.asker.AskString("Please provide a path the filesystem mount path [empty value to continue]: ", "", func(s string) error {
+					if s != "" {
+						if util.PathExists(s) {
+							return nil
+						}
+
+						return errors.New("Path does not exist")
+					}
+
+					return nil
+				})
+				if err != nil {
+					return err
+				}
+
+				if path == "" {
+					break
+				}
+
+				mounts = append(mounts, path)
+			}
+
+			config.Mounts = append(config.Mounts, mounts...)
+		}
+
+		if len(config.Excludes) == 0 {
+			err = c.askExcludes(config)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	incus "github.com/lxc/incus/v6/client"
+	"github.com/lxc/incus/v6/shared/validate"
+)
+
+// nicDescription returns the network of a network interface, along with its addresses if set.
+func nicDescription(device map[string]string) string {
+	var addresses []string
+	for _, key := range []string{"hwaddr", "ipv4.address", "ipv6.address"} {
+		if device[key] != "" {
+			addresses = append(addresses, fmt.Sprintf("%s=%s", key, device[key]))
+		}
+	}
+
+	if len(addresses) == 0 {
+		return device["parent"]
+	}
+
+	return fmt.Sprintf("%s (%s)", device["parent"], strings.Join(addresses, ", "))
+}
+
+func (c *cmdMigrate) askNetwork(server incus.InstanceServer, config *cmdMigrateData) error {
+	networks, err := server.GetNetworkNames()
+	if err != nil {
+		return err
+	}
+
+	if len(networks) == 0 {
+		return errors.New("No networks available")
+	}
+
+	// Start over, replacing the interfaces configured before.
+	for name, device := range config.InstanceArgs.Devices {
+		if device["type"] == "nic" {
+			delete(config.InstanceArgs.Devices, name)
+		}
+	}
+
+	name := "eth0"
+	question := "Please specify the network to use for the instance: "
+
+	for {
+		network, err := c.global.asker.AskChoice(question, networks, "")
+		if err != nil {
+			return err
+		}
+
+		config.InstanceArgs.Devices[name] = map[string]string{
+			"type":    "nic",
+			"nictype": "bridged",
+			"parent":  network,
+			"name":    name,
+		}
+
+		err = c.askNICAddresses(name, config.InstanceArgs.Devices[name])
+		if err != nil {
+			return err
+		}
+
+		addNetwork, err := c.global.asker.AskBool("Do you want to add another network interface? [default=no]: ", "no")
+		if err != nil {
+			return err
+		}
+
+		if !addNetwork {
+			return nil
+		}
+
+		// Suggest the next free interface name.
+		next := name
+		for i := 1; ; i++ {
+			next = fmt.Sprintf("eth%d", i)
+
+			_, ok := config.InstanceArgs.Devices[next]
+			if !ok {
+				break
+			}
+		}
+
+		name, err = c.global.asker.AskString(fmt.Sprintf("Name of the network interface [default=%s]: ", next), next, func(s string) error {
+			_, ok := config.InstanceArgs.Devices[s]
+			if ok {
+				return fmt.Errorf("Device %q already exists", s)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		question = fmt.Sprintf("Please specify the network to use for %s: ", name)
+	}
+}
+
+// askNICAddresses optionally sets the MAC address and static IP addresses of a network interface,
+// to preserve the network identity of the source.
+func (c *cmdMigrate) askNICAddresses(name string, device map[string]string) error {
+	setAddresses, err := c.global.asker.AskBool(fmt.Sprintf("Do you want to set the MAC or IP addresses of %s? [default=no]: ", name), "no")
+	if err != nil {
+		return err
+	}
+
+	if !setAddresses {
+		return nil
+	}
+
+	questions := []struct {
+		key      string
+		question string
+		validate func(string) error
+	}{
+		{"hwaddr", "MAC address", validate.IsNetworkMAC},
+		{"ipv4.address", "IPv4 address", validate.IsNetworkAddressV4},
+		{"ipv6.address", "IPv6 address", validate.IsNetworkAddressV6},
+	}
+
+	for _, q := range questions {
+		value, err := c.global.asker.AskString(fmt.Sprintf("%s of %s [empty to skip]: ", q.question, name), "", validate.Optional(q.validate))
+		if err != nil {
+			return err
+		}
+
+		if value != "" {
+			device[q.key] = value
+		}
+	}
+
+	return nil
+}
+
+// removeNetwork ensures that the instance doesn't get any network device,
+// masking the NICs which would otherwise be inherited from its profiles.
+func (c *cmdMigrate) removeNetwork(server incus.InstanceServer, config *cmdMigrateData) error {
+	config.InstanceArgs.Devices["eth0"] = map[string]string{
+		"type": "none",
+	}
+
+	profiles := config.InstanceArgs.Profiles
+	if profiles == nil {
+		profiles = []string{"default"}
+	}
+
+	for _, profileName := range profiles {
+		profile, _, err := server.GetProfile(profileName)
+		if err != nil {
+			return err
+		}
+
+		for deviceName, device := range profile.Devices {
+			if device["type"] != "nic" {
+				continue
+			}
+
+			_, ok := config.InstanceArgs.Devices[deviceName]
+			if ok {
+				continue
+			}
+
+			fmt.Fprintf(c.out, "Masking network device %q inherited from profile %q\n", deviceName, profileName)
+			config.InstanceArgs.Devices[deviceName] = map[string]string{
+				"type": "none",
+			}
+		}
+	}
+
+	return nil
+}
+
+// netplanPath is where the netplan configuration is written in containers.
+const netplanPath = "/etc/netplan/99-incus-migrate.yaml"
+
+// readNetplan reads the netplan configuration file and checks that it looks like one.
+func readNetplan(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("Failed to read netplan configuration: %w", err)
+	}
+
+	netplan := map[string]any{}
+
+	err = yaml.Unmarshal(content, &netplan)
+	if err != nil {
+		return "", fmt.Errorf("Failed to parse netplan configuration %q: %w", path, err)
+	}
+
+	_, ok := netplan["network"]
+	if !ok {
+		return "", fmt.Errorf("Netplan configuration %q is missing the top-level \"network\" key", path)
+	}
+
+	return string(content), nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// Partition table layouts as reported by detectPartitionTable.
+const (
+	partitionTableNone       = "none"
+	partitionTableMBR        = "MBR"
+	partitionTableGPT        = "GPT"
+	partitionTableHybridGPT  = "GPT (hybrid MBR)"
+	partitionTableBackupGPT  = "GPT (backup header only)"
+	partitionTableFilesystem = "none (filesystem boot sector)"
+)
+
+// mbrTypeGPTProtective is the MBR partition type covering a GPT disk.
+const mbrTypeGPTProtective = 0xee
+
+// detectPartitionTable returns the partition table layout of a disk or image.
+// Disks are checked for GPT with both 512 and 4096 bytes logical sectors. A GPT
+// disk is recognized through its primary header or, if that one is damaged, through
+// its backup header at the end of the disk. The MBR is used to tell a pure protective
+// MBR from a hybrid one, and to tell a partitioned disk from a filesystem image whose
+// boot sector also carries the 0x55aa signature.
+func detectPartitionTable(r io.ReaderAt, size int64) (string, error) {
+	mbr := make([]byte, 512)
+
+	_, err := r.ReadAt(mbr, 0)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return partitionTableNone, nil
+		}
+
+		return "", err
+	}
+
+	hasSignature := mbr[510] == 0x55 && mbr[511] == 0xaa
+
+	// Look at the MBR partition entries.
+	var protective bool
+	var others int
+	var invalid bool
+
+	for i := range 4 {
+		entry := mbr[446+i*16 : 446+(i+1)*16]
+
+		// The boot indicator must be either inactive or active.
+		if entry[0] != 0x00 && entry[0] != 0x80 {
+			invalid = true
+		}
+
+		switch entry[4] {
+		case 0x00:
+			// Unused entry.
+		case mbrTypeGPTProtective:
+			protective = true
+		default:
+			others++
+		}
+	}
+
+	// Look for a GPT header, first right after the MBR then at the end of the disk.
+	for _, sectorSize := range []int64{512, 4096} {
+		if isGPTHeader(r, sectorSize) {
+			if hasSignature && protective && others > 0 {
+				return partitionTableHybridGPT, nil
+			}
+
+			return partitionTableGPT, nil
+		}
+	}
+
+	if hasSignature && protective {
+		for _, sectorSize := range []int64{512, 4096} {
+			if size >= 2*sectorSize && size%sectorSize == 0 && isGPTHeader(r, size-sectorSize) {
+				return partitionTableBackupGPT, nil
+			}
+		}
+	}
+
+	if !hasSignature {
+		return partitionTableNone, nil
+	}
+
+	// FAT, exFAT and NTFS boot sectors also end with 0x55aa.
+	if bytes.Equal(mbr[3:11], []byte("NTFS    ")) || bytes.Equal(mbr[3:11], []byte("EXFAT   ")) ||
+		bytes.HasPrefix(mbr[54:62], []byte("FAT")) || bytes.HasPrefix(mbr[82:90], []byte("FAT")) {
+		return partitionTableFilesystem, nil
+	}
+
+	if invalid || others == 0 {
+		return partitionTableNone, nil
+	}
+
+	return partitionTableMBR, nil
+}
+
+// isGPTHeader checks for a valid GPT header at the provided offset.
+func isGPTHeader(r io.ReaderAt, offset int64) bool {
+	header := make([]byte, 512)
+
+	_, err := r.ReadAt(header, offset)
+	if err != nil {
+		return false
+	}
+
+	if !bytes.Equal(header[0:8], []byte("EFI PART")) {
+		return false
+	}
+
+	headerSize := binary.LittleEndian.Uint32(header[12:16])
+	if headerSize < 92 || headerSize > uint32(len(header)) {
+		return false
+	}
+
+	// The checksum is computed with the checksum field zeroed.
+	checksum := binary.LittleEndian.Uint32(header[16:20])
+	binary.LittleEndian.PutUint32(header[16:20], 0)
+
+	return crc32.ChecksumIEEE(header[:headerSize]) == checksum
+}
+
+// detectPartitionTableFromPath returns the partition table layout of a disk or raw image.
+func detectPartitionTableFromPath(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+
+	defer func() { _ = f.Close() }()
+
+	// Seeking works for both image files and block devices.
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return "", err
+	}
+
+	return detectPartitionTable(f, size)
+}
+
+// partitionTableIssue returns what prevents a disk with the provided partition table layout from
+// booting as a virtual machine, or an empty string if nothing does.
+func partitionTableIssue(table string) string {
+	switch table {
+	case partitionTableMBR, partitionTableGPT, partitionTableHybridGPT:
+		return ""
+	case partitionTableBackupGPT:
+		return "The primary GPT header of the source is damaged and only the backup one is intact, the virtual machine firmware may not find the partitions (repair it with gdisk or sgdisk)"
+	default:
+		return fmt.Sprintf("The source doesn't look like a bootable disk (partition table: %s), a virtual machine needs a whole disk rather than a partition", table)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// mbrEntry describes an MBR partition entry for the test fixtures.
+type mbrEntry struct {
+	boot  byte
+	ptype byte
+}
+
+// writeMBR writes the MBR partition entries and signature to the disk.
+func writeMBR(disk []byte, entries ...mbrEntry) {
+	for i, entry := range entries {
+		disk[446+i*16] = entry.boot
+		disk[446+i*16+4] = entry.ptype
+	}
+
+	disk[510] = 0x55
+	disk[511] = 0xaa
+}
+
+// writeGPTHeader writes a GPT header with a valid checksum at the provided offset.
+func writeGPTHeader(disk []byte, offset int) {
+	header := disk[offset : offset+92]
+	copy(header[0:8], "EFI PART")
+	binary.LittleEndian.PutUint32(header[8:12], 0x00010000)
+	binary.LittleEndian.PutUint32(header[12:16], 92)
+	binary.LittleEndian.PutUint32(header[16:20], crc32.ChecksumIEEE(header))
+}
+
+func TestDetectPartitionTable(t *testing.T) {
+	const size = 64 * 1024
+
+	tests := []struct {
+		name  string
+		setup func(disk []byte)
+		want  string
+	}{
+		{
+			name:  "Empty disk",
+			setup: func(disk []byte) {},
+			want:  partitionTableNone,
+		},
+		{
+			name: "MBR",
+			setup: func(disk []byte) {
+				writeMBR(disk, mbrEntry{0x80, 0x83}, mbrEntry{0x00, 0x82})
+			},
+			want: partitionTableMBR,
+		},
+		{
+			name: "GPT with protective MBR",
+			setup: func(disk []byte) {
+				writeMBR(disk, mbrEntry{0x00, mbrTypeGPTProtective})
+				writeGPTHeader(disk, 512)
+				writeGPTHeader(disk, size-512)
+			},
+			want: partitionTableGPT,
+		},
+		{
+			name: "GPT with 4k sectors",
+			setup: func(disk []byte) {
+				writeMBR(disk, mbrEntry{0x00, mbrTypeGPTProtective})
+				writeGPTHeader(disk, 4096)
+			},
+			want: partitionTableGPT,
+		},
+		{
+			name: "GPT with hybrid MBR",
+			setup: func(disk []byte) {
+				writeMBR(disk, mbrEntry{0x00, mbrTypeGPTProtective}, mbrEntry{0x80, 0x0c}, mbrEntry{0x00, 0x83})
+				writeGPTHeader(disk, 512)
+			},
+			want: partitionTableHybridGPT,
+		},
+		{
+			name: "GPT with hybrid MBR and protective entry last",
+			setup: func(disk []byte) {
+				writeMBR(disk, mbrEntry{0x80, 0x07}, mbrEntry{0x00, mbrTypeGPTProtective})
+				writeGPTHeader(disk, 512)
+			},
+			want: partitionTableHybridGPT,
+		},
+		{
+			name: "GPT with damaged primary header",
+			setup: func(disk []byte) {
+				writeMBR(disk, mbrEntry{0x00, mbrTypeGPTProtective})
+				writeGPTHeader(disk, 512)
+				disk[512+40] ^= 0xff
+				writeGPTHeader(disk, size-512)
+			},
+			want: partitionTableBackupGPT,
+		},
+		{
+			name: "Protective MBR without any GPT header",
+			setup: func(disk []byte) {
+				writeMBR(disk, mbrEntry{0x00, mbrTypeGPTProtective})
+			},
+			want: partitionTableNone,
+		},
+		{
+			name: "FAT filesystem boot sector",
+			setup: func(disk []byte) {
+				copy(disk[82:90], "FAT32   ")
+				disk[446] = 0x33 // Boot code overlapping the partition entries.
+				disk[450] = 0x0c
+				disk[510] = 0x55
+				disk[511] = 0xaa
+			},
+			want: partitionTableFilesystem,
+		},
+		{
+			name: "NTFS filesystem boot sector",
+			setup: func(disk []byte) {
+				copy(disk[3:11], "NTFS    ")
+				disk[510] = 0x55
+				disk[511] = 0xaa
+			},
+			want: partitionTableFilesystem,
+		},
+		{
+			name: "Boot sector with invalid partition entries",
+			setup: func(disk []byte) {
+				writeMBR(disk, mbrEntry{0x12, 0x83})
+			},
+			want: partitionTableNone,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			disk := make([]byte, size)
+			tt.setup(disk)
+
+			got, err := detectPartitionTable(bytes.NewReader(disk), size)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestDetectPartitionTableShortImage(t *testing.T) {
+	got, err := detectPartitionTable(bytes.NewReader([]byte{0x55, 0xaa}), 2)
+	assert.NoError(t, err)
+	assert.Equal(t, partitionTableNone, got)
+}
+
+func TestPartitionTableIssue(t *testing.T) {
+	for _, table := range []string{partitionTableMBR, partitionTableGPT, partitionTableHybridGPT} {
+		assert.Empty(t, partitionTableIssue(table), table)
+	}
+
+	assert.Contains(t, partitionTableIssue(partitionTableBackupGPT), "primary GPT header")
+	assert.Contains(t, partitionTableIssue(partitionTableNone), "doesn't look like a bootable disk")
+	assert.Contains(t, partitionTableIssue(partitionTableFilesystem), "doesn't look like a bootable disk")
+}
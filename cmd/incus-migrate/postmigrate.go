@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	incus "github.com/lxc/incus/v6/client"
+	"github.com/lxc/incus/v6/shared/api"
+	"github.com/lxc/incus/v6/shared/util"
+)
+
+// postMigrateSnapshotName is the name of the snapshot taken with --post-migrate-snapshot.
+const postMigrateSnapshotName = "post-migrate"
+
+// snapshotInstance takes a snapshot of the instance.
+func snapshotInstance(out io.Writer, server incus.InstanceServer, name string, snapshotName string) error {
+	op, err := server.CreateInstanceSnapshot(name, api.InstanceSnapshotsPost{Name: snapshotName})
+	if err != nil {
+		return err
+	}
+
+	err = op.Wait()
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "Instance %s snapshotted as %q\n", name, snapshotName)
+
+	return nil
+}
+
+// pauseInstance starts the instance and freezes it right away.
+func pauseInstance(out io.Writer, server incus.InstanceServer, name string) error {
+	for _, action := range []string{"start", "freeze"} {
+		op, err := server.UpdateInstanceState(name, api.InstanceStatePut{Action: action, Timeout: -1}, "")
+		if err != nil {
+			return err
+		}
+
+		err = op.Wait()
+		if err != nil {
+			return err
+		}
+	}
+
+	state, _, err := server.GetInstanceState(name)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "Instance %s is now %s\n", name, strings.ToLower(state.Status))
+
+	return nil
+}
+
+// disableScheduledJobs masks the cron daemon and the enabled systemd timers of a transferred container.
+func disableScheduledJobs(out io.Writer, server incus.InstanceServer, name string, rootfs string) error {
+	cronJobs, units := sourceScheduledJobs(rootfs)
+
+	cronService := sourceCronService(rootfs)
+	if len(cronJobs) > 0 && cronService != "" {
+		units = append(units, cronService)
+	}
+
+	for _, unit := range units {
+		err := maskUnit(out, server, name, rootfs, unit)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// maskUnit masks a systemd unit of a transferred container.
+func maskUnit(out io.Writer, server incus.InstanceServer, name string, rootfs string, unit string) error {
+	unitPath := filepath.Join("/etc/systemd/system", unit)
+
+	// Don't replace units defined by the administrator.
+	if isRegularFile(filepath.Join(rootfs, unitPath)) {
+		fmt.Fprintf(out, "WARNING: Not masking %s as %q is a unit file\n", unit, unitPath)
+		return nil
+	}
+
+	err := server.CreateInstanceFile(name, unitPath, incus.InstanceFileArgs{
+		Content:   strings.NewReader("/dev/null"),
+		Type:      "symlink",
+		WriteMode: "overwrite",
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to mask %s: %w", unit, err)
+	}
+
+	fmt.Fprintf(out, "Masked %s\n", unit)
+
+	return nil
+}
+
+// clearMachineID empties the machine ID of a transferred container so that a fresh one
+// gets generated on first boot, avoiding conflicts with the source (DHCP leases, journal, ...).
+func clearMachineID(out io.Writer, server incus.InstanceServer, name string, rootfs string) error {
+	if !util.PathExists(filepath.Join(rootfs, "etc", "machine-id")) {
+		return nil
+	}
+
+	// An empty file (rather than a missing one) has systemd generate the ID on boot.
+	err := server.CreateInstanceFile(name, "/etc/machine-id", incus.InstanceFileArgs{
+		Content:   strings.NewReader(""),
+		Mode:      0o444,
+		Type:      "file",
+		WriteMode: "overwrite",
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(out, "Cleared /etc/machine-id, a new machine ID gets generated on first boot")
+
+	// The D-Bus machine ID is usually a symlink to /etc/machine-id, only remove standalone copies.
+	info, err := os.Lstat(filepath.Join(rootfs, "var", "lib", "dbus", "machine-id"))
+	if err == nil && info.Mode().IsRegular() {
+		err = server.DeleteInstanceFile(name, "/var/lib/dbus/machine-id")
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(out, "Removed /var/lib/dbus/machine-id")
+	}
+
+	return nil
+}
+
+// finishInstance writes the netplan configuration and adapts the files of a container once the
+// instance is created, then snapshots and pauses it as requested. Failures are reported as
+// warnings, the data being in place already.
+func (c *cmdMigrate) finishInstance(server incus.InstanceServer, config *cmdMigrateData, path string, migrationType MigrationType) {
+	var err error
+
+	if config.Netplan != "" {
+		err = server.CreateInstanceFile(config.InstanceArgs.Name, netplanPath, incus.InstanceFileArgs{
+			Content:   strings.NewReader(config.Netplan),
+			Mode:      0o600,
+			Type:      "file",
+			WriteMode: "overwrite",
+		})
+		if err != nil {
+			fmt.Fprintf(c.out, "WARNING: Failed to write the netplan configuration to %q: %v\n", netplanPath, err)
+		} else {
+			fmt.Fprintf(c.out, "Netplan configuration written to %q\n", netplanPath)
+		}
+	}
+
+	if migrationType == MigrationTypeContainer && c.flagDisableTimers {
+		err = disableScheduledJobs(c.out, server, config.InstanceArgs.Name, path)
+		if err != nil {
+			fmt.Fprintf(c.out, "WARNING: Failed to disable the scheduled jobs: %v\n", err)
+		}
+	}
+
+	if migrationType == MigrationTypeContainer && c.flagContainerFixups {
+		err = applyContainerFixups(c.out, server, config.InstanceArgs.Name, path)
+		if err != nil {
+			fmt.Fprintf(c.out, "WARNING: Failed to apply the container fixups: %v\n", err)
+		}
+	}
+
+	if migrationType == MigrationTypeContainer && !c.flagKeepMachineID {
+		err = clearMachineID(c.out, server, config.InstanceArgs.Name, path)
+		if err != nil {
+			fmt.Fprintf(c.out, "WARNING: Failed to clear the machine ID: %v\n", err)
+		}
+	}
+
+	// The snapshot is taken once the files are adapted but before the instance ever runs.
+	if c.flagPostMigrateSnapshot {
+		err = snapshotInstance(c.out, server, config.InstanceArgs.Name, postMigrateSnapshotName)
+		if err != nil {
+			fmt.Fprintf(c.out, "WARNING: Failed to snapshot instance %q: %v\n", config.InstanceArgs.Name, err)
+		}
+	}
+
+	if c.flagCreatePaused {
+		err = pauseInstance(c.out, server, config.InstanceArgs.Name)
+		if err != nil {
+			fmt.Fprintf(c.out, "WARNING: Failed to start and freeze instance %q, check its state with `incus info`: %v\n", config.InstanceArgs.Name, err)
+		}
+	}
+}
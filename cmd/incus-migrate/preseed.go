@@ -0,0 +1,291 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+
+	"gopkg.in/yaml.v2"
+
+	incus "github.com/lxc/incus/v6/client"
+	"github.com/lxc/incus/v6/shared/api"
+	"github.com/lxc/incus/v6/shared/units"
+	"github.com/lxc/incus/v6/shared/util"
+)
+
+// migratePreseed is the content of a --config file, answering all the questions of a migration.
+//
+// The target is either a saved remote, a server URL along with credentials or, when neither
+// is set, the local server.
+type migratePreseed struct {
+	// Target server.
+	Remote            string `yaml:"remote,omitempty"`
+	Server            string `yaml:"server,omitempty"`
+	ServerFingerprint string `yaml:"server_fingerprint,omitempty"`
+	Certificate       string `yaml:"certificate,omitempty"`
+	Key               string `yaml:"key,omitempty"`
+	Token             string `yaml:"token,omitempty"`
+	Project           string `yaml:"project,omitempty"`
+	CreateProject     bool   `yaml:"create_project,omitempty"`
+	Target            string `yaml:"target,omitempty"`
+
+	// Source.
+	Type    MigrationType `yaml:"type"`
+	Source  string        `yaml:"source"`
+	Mounts  []string      `yaml:"mounts,omitempty"`
+	Exclude []string      `yaml:"exclude,omitempty"`
+	Disks   []string      `yaml:"disks,omitempty"`
+
+	// Instance or custom volume to create.
+	Name        string            `yaml:"name"`
+	Description string            `yaml:"description,omitempty"`
+	Profiles    []string          `yaml:"profiles,omitempty"`
+	Config      map[string]string `yaml:"config,omitempty"`
+	Pool        string            `yaml:"pool,omitempty"`
+	Size        string            `yaml:"size,omitempty"`
+	Network     string            `yaml:"network,omitempty"`
+	Firmware    string            `yaml:"firmware,omitempty"`
+}
+
+// loadPreseed reads and validates a --config file, the source being read from the standard
+// input with --source-stdin.
+func loadPreseed(path string, sourceStdin bool) (*migratePreseed, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read the configuration file: %w", err)
+	}
+
+	preseed := &migratePreseed{}
+
+	err = yaml.UnmarshalStrict(content, preseed)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse the configuration file %q: %w", path, err)
+	}
+
+	err = preseed.validate(sourceStdin)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid configuration file %q: %w", path, err)
+	}
+
+	return preseed, nil
+}
+
+// validate checks the fields which don't depend on the target server.
+//
+// A source of "-" stands for the standard input, as does an empty one with --source-stdin.
+func (p *migratePreseed) validate(sourceStdin bool) error {
+	if !slices.Contains([]MigrationType{MigrationTypeContainer, MigrationTypeVM, MigrationTypeVolumeFilesystem, MigrationTypeVolumeBlock}, p.Type) {
+		return fmt.Errorf("Invalid type %q (must be one of %s, %s, %s or %s)", p.Type, MigrationTypeContainer, MigrationTypeVM, MigrationTypeVolumeFilesystem, MigrationTypeVolumeBlock)
+	}
+
+	if p.Name == "" {
+		return errors.New("Missing name")
+	}
+
+	if sourceStdin && p.Source != "" && p.Source != "-" {
+		return errors.New("The source can't be set along with --source-stdin")
+	}
+
+	if p.Source == "" && !sourceStdin {
+		return errors.New("Missing source")
+	}
+
+	if p.Source == "-" || sourceStdin {
+		if p.Type != MigrationTypeVM && p.Type != MigrationTypeVolumeBlock {
+			return errors.New("The standard input can only be used as the source of virtual machines and block volumes")
+		}
+	} else if !util.PathExists(p.Source) {
+		return fmt.Errorf("Source %q doesn't exist", p.Source)
+	}
+
+	for _, mount := range p.Mounts {
+		if !util.PathExists(mount) {
+			return fmt.Errorf("Mount %q doesn't exist", mount)
+		}
+	}
+
+	isInstance := p.Type == MigrationTypeContainer || p.Type == MigrationTypeVM
+
+	if len(p.Disks) > 0 && p.Type != MigrationTypeVM {
+		return errors.New("Additional disks can only be set for virtual machines")
+	}
+
+	for _, disk := range p.Disks {
+		if !util.PathExists(disk) {
+			return fmt.Errorf("Disk %q doesn't exist", disk)
+		}
+	}
+
+	if (len(p.Mounts) > 0 || len(p.Exclude) > 0) && p.Type != MigrationTypeContainer {
+		return errors.New("Mounts and excluded paths can only be set for containers")
+	}
+
+	for _, exclude := range p.Exclude {
+		err := validateExclude(exclude)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !isInstance && (len(p.Profiles) > 0 || len(p.Config) > 0 || p.Network != "" || p.Description != "") {
+		return errors.New("Profiles, config, network and description can only be set for instances")
+	}
+
+	if !isInstance && p.Pool == "" {
+		return errors.New("Missing pool (required for custom volumes)")
+	}
+
+	if p.Firmware != "" {
+		if p.Type != MigrationTypeVM {
+			return errors.New("A firmware can only be set for virtual machines")
+		}
+
+		if !slices.Contains([]string{firmwareBIOS, firmwareUEFI, firmwareUEFISecureBoot}, p.Firmware) {
+			return fmt.Errorf("Invalid firmware %q (must be one of %q, %q or %q)", p.Firmware, firmwareBIOS, firmwareUEFI, firmwareUEFISecureBoot)
+		}
+	}
+
+	if p.Size != "" {
+		_, err := units.ParseByteSizeString(p.Size)
+		if err != nil {
+			return fmt.Errorf("Invalid size %q: %w", p.Size, err)
+		}
+
+		if isInstance && p.Pool == "" {
+			return errors.New("A size can only be set along with a pool")
+		}
+	}
+
+	if p.Remote != "" && p.Server != "" {
+		return errors.New("Only one of remote and server can be set")
+	}
+
+	if p.Server != "" {
+		if p.Token == "" && (p.Certificate == "" || p.Key == "") {
+			return errors.New("Either a token or a certificate and key are required to authenticate with the server")
+		}
+
+		for _, path := range []string{p.Certificate, p.Key} {
+			if path != "" && !util.PathExists(path) {
+				return fmt.Errorf("File %q doesn't exist", path)
+			}
+		}
+
+		_, err := parseURL(p.Server)
+		if err != nil {
+			return err
+		}
+	} else if p.ServerFingerprint != "" || p.Certificate != "" || p.Key != "" || p.Token != "" {
+		return errors.New("Server credentials can only be set along with a server URL")
+	}
+
+	return nil
+}
+
+// connectPreseed connects to the target server of a --config file.
+func (c *cmdMigrate) connectPreseed() (incus.InstanceServer, string, error) {
+	if c.preseed.Remote != "" {
+		return c.connectRemote(c.preseed.Remote)
+	}
+
+	if c.preseed.Server == "" {
+		server, err := c.connectLocal()
+		if err != nil {
+			return nil, "", fmt.Errorf("Failed to connect to the local server (set server or remote in the configuration file to use another one): %w", err)
+		}
+
+		return server, "", nil
+	}
+
+	serverURL, err := parseURL(c.preseed.Server)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Nobody is there to check the certificate of the server, so unless the system CA trusts it,
+	// it must match server_fingerprint.
+	serverCert, err := getServerCertificate(serverURL, c.preseed.ServerFingerprint, nil)
+	if err != nil {
+		if c.preseed.ServerFingerprint == "" {
+			return nil, "", fmt.Errorf("%w (set server_fingerprint in the configuration file)", err)
+		}
+
+		return nil, "", err
+	}
+
+	return c.connectTarget(serverURL, serverCert, c.preseed.Certificate, c.preseed.Key, api.AuthenticationMethodTLS, c.preseed.Token)
+}
+
+// applyPreseedProfiles applies the profiles and configuration keys of a --config file to the instance.
+func (c *cmdMigrate) applyPreseedProfiles(server incus.InstanceServer, config *cmdMigrateData) error {
+	if c.preseed.Profiles != nil {
+		profileNames, err := server.GetProfileNames()
+		if err != nil {
+			return err
+		}
+
+		for _, profile := range c.preseed.Profiles {
+			if !slices.Contains(profileNames, profile) {
+				return fmt.Errorf("Unknown profile %q", profile)
+			}
+		}
+
+		// An empty list means no profiles at all.
+		config.InstanceArgs.Profiles = c.preseed.Profiles
+	}
+
+	maps.Copy(config.InstanceArgs.Config, c.preseed.Config)
+
+	return nil
+}
+
+// applyPreseedStorage applies the storage pool, size and network of a --config file to the instance.
+func (c *cmdMigrate) applyPreseedStorage(server incus.InstanceServer, config *cmdMigrateData) error {
+	if c.preseed.Pool != "" {
+		_, _, err := server.GetStoragePool(c.preseed.Pool)
+		if err != nil {
+			return fmt.Errorf("Storage pool %q: %w", c.preseed.Pool, err)
+		}
+
+		config.InstanceArgs.Devices["root"] = map[string]string{
+			"type": "disk",
+			"pool": c.preseed.Pool,
+			"path": "/",
+		}
+
+		if c.preseed.Size != "" {
+			err = c.checkRequestedSize(config, c.preseed.Size, config.InstanceArgs.Type == api.InstanceTypeVM && !c.flagAsVM)
+			if err != nil {
+				return fmt.Errorf("Invalid size %q: %w", c.preseed.Size, err)
+			}
+
+			config.InstanceArgs.Devices["root"]["size"] = c.preseed.Size
+		}
+
+		if config.InstanceArgs.Type == api.InstanceTypeContainer && c.flagFSType != "" {
+			config.InstanceArgs.Devices["root"]["initial.block.filesystem"] = c.flagFSType
+		}
+
+		if config.InstanceArgs.Type == api.InstanceTypeVM && c.flagStateSize != "" {
+			config.InstanceArgs.Devices["root"]["size.state"] = c.flagStateSize
+		}
+	}
+
+	if c.preseed.Network != "" {
+		_, _, err := server.GetNetwork(c.preseed.Network)
+		if err != nil {
+			return fmt.Errorf("Network %q: %w", c.preseed.Network, err)
+		}
+
+		config.InstanceArgs.Devices["eth0"] = map[string]string{
+			"type":    "nic",
+			"nictype": "bridged",
+			"parent":  c.preseed.Network,
+			"name":    "eth0",
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadPreseed(t *testing.T) {
+	source := t.TempDir()
+
+	tests := []struct {
+		name        string
+		content     string
+		sourceStdin bool
+		want        *migratePreseed
+		wantErr     string
+	}{
+		{
+			name: "Container on a server",
+			content: `type: container
+source: SOURCE
+name: web01
+server: https://incus.example.net:8443
+token: abc
+server_fingerprint: 0123
+project: web
+create_project: true
+profiles: [default, web]
+config:
+  limits.cpu: "2"
+pool: default
+size: 20GiB
+network: incusbr0
+`,
+			want: &migratePreseed{
+				Server:            "https://incus.example.net:8443",
+				ServerFingerprint: "0123",
+				Token:             "abc",
+				Project:           "web",
+				CreateProject:     true,
+				Type:              MigrationTypeContainer,
+				Source:            "SOURCE",
+				Name:              "web01",
+				Profiles:          []string{"default", "web"},
+				Config:            map[string]string{"limits.cpu": "2"},
+				Pool:              "default",
+				Size:              "20GiB",
+				Network:           "incusbr0",
+			},
+		},
+		{
+			name: "Defaults",
+			content: `type: virtual-machine
+source: SOURCE
+name: vm01
+`,
+			want: &migratePreseed{
+				Type:   MigrationTypeVM,
+				Source: "SOURCE",
+				Name:   "vm01",
+			},
+		},
+		{
+			name: "No profiles",
+			content: `type: container
+source: SOURCE
+name: web01
+profiles: []
+`,
+			want: &migratePreseed{
+				Type:     MigrationTypeContainer,
+				Source:   "SOURCE",
+				Name:     "web01",
+				Profiles: []string{},
+			},
+		},
+		{
+			name: "Source from the standard input",
+			content: `type: volume-block
+name: data
+pool: default
+`,
+			sourceStdin: true,
+			want: &migratePreseed{
+				Type: MigrationTypeVolumeBlock,
+				Name: "data",
+				Pool: "default",
+			},
+		},
+		{
+			name: "Unknown key",
+			content: `type: container
+source: SOURCE
+name: web01
+profile: default
+`,
+			wantErr: "field profile not found",
+		},
+		{
+			name: "Invalid type",
+			content: `type: lxc
+source: SOURCE
+name: web01
+`,
+			wantErr: `Invalid type "lxc"`,
+		},
+		{
+			name: "Missing name",
+			content: `type: container
+source: SOURCE
+`,
+			wantErr: "Missing name",
+		},
+		{
+			name: "Remote and server",
+			content: `type: container
+source: SOURCE
+name: web01
+remote: backup
+server: https://incus.example.net:8443
+token: abc
+`,
+			wantErr: "Only one of remote and server can be set",
+		},
+		{
+			name: "Credentials without a server",
+			content: `type: container
+source: SOURCE
+name: web01
+token: abc
+`,
+			wantErr: "Server credentials can only be set along with a server URL",
+		},
+		{
+			name: "Server without credentials",
+			content: `type: container
+source: SOURCE
+name: web01
+server: https://incus.example.net:8443
+`,
+			wantErr: "Either a token or a certificate and key are required",
+		},
+		{
+			name: "Source along with the standard input",
+			content: `type: virtual-machine
+source: SOURCE
+name: vm01
+`,
+			sourceStdin: true,
+			wantErr:     "The source can't be set along with --source-stdin",
+		},
+		{
+			name: "Standard input for a container",
+			content: `type: container
+source: "-"
+name: web01
+`,
+			wantErr: "The standard input can only be used as the source of virtual machines and block volumes",
+		},
+		{
+			name: "Disks of a container",
+			content: `type: container
+source: SOURCE
+name: web01
+disks: [SOURCE]
+`,
+			wantErr: "Additional disks can only be set for virtual machines",
+		},
+		{
+			name: "Firmware of a container",
+			content: `type: container
+source: SOURCE
+name: web01
+firmware: bios
+`,
+			wantErr: "A firmware can only be set for virtual machines",
+		},
+		{
+			name: "Profiles of a custom volume",
+			content: `type: volume-filesystem
+source: SOURCE
+name: data
+pool: default
+profiles: [default]
+`,
+			wantErr: "Profiles, config, network and description can only be set for instances",
+		},
+		{
+			name: "Custom volume without a pool",
+			content: `type: volume-filesystem
+source: SOURCE
+name: data
+`,
+			wantErr: "Missing pool",
+		},
+		{
+			name: "Size without a pool",
+			content: `type: container
+source: SOURCE
+name: web01
+size: 20GiB
+`,
+			wantErr: "A size can only be set along with a pool",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			err := os.WriteFile(path, []byte(strings.ReplaceAll(tt.content, "SOURCE", source)), 0o600)
+			assert.NoError(t, err)
+
+			got, err := loadPreseed(path, tt.sourceStdin)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+
+			if tt.want.Source == "SOURCE" {
+				tt.want.Source = source
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
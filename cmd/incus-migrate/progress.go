@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/lxc/incus/v6/shared/api"
+)
+
+// progressInterval is the minimum time between two progress events caused by transferred data.
+const progressInterval = time.Second
+
+// ProgressEvent is a progress event as written by --report-progress-to, one JSON object per line.
+//
+// The event schema is stable, new fields may get added but existing ones won't change.
+type ProgressEvent struct {
+	// Time at which the event was emitted.
+	Timestamp time.Time `json:"timestamp"`
+
+	// Current phase of the migration (setup, converting, transferring, transferring to URL, completed or failed).
+	Phase string `json:"phase"`
+
+	// Amount of data sent so far.
+	Bytes int64 `json:"bytes"`
+
+	// Percentage of the source sent so far, only set once the size of the source is known.
+	Percent *int `json:"percent,omitempty"`
+
+	// Status of the server side operation, if any.
+	Status string `json:"status,omitempty"`
+
+	// Error which caused the migration to fail.
+	Error string `json:"error,omitempty"`
+}
+
+// progressReporter writes progress events as newline-delimited JSON.
+// All methods are no-ops on a nil reporter.
+type progressReporter struct {
+	w io.WriteCloser
+
+	mu       sync.Mutex
+	phase    string
+	status   string
+	bytes    int64
+	total    int64
+	lastEmit time.Time
+}
+
+// newProgressReporter opens the file or file descriptor (if numeric) to report progress to.
+func newProgressReporter(target string) (*progressReporter, error) {
+	fd, err := strconv.Atoi(target)
+	if err == nil {
+		if fd < 0 {
+			return nil, fmt.Errorf("Invalid file descriptor %d", fd)
+		}
+
+		// The standard streams carry the output of the tool and its questions.
+		if fd <= 2 {
+			return nil, fmt.Errorf("Invalid file descriptor %d, the standard streams can't be used", fd)
+		}
+
+		_, err = unix.FcntlInt(uintptr(fd), unix.F_GETFD, 0)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid file descriptor %d: %w", fd, err)
+		}
+
+		return &progressReporter{w: os.NewFile(uintptr(fd), "progress"), phase: "setup"}, nil
+	}
+
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &progressReporter{w: f, phase: "setup"}, nil
+}
+
+// setPhase reports a new phase.
+func (r *progressReporter) setPhase(phase string) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.phase = phase
+	r.status = ""
+	r.emit("")
+}
+
+// setTotal records the size of the source, used to compute the percentage.
+func (r *progressReporter) setTotal(total int64) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.total = total
+}
+
+// addBytes records transferred data, only reporting it every so often.
+func (r *progressReporter) addBytes(n int64) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.bytes += n
+
+	if time.Since(r.lastEmit) >= progressInterval {
+		r.emit("")
+	}
+}
+
+// updateOp is an operation handler reporting changes of the operation status.
+func (r *progressReporter) updateOp(op api.Operation) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if op.Status == r.status {
+		return
+	}
+
+	r.status = op.Status
+	r.emit("")
+}
+
+// done reports the outcome of the migration and closes the reporter.
+func (r *progressReporter) done(err error) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.status = ""
+
+	if err != nil {
+		r.phase = "failed"
+		r.emit(err.Error())
+	} else {
+		r.phase = "completed"
+		r.emit("")
+	}
+
+	_ = r.w.Close()
+}
+
+// emit writes an event, the caller is responsible for locking.
+func (r *progressReporter) emit(errMsg string) {
+	r.lastEmit = time.Now()
+
+	event := ProgressEvent{
+		Timestamp: r.lastEmit.UTC(),
+		Phase:     r.phase,
+		Bytes:     r.bytes,
+		Status:    r.status,
+		Error:     errMsg,
+	}
+
+	if r.total > 0 {
+		percent := int(min(r.bytes*100/r.total, 100))
+		event.Percent = &percent
+	}
+
+	content, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+
+	_, _ = r.w.Write(append(content, '\n'))
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	incus "github.com/lxc/incus/v6/client"
+	"github.com/lxc/incus/v6/shared/api"
+)
+
+func (c *cmdMigrate) askProject(server incus.InstanceServer, config *cmdMigrateData) error {
+	projectNames, err := server.GetProjectNames()
+	if err != nil {
+		return err
+	}
+
+	config.Project = api.ProjectDefaultName
+
+	if c.preseed != nil {
+		if c.preseed.Project != "" {
+			if !slices.Contains(projectNames, c.preseed.Project) {
+				if !c.preseed.CreateProject {
+					return fmt.Errorf("Project %q doesn't exist (set create_project to create it)", c.preseed.Project)
+				}
+
+				err = c.createProject(server, c.preseed.Project)
+				if err != nil {
+					return err
+				}
+			}
+
+			config.Project = c.preseed.Project
+		}
+	} else if len(projectNames) > 1 {
+		slices.Sort(projectNames)
+
+		for {
+			project, err := c.global.asker.AskString(fmt.Sprintf("Project to create the instance in (%s) [default=default]: ", strings.Join(projectNames, ", ")), api.ProjectDefaultName, nil)
+			if err != nil {
+				return err
+			}
+
+			if !slices.Contains(projectNames, project) {
+				// Guard against typos in the name of an existing project.
+				create, err := c.global.asker.AskBool(fmt.Sprintf("Project %q doesn't exist, create it? [default=no]: ", project), "no")
+				if err != nil {
+					return err
+				}
+
+				if !create {
+					continue
+				}
+
+				err = c.createProject(server, project)
+				if err != nil {
+					return err
+				}
+			}
+
+			config.Project = project
+			break
+		}
+	}
+
+	// Catch permission problems before asking any further questions.
+	if config.Project == c.dryRunProject {
+		return checkProjectAccess(server, api.ProjectDefaultName)
+	}
+
+	return checkProjectAccess(server, config.Project)
+}
+
+// createProject creates the project to migrate into. Its instances use the profiles of the
+// default project, which come with a root disk and a network interface, rather than an empty
+// default profile of its own. It's deleted again if nothing gets migrated into it (see
+// deleteCreatedProject).
+func (c *cmdMigrate) createProject(server incus.InstanceServer, name string) error {
+	if c.flagDryRun {
+		fmt.Fprintf(c.out, "Project %q would be created, checking against the default project instead\n", name)
+		c.dryRunProject = name
+		return nil
+	}
+
+	project := api.ProjectsPost{
+		Name: name,
+		ProjectPut: api.ProjectPut{
+			Description: "Created by incus-migrate",
+			Config:      map[string]string{"features.profiles": "false"},
+		},
+	}
+
+	err := server.CreateProject(project)
+	if err != nil {
+		return fmt.Errorf("Failed to create project %q: %w", name, err)
+	}
+
+	fmt.Fprintf(c.out, "Project %q created\n", name)
+	c.createdProject = name
+
+	return nil
+}
+
+// useProject returns the server set to use the given project. A project which would only be
+// created in dry run mode doesn't exist yet, so the default project, whose profiles its instances
+// would use, is queried instead.
+func (c *cmdMigrate) useProject(server incus.InstanceServer, project string) incus.InstanceServer {
+	if project == c.dryRunProject {
+		return server.UseProject(api.ProjectDefaultName)
+	}
+
+	return server.UseProject(project)
+}
+
+// deleteCreatedProject deletes the project created for the migration when nothing ended up in it,
+// because the migration was cancelled or failed.
+func (c *cmdMigrate) deleteCreatedProject(server incus.InstanceServer, name string) {
+	if name == "" {
+		return
+	}
+
+	project, _, err := server.GetProject(name)
+	if err != nil || len(project.UsedBy) > 0 {
+		return
+	}
+
+	err = server.DeleteProject(name)
+	if err != nil {
+		fmt.Fprintf(c.out, "WARNING: Failed to delete project %q: %v\n", name, err)
+		return
+	}
+
+	fmt.Fprintf(c.out, "Project %q deleted, nothing was migrated into it\n", name)
+}
+
+// checkProjectAccess runs a few harmless queries against the project to detect permission problems early.
+func checkProjectAccess(server incus.InstanceServer, project string) error {
+	server = server.UseProject(project)
+
+	_, err := server.GetInstanceNames(api.InstanceTypeAny)
+	if err != nil {
+		if api.StatusErrorCheck(err, http.StatusForbidden) {
+			return fmt.Errorf("Not allowed to access instances in project %q, check the permissions granted to this client: %w", project, err)
+		}
+
+		return fmt.Errorf("Failed to list instances in project %q: %w", project, err)
+	}
+
+	_, err = server.GetStoragePoolNames()
+	if err != nil {
+		if api.StatusErrorCheck(err, http.StatusForbidden) {
+			return fmt.Errorf("Not allowed to access storage pools in project %q, check the permissions granted to this client: %w", project, err)
+		}
+
+		return fmt.Errorf("Failed to list storage pools in project %q: %w", project, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	incus "github.com/lxc/incus/v6/client"
+	"github.com/lxc/incus/v6/shared/api"
+)
+
+// migrationResult is the result of a migration as printed with --format json.
+type migrationResult struct {
+	Type             MigrationType `json:"type"`
+	Name             string        `json:"name"`
+	Project          string        `json:"project"`
+	Pool             string        `json:"pool,omitempty"`
+	Target           string        `json:"target,omitempty"`
+	ElapsedSeconds   float64       `json:"elapsed_seconds"`
+	BytesTransferred int64         `json:"bytes_transferred"`
+}
+
+// printResult prints the result of a successful migration with --format json. The migration
+// already succeeded, so failing to gather its details only leads to warnings.
+func (c *cmdMigrate) printResult(server incus.InstanceServer, config *cmdMigrateData, migrationType MigrationType) {
+	if c.flagFormat != "json" {
+		return
+	}
+
+	result := migrationResult{
+		Type:    migrationType,
+		Project: config.Project,
+		Target:  config.Target,
+	}
+
+	if result.Project == "" {
+		result.Project = api.ProjectDefaultName
+	} else {
+		server = c.useProject(server, config.Project)
+	}
+
+	if migrationType == MigrationTypeVolumeBlock || migrationType == MigrationTypeVolumeFilesystem {
+		result.Name = config.CustomVolumeArgs.Name
+		result.Pool = config.Pool
+	} else {
+		result.Name = config.InstanceArgs.Name
+
+		// The pool may come from a profile.
+		pool, err := rootDiskPool(server, config)
+		if err != nil {
+			fmt.Fprintf(c.out, "WARNING: Failed to get the storage pool of the instance: %v\n", err)
+		}
+
+		result.Pool = pool
+	}
+
+	if c.checkpoint != nil {
+		result.ElapsedSeconds = time.Since(c.checkpoint.Started).Round(time.Millisecond).Seconds()
+		result.BytesTransferred = c.checkpoint.BytesTransferred
+	}
+
+	content, err := json.Marshal(result)
+	if err != nil {
+		fmt.Fprintf(c.out, "WARNING: Failed to print the result of the migration: %v\n", err)
+		return
+	}
+
+	fmt.Println(string(content))
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	incus "github.com/lxc/incus/v6/client"
+	"github.com/lxc/incus/v6/shared/api"
+)
+
+// retryTransfer runs a transfer, retrying it with an exponential backoff when data failed to
+// transfer (see --retries). Retries refresh the partially transferred instance or volume, which
+// lets rsync pick up the partially transferred files.
+func (c *cmdMigrate) retryTransfer(ctx context.Context, config *cmdMigrateData, phase string, transfer func() error) error {
+	resume := config.Resume
+	defer func() {
+		config.Resume = resume
+		config.RetriesLeft = 0
+	}()
+
+	for attempt := 1; ; attempt++ {
+		config.RetriesLeft = c.flagRetries + 1 - attempt
+
+		err := transfer()
+
+		// Other errors, like the instance already existing, wouldn't go away.
+		var errTransfer transferError
+		if err == nil || attempt > c.flagRetries || !errors.As(err, &errTransfer) || ctx.Err() != nil {
+			return err
+		}
+
+		delay := min(retryDelay<<(attempt-1), retryMaxDelay)
+
+		fmt.Fprintf(c.out, "Transfer failed (attempt %d of %d): %v\n", attempt, c.flagRetries+1, err)
+		fmt.Fprintf(c.out, "Retrying in %s\n", delay)
+		c.setPhase("retrying")
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+
+		config.Resume = true
+		c.setPhase(phase)
+	}
+}
+
+// keepPartial returns whether to keep what a failed transfer created rather than deleting it,
+// either for a later --resume or for the next attempt when the transfer gets retried.
+func (c *cmdMigrate) keepPartial(ctx context.Context, config *cmdMigrateData, err error) bool {
+	// Resuming wouldn't send the differing files again, they have the same size and time.
+	var errVerification verificationError
+	if errors.As(err, &errVerification) {
+		return false
+	}
+
+	if c.flagResume {
+		return true
+	}
+
+	var errTransfer transferError
+
+	return config.RetriesLeft > 0 && errors.As(err, &errTransfer) && ctx.Err() == nil
+}
+
+// transferTimeoutError tells a transfer which was cancelled because it went past --timeout apart
+// from other failures, which would otherwise only show up as a closed connection.
+func (c *cmdMigrate) transferTimeoutError(ctx context.Context, err error) error {
+	if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return err
+	}
+
+	return fmt.Errorf("Transfer timed out after %s (see --timeout): %w", c.flagTimeout, err)
+}
+
+// migratePartialKey marks the instances and custom volumes created by incus-migrate until their
+// transfer completes, the transfer can only be resumed into those.
+const migratePartialKey = "user.incus-migrate.partial"
+
+// clearInstancePartial removes the mark of an incomplete transfer from an instance.
+func clearInstancePartial(server incus.InstanceServer, name string) error {
+	inst, etag, err := server.GetInstance(name)
+	if err != nil {
+		return err
+	}
+
+	delete(inst.Config, migratePartialKey)
+
+	op, err := server.UpdateInstance(name, inst.Writable(), etag)
+	if err != nil {
+		return fmt.Errorf("Failed to mark instance %q as complete: %w", name, err)
+	}
+
+	return op.Wait()
+}
+
+// clearVolumePartial removes the mark of an incomplete transfer from a custom volume.
+func clearVolumePartial(server incus.InstanceServer, pool string, name string) error {
+	volume, etag, err := server.GetStoragePoolVolume(pool, "custom", name)
+	if err != nil {
+		return err
+	}
+
+	delete(volume.Config, migratePartialKey)
+
+	err = server.UpdateStoragePoolVolume(pool, "custom", name, volume.Writable(), etag)
+	if err != nil {
+		return fmt.Errorf("Failed to mark storage volume %q as complete: %w", name, err)
+	}
+
+	return nil
+}
+
+// askResumeInstance returns whether to resume the transfer into an existing instance, which is
+// only offered for stopped instances left behind by an incomplete migration (see migratePartialKey).
+func (c *cmdMigrate) askResumeInstance(server incus.InstanceServer, name string, instanceType api.InstanceType) (bool, error) {
+	// Other targets may hold an unrelated instance of the same name.
+	if len(c.additionalTargets) > 0 {
+		return false, nil
+	}
+
+	inst, _, err := server.GetInstance(name)
+	if err != nil {
+		return false, err
+	}
+
+	if inst.Config[migratePartialKey] != "true" || inst.Type != string(instanceType) || inst.StatusCode != api.Stopped {
+		return false, nil
+	}
+
+	if instanceType == api.InstanceTypeVM {
+		fmt.Fprintln(c.out, "The disk of a virtual machine is always transferred in full, only the transfer of its configuration is resumed")
+	}
+
+	if c.flagResume {
+		fmt.Fprintf(c.out, "Resuming the transfer into instance %q\n", name)
+		return true, nil
+	}
+
+	if c.preseed != nil {
+		return false, nil
+	}
+
+	return c.global.asker.AskBool(fmt.Sprintf("Instance %q was left behind by an incomplete migration, resume the transfer into it? [default=no]: ", name), "no")
+}
+
+// askResumeVolume returns whether to resume the transfer into an existing custom volume, which is
+// only offered for volumes left behind by an incomplete migration (see migratePartialKey).
+func (c *cmdMigrate) askResumeVolume(server incus.InstanceServer, pool string, name string, contentType string) (bool, error) {
+	if len(c.additionalTargets) > 0 {
+		return false, nil
+	}
+
+	volume, _, err := server.GetStoragePoolVolume(pool, "custom", name)
+	if err != nil {
+		return false, err
+	}
+
+	if volume.Config[migratePartialKey] != "true" || volume.ContentType != contentType {
+		return false, nil
+	}
+
+	if contentType == "block" {
+		fmt.Fprintln(c.out, "Block volumes are always transferred in full")
+	}
+
+	if c.flagResume {
+		fmt.Fprintf(c.out, "Resuming the transfer into storage volume %q\n", name)
+		return true, nil
+	}
+
+	if c.preseed != nil {
+		return false, nil
+	}
+
+	return c.global.asker.AskBool(fmt.Sprintf("Storage volume %q was left behind by an incomplete migration, resume the transfer into it? [default=no]: ", name), "no")
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"slices"
+
+	"golang.org/x/sys/unix"
+	"gopkg.in/yaml.v2"
+
+	"github.com/lxc/incus/v6/shared/units"
+)
+
+// scanLargeFileSize is the size from which files get listed in the scan report.
+const scanLargeFileSize = 1024 * 1024 * 1024
+
+// scanLargeFilesMax is the number of large files listed in the scan report.
+const scanLargeFilesMax = 10
+
+// scanReport is the readiness report of a source.
+type scanReport struct {
+	Source           string        `yaml:"Source"`
+	Type             MigrationType `yaml:"Type"`
+	Format           string        `yaml:"Format,omitempty"`
+	FormatEvidence   string        `yaml:"Format detection,omitempty"`
+	Architecture     string        `yaml:"Architecture,omitempty"`
+	OS               string        `yaml:"Operating system,omitempty"`
+	InitSystem       string        `yaml:"Init system,omitempty"`
+	Size             string        `yaml:"Size"`
+	Files            int64         `yaml:"Files,omitempty"`
+	LargeFiles       []string      `yaml:"Large files,omitempty"`
+	Mounts           []string      `yaml:"Mounts offered as additional mounts,omitempty"`
+	SkippedMounts    []string      `yaml:"Mounts not offered,omitempty"`
+	Swap             []string      `yaml:"Swap,omitempty"`
+	CronJobs         []string      `yaml:"Cron jobs,omitempty"`
+	Timers           []string      `yaml:"Systemd timers,omitempty"`
+	FileCapabilities []string      `yaml:"File capabilities,omitempty"`
+	Issues           []string      `yaml:"Issues,omitempty"`
+}
+
+// scanSource analyzes a source and prints a readiness report, without connecting to any server.
+func (c *cmdMigrate) scanSource() error {
+	scanType, err := c.global.asker.AskInt(`
+What would you like to scan?
+1) Container
+2) Virtual Machine
+3) Custom Volume (from filesystem)
+4) Custom Volume (from disk)
+
+Please enter the number of your choice: `, 1, 4, "", nil)
+	if err != nil {
+		return err
+	}
+
+	migrationType := []MigrationType{MigrationTypeContainer, MigrationTypeVM, MigrationTypeVolumeFilesystem, MigrationTypeVolumeBlock}[scanType-1]
+
+	config := cmdMigrateData{}
+	config.InstanceArgs.Config = map[string]string{}
+
+	err = c.askSourcePath(&config, migrationType)
+	if err != nil {
+		return err
+	}
+
+	report := scanReport{
+		Source: config.SourcePath,
+		Type:   migrationType,
+	}
+
+	empty, err := isEmptySource(config.SourcePath)
+	if err != nil {
+		return fmt.Errorf("Failed to read %q: %w", config.SourcePath, err)
+	}
+
+	if empty != "" {
+		report.Issues = append(report.Issues, fmt.Sprintf("The source %s", empty))
+	}
+
+	fmt.Fprintf(c.out, "Scanning %q\n", config.SourcePath)
+
+	if migrationType == MigrationTypeVM || migrationType == MigrationTypeVolumeBlock {
+		err = c.scanDisk(&report, migrationType)
+	} else {
+		err = c.scanFilesystem(&report, migrationType)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if len(report.Issues) == 0 {
+		fmt.Fprintln(c.out, "\nNo issue found, the source is ready to be migrated.")
+	} else {
+		fmt.Fprintln(c.out, "\nPotential issues found, see the report below.")
+	}
+
+	content, err := yaml.Marshal(&report)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(c.out, "\n%s", content)
+
+	return nil
+}
+
+// scanDisk fills the report for a disk source.
+func (c *cmdMigrate) scanDisk(report *scanReport, migrationType MigrationType) error {
+	format, evidence := detectImageFormat(report.Source)
+	report.Format = detectSourceFormat(report.Source)
+	report.FormatEvidence = evidence
+
+	size, err := diskSize(report.Source)
+	if err != nil {
+		return fmt.Errorf("Failed to read %q: %w", report.Source, err)
+	}
+
+	if format != imageFormatRaw {
+		virtualSize, err := imageVirtualSize(report.Source)
+		if err != nil {
+			report.Issues = append(report.Issues, fmt.Sprintf("Failed to get the disk size from the %s image: %v", format, err))
+		} else {
+			size = virtualSize
+		}
+
+		report.Size = units.GetByteSizeStringIEC(size, 2)
+
+		return nil
+	}
+
+	report.Size = units.GetByteSizeStringIEC(size, 2)
+
+	if migrationType != MigrationTypeVM {
+		return nil
+	}
+
+	// Virtual machines need a whole disk, not a partition or a filesystem image.
+	table, err := detectPartitionTableFromPath(report.Source)
+	if err == nil && partitionTableIssue(table) != "" {
+		report.Issues = append(report.Issues, partitionTableIssue(table))
+	}
+
+	hostArchitecture, err := c.instanceArchitecture()
+	if err != nil {
+		return err
+	}
+
+	report.Architecture, err = detectImageArchitectureFromPath(report.Source)
+	if err != nil {
+		return fmt.Errorf("Failed to read %q: %w", report.Source, err)
+	}
+
+	if report.Architecture != "" && !architecturesCompatible(report.Architecture, hostArchitecture) {
+		report.Issues = append(report.Issues, fmt.Sprintf("The source disk looks like a %s system, it won't boot as a %s virtual machine (use --architecture to change it)", report.Architecture, hostArchitecture))
+	}
+
+	return nil
+}
+
+// scanFilesystem fills the report for a filesystem source.
+func (c *cmdMigrate) scanFilesystem(report *scanReport, migrationType MigrationType) error {
+	size, files, largeFiles, err := walkSourceFilesystem(report.Source)
+	if err != nil {
+		return fmt.Errorf("Failed to read %q: %w", report.Source, err)
+	}
+
+	report.Size = units.GetByteSizeStringIEC(size, 2)
+	report.Files = files
+
+	for _, file := range largeFiles {
+		report.LargeFiles = append(report.LargeFiles, fmt.Sprintf("%s (%s)", file.path, units.GetByteSizeStringIEC(file.size, 2)))
+	}
+
+	// Filesystems mounted below the source aren't part of it, they're only transferred when added
+	// as additional mounts.
+	mounts, err := sourceAllSubMounts(report.Source)
+	if err != nil {
+		return err
+	}
+
+	paths := []string{report.Source}
+
+	for _, mount := range mounts {
+		description := fmt.Sprintf("%s (%s, %s)", mount.MountPoint, mount.FSType, mount.Source)
+
+		if slices.Contains(pseudoFilesystems, mount.FSType) {
+			report.SkippedMounts = append(report.SkippedMounts, fmt.Sprintf("%s: pseudo filesystem", description))
+			continue
+		}
+
+		reason := removableMountReason(mount)
+		if reason != "" {
+			report.SkippedMounts = append(report.SkippedMounts, fmt.Sprintf("%s: %s (use --include-removable to offer it)", description, reason))
+			continue
+		}
+
+		report.Mounts = append(report.Mounts, description)
+		paths = append(paths, mount.MountPoint)
+	}
+
+	report.Issues = append(report.Issues, sourceFilesystemWarnings(paths)...)
+
+	if migrationType != MigrationTypeContainer {
+		return nil
+	}
+
+	report.OS = sourceOSName(report.Source)
+	report.InitSystem = sourceInitSystem(report.Source)
+	if report.InitSystem == "" {
+		report.Issues = append(report.Issues, "No init system found, the container won't boot")
+	}
+
+	report.Swap = sourceSwap(report.Source)
+	if len(report.Swap) > 0 {
+		report.Issues = append(report.Issues, "Swap isn't available in containers, the swap entries of /etc/fstab will fail (use --container-fixups to disable them)")
+	}
+
+	report.CronJobs, report.Timers = sourceScheduledJobs(report.Source)
+
+	report.FileCapabilities, err = sourceFileCapabilities(report.Source)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// largeFile is a file listed in the scan report for its size.
+type largeFile struct {
+	path string
+	size int64
+}
+
+// walkSourceFilesystem returns the size and number of files of the filesystem at the provided
+// path, along with its largest files. Filesystems mounted below it aren't walked.
+func walkSourceFilesystem(root string) (int64, int64, []largeFile, error) {
+	var rootStat unix.Stat_t
+
+	err := unix.Stat(root, &rootStat)
+	if err != nil {
+		return -1, -1, nil, err
+	}
+
+	var size int64
+	var files int64
+	largeFiles := []largeFile{}
+
+	err = filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			// Unreadable entries won't be transferred either.
+			if errors.Is(err, fs.ErrPermission) && path != root {
+				return nil
+			}
+
+			return err
+		}
+
+		// Don't cross into other filesystems, those are reported as mounts.
+		if entry.IsDir() {
+			var stat unix.Stat_t
+
+			err := unix.Lstat(path, &stat)
+			if err == nil && stat.Dev != rootStat.Dev {
+				return fs.SkipDir
+			}
+		}
+
+		files++
+
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+
+		size += info.Size()
+
+		if info.Size() >= scanLargeFileSize {
+			largeFiles = append(largeFiles, largeFile{path: path, size: info.Size()})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return -1, -1, nil, err
+	}
+
+	slices.SortFunc(largeFiles, func(a largeFile, b largeFile) int { return cmp.Compare(b.size, a.size) })
+	if len(largeFiles) > scanLargeFilesMax {
+		largeFiles = largeFiles[:scanLargeFilesMax]
+	}
+
+	return size, files, largeFiles, nil
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	incus "github.com/lxc/incus/v6/client"
+	"github.com/lxc/incus/v6/internal/version"
+	"github.com/lxc/incus/v6/shared/api"
+	localtls "github.com/lxc/incus/v6/shared/tls"
+	"github.com/lxc/incus/v6/shared/util"
+)
+
+func (c *cmdMigrate) askServer() (incus.InstanceServer, string, error) {
+	// Use the server from the --config file.
+	if c.preseed != nil {
+		return c.connectPreseed()
+	}
+
+	// Use a saved remote.
+	if c.flagRemote != "" {
+		return c.connectRemote(c.flagRemote)
+	}
+
+	// Detect local server.
+	local, err := c.connectLocal()
+	if err == nil {
+		useLocal, err := c.global.asker.AskBool("The local Incus server is the target [default=yes]: ", "yes")
+		if err != nil {
+			return nil, "", err
+		}
+
+		if useLocal {
+			return local, "", nil
+		}
+	}
+
+	// Server address
+	serverURL, err := c.global.asker.AskString("Please provide Incus server URL: ", "", nil)
+	if err != nil {
+		return nil, "", err
+	}
+
+	serverURL, err = parseURL(serverURL)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return c.connectServer(serverURL)
+}
+
+// connectServer connects to the server, asking for the authentication details.
+func (c *cmdMigrate) connectServer(serverURL string) (incus.InstanceServer, string, error) {
+	args := incus.ConnectionArgs{
+		UserAgent: fmt.Sprintf("LXC-MIGRATE %s", version.Version),
+	}
+
+	serverCert, err := getServerCertificate(serverURL, "", func(digest string) error {
+		fmt.Fprintln(c.out, "Certificate fingerprint:", digest)
+		fmt.Fprint(c.out, "ok (y/n)? ")
+
+		buf := bufio.NewReader(os.Stdin)
+		line, _, err := buf.ReadLine()
+		if err != nil {
+			return err
+		}
+
+		if len(line) < 1 || line[0] != 'y' && line[0] != 'Y' {
+			return fmt.Errorf("Server certificate rejected by user")
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	args.TLSServerCert = serverCert
+	server, err := incus.ConnectIncus(serverURL, &args)
+	if err != nil {
+		return nil, "", fmt.Errorf("Failed to connect to server: %w", err)
+	}
+
+	apiServer, _, err := server.GetServer()
+	if err != nil {
+		return nil, "", fmt.Errorf("Failed to get server: %w", err)
+	}
+
+	// Reuse the client certificate from the command line rather than asking.
+	if c.flagClientCert != "" {
+		return c.connectTarget(serverURL, serverCert, c.flagClientCert, c.flagClientKey, api.AuthenticationMethodTLS, "")
+	}
+
+	fmt.Fprintln(c.out, "")
+
+	type AuthMethod int
+
+	const (
+		authMethodTLSCertificate AuthMethod = iota
+		authMethodTLSTemporaryCertificate
+		authMethodTLSCertificateToken
+	)
+
+	// TLS is always available
+	var availableAuthMethods []AuthMethod
+	var authMethod AuthMethod
+
+	i := 1
+
+	if slices.Contains(apiServer.AuthMethods, api.AuthenticationMethodTLS) {
+		fmt.Fprintf(c.out, "%d) Use a certificate token\n", i)
+		availableAuthMethods = append(availableAuthMethods, authMethodTLSCertificateToken)
+		i++
+		fmt.Fprintf(c.out, "%d) Use an existing TLS authentication certificate\n", i)
+		availableAuthMethods = append(availableAuthMethods, authMethodTLSCertificate)
+		i++
+		fmt.Fprintf(c.out, "%d) Generate a temporary TLS authentication certificate\n", i)
+		availableAuthMethods = append(availableAuthMethods, authMethodTLSTemporaryCertificate)
+	}
+
+	if len(apiServer.AuthMethods) > 1 || slices.Contains(apiServer.AuthMethods, api.AuthenticationMethodTLS) {
+		authMethodInt, err := c.global.asker.AskInt("Please pick an authentication mechanism above: ", 1, int64(i), "", nil)
+		if err != nil {
+			return nil, "", err
+		}
+
+		authMethod = availableAuthMethods[authMethodInt-1]
+	}
+
+	var certPath string
+	var keyPath string
+	var token string
+
+	switch authMethod {
+	case authMethodTLSCertificate:
+		certPath, err = c.global.asker.AskString("Please provide the certificate path: ", "", func(path string) error {
+			if !util.PathExists(path) {
+				return errors.New("File does not exist")
+			}
+
+			return nil
+		})
+		if err != nil {
+			return nil, "", err
+		}
+
+		keyPath, err = c.global.asker.AskString("Please provide the keyfile path: ", "", func(path string) error {
+			if !util.PathExists(path) {
+				return errors.New("File does not exist")
+			}
+
+			return nil
+		})
+		if err != nil {
+			return nil, "", err
+		}
+
+	case authMethodTLSCertificateToken:
+		token, err = c.global.asker.AskString("Please provide the certificate token: ", "", func(token string) error {
+			_, err := localtls.CertificateTokenDecode(token)
+			if err != nil {
+				return err
+			}
+
+			return nil
+		})
+		if err != nil {
+			return nil, "", err
+		}
+
+	case authMethodTLSTemporaryCertificate:
+		// Intentionally ignored
+	}
+
+	var authType string
+
+	switch authMethod {
+	case authMethodTLSCertificate, authMethodTLSTemporaryCertificate, authMethodTLSCertificateToken:
+		authType = api.AuthenticationMethodTLS
+	}
+
+	server, clientFingerprint, err := c.connectTarget(serverURL, serverCert, certPath, keyPath, authType, token)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Offer to save the server when using an existing certificate.
+	if certPath != "" && keyPath != "" {
+		err = c.askSaveRemote(serverURL, serverCert, certPath, keyPath)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	return server, clientFingerprint, nil
+}
+
+// connectRemote connects to a saved remote, checking that its certificate didn't change.
+func (c *cmdMigrate) connectRemote(name string) (incus.InstanceServer, string, error) {
+	remotes, err := loadRemotes()
+	if err != nil {
+		return nil, "", err
+	}
+
+	remote, ok := remotes[name]
+	if !ok {
+		return nil, "", fmt.Errorf("Remote %q doesn't exist", name)
+	}
+
+	// Without a saved fingerprint, the server was trusted by the system CA when saved.
+	serverCert, err := getServerCertificate(remote.Addr, remote.Fingerprint, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("Failed to check the certificate of remote %q: %w", name, err)
+	}
+
+	return c.connectTarget(remote.Addr, serverCert, remote.CertPath, remote.KeyPath, api.AuthenticationMethodTLS, "")
+}
+
+// askSaveRemote offers to save the server details for use through --remote.
+func (c *cmdMigrate) askSaveRemote(serverURL string, serverCert string, certPath string, keyPath string) error {
+	save, err := c.global.asker.AskBool("Would you like to save this server for later use? [default=no]: ", "no")
+	if err != nil {
+		return err
+	}
+
+	if !save {
+		return nil
+	}
+
+	remotes, err := loadRemotes()
+	if err != nil {
+		return err
+	}
+
+	name, err := c.global.asker.AskString("Name of the saved server: ", "", func(s string) error {
+		_, ok := remotes[s]
+		if ok {
+			return fmt.Errorf("Remote %q already exists", s)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	remote, err := newSavedRemote(serverURL, serverCert, certPath, keyPath)
+	if err != nil {
+		return err
+	}
+
+	remotes[name] = *remote
+
+	err = saveRemotes(remotes)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(c.out, "Server saved, use \"--remote %s\" to skip the server questions next time\n\n", name)
+
+	return nil
+}
+
+// askTarget sets the cluster member to create the instance or volume on. Standalone servers
+// have nothing to choose from.
+func (c *cmdMigrate) askTarget(server incus.InstanceServer, config *cmdMigrateData) error {
+	target := c.flagTarget
+	if target == "" && c.preseed != nil {
+		target = c.preseed.Target
+	}
+
+	if !server.IsClustered() {
+		if target != "" {
+			return fmt.Errorf("Cluster member %q can't be used, the target server isn't clustered", target)
+		}
+
+		return nil
+	}
+
+	members, err := server.GetClusterMemberNames()
+	if err != nil {
+		return fmt.Errorf("Failed to list cluster members: %w", err)
+	}
+
+	if target != "" {
+		if !slices.Contains(members, target) {
+			return fmt.Errorf("Cluster member %q doesn't exist", target)
+		}
+
+		config.Target = target
+
+		return nil
+	}
+
+	if c.preseed != nil {
+		return nil
+	}
+
+	slices.Sort(members)
+
+	config.Target, err = c.global.asker.AskString(fmt.Sprintf("Cluster member to use (%s) [default=automatic placement]: ", strings.Join(members, ", ")), "", func(s string) error {
+		if s != "" && !slices.Contains(members, s) {
+			return fmt.Errorf("Unknown cluster member %q", s)
+		}
+
+		return nil
+	})
+
+	return err
+}
+
+// checkVolumeMigrationSupport makes sure that the server can create custom volumes from a migration.
+func checkVolumeMigrationSupport(server incus.InstanceServer, migrationType MigrationType) error {
+	extensions := []string{"storage_api_remote_volume_handling"}
+	if migrationType == MigrationTypeVolumeBlock {
+		extensions = append(extensions, "custom_block_volumes")
+	}
+
+	for _, extension := range extensions {
+		if !server.HasExtension(extension) {
+			return fmt.Errorf("The target server is too old to create custom volumes from a migration (missing the %q API extension), Incus 0.1 or later is required", extension)
+		}
+	}
+
+	return nil
+}
+
+// dumpServerInfo prints what the server reports about itself.
+func dumpServerInfo(server incus.InstanceServer) error {
+	srv, _, err := server.GetServer()
+	if err != nil {
+		return fmt.Errorf("Failed to get server information: %w", err)
+	}
+
+	fmt.Printf("Server name: %s\n", srv.Environment.ServerName)
+	fmt.Printf("Server version: %s\n", srv.Environment.ServerVersion)
+	fmt.Printf("API version: %s\n", srv.APIVersion)
+	fmt.Printf("API status: %s\n", srv.APIStatus)
+	fmt.Printf("Authentication: %s\n", srv.Auth)
+	fmt.Printf("Authentication methods: %s\n", strings.Join(srv.AuthMethods, ", "))
+	fmt.Printf("Clustered: %v\n", srv.Environment.ServerClustered)
+	fmt.Printf("Architectures: %s\n", strings.Join(srv.Environment.Architectures, ", "))
+
+	fmt.Println("Storage drivers:")
+	for _, driver := range srv.Environment.StorageSupportedDrivers {
+		fmt.Printf("  - %s (%s)\n", driver.Name, driver.Version)
+	}
+
+	fmt.Println("API extensions:")
+	for _, extension := range srv.APIExtensions {
+		fmt.Printf("  - %s\n", extension)
+	}
+
+	return nil
+}
+
+// checkTargetResources makes sure the target has the storage pools (and networks if needed) to create the new instance or volume.
+func checkTargetResources(server incus.InstanceServer, needNetwork bool) error {
+	storagePools, err := server.GetStoragePoolNames()
+	if err != nil {
+		return fmt.Errorf("Failed to list storage pools: %w", err)
+	}
+
+	if len(storagePools) == 0 {
+		return errors.New("No storage pools available on the target server, create one first (for example with \"incus storage create\" or \"incus admin init\")")
+	}
+
+	if !needNetwork {
+		return nil
+	}
+
+	networks, err := server.GetNetworkNames()
+	if err != nil {
+		return fmt.Errorf("Failed to list networks: %w", err)
+	}
+
+	if len(networks) == 0 {
+		return errors.New("No networks available on the target server, create one first (for example with \"incus network create\") or use --network-none")
+	}
+
+	return nil
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/lxc/incus/v6/shared/revert"
+	"github.com/lxc/incus/v6/shared/subprocess"
+	"github.com/lxc/incus/v6/shared/units"
+)
+
+// prepareSource replaces the source by a snapshot of its logical volume, a loop device starting at
+// the requested offset or the decrypted content of a LUKS device, as requested. It returns the
+// decrypted device, if any, along with a function cleaning up behind it.
+func (c *cmdMigrate) prepareSource(config *cmdMigrateData, migrationType MigrationType) (string, func(), error) {
+	var err error
+
+	reverter := revert.New()
+	defer reverter.Fail()
+
+	// Transfer a snapshot of the logical volume rather than the volume itself.
+	if c.flagSnapshot && c.flagSourceLV != "" {
+		volume, err := lookupLogicalVolume(c.flagSourceLV)
+		if err != nil {
+			return "", nil, err
+		}
+
+		snapshot, removeSnapshot, err := volume.createSnapshot(c.out)
+		if err != nil {
+			return "", nil, err
+		}
+
+		reverter.Add(removeSnapshot)
+
+		fmt.Fprintf(c.out, "Transferring the snapshot %s/%s of %s\n", snapshot.vg, snapshot.lv, c.flagSourceLV)
+		config.SourcePath = snapshot.path
+	}
+
+	// Expose the data starting at the requested offset through a loop device.
+	if c.flagSourceOffset != "" {
+		if c.imageFormat(config.SourcePath) != imageFormatRaw {
+			return "", nil, errors.New("A source offset can only be used with raw images and block devices")
+		}
+
+		offset, err := units.ParseByteSizeString(c.flagSourceOffset)
+		if err != nil {
+			return "", nil, err
+		}
+
+		loopDevice, err := setupLoopDevice(config.SourcePath, offset)
+		if err != nil {
+			return "", nil, err
+		}
+
+		reverter.Add(func() { _, _ = subprocess.RunCommand("losetup", "--detach", loopDevice) })
+
+		fmt.Fprintf(c.out, "Using %q from offset %d as the source\n", config.SourcePath, offset)
+		config.SourcePath = loopDevice
+	}
+
+	// Transfer the decrypted content of an encrypted source rather than the ciphertext. Disks are
+	// transferred as-is, including additional ones, leaving the guest to unlock them at boot.
+	var luksDevice string
+	if c.importSource == "" && (migrationType == MigrationTypeVM || migrationType == MigrationTypeVolumeBlock) && isLUKS(config.SourcePath) {
+		fmt.Fprintf(c.out, "The source %q is encrypted with LUKS, it's transferred as-is and must be unlocked by the guest\n", config.SourcePath)
+	} else if c.importSource == "" && isLUKS(config.SourcePath) {
+		var closeDevice func()
+
+		luksDevice, closeDevice, err = c.openLUKS(config.SourcePath)
+		if err != nil {
+			return "", nil, err
+		}
+
+		reverter.Add(closeDevice)
+
+		fmt.Fprintf(c.out, "Using the decrypted content of %q as the source\n", config.SourcePath)
+		config.SourcePath = luksDevice
+	}
+
+	cleanup := reverter.Clone().Fail
+	reverter.Success()
+
+	return luksDevice, cleanup, nil
+}
+
+// setupFilesystemSource sets up the tree of mounts to transfer in path, rootMount being the
+// source as provided and luksDevice its decrypted content. It returns the path to transfer from,
+// along with a function cleaning up behind it.
+func (c *cmdMigrate) setupFilesystemSource(path string, config *cmdMigrateData, migrationType MigrationType, rootMount string, luksDevice string) (string, func(), error) {
+	reverter := revert.New()
+	defer reverter.Fail()
+
+	// Create the rootfs directory
+	fullPath := fmt.Sprintf("%s/rootfs", path)
+
+	err := os.Mkdir(fullPath, 0o755)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// Mount the filesystem found at the offset or in the encrypted source, it then replaces the source.
+	if c.flagSourceOffset != "" || luksDevice != "" {
+		sourcePath := filepath.Join(path, "source")
+
+		err = os.Mkdir(sourcePath, 0o755)
+		if err != nil {
+			return "", nil, err
+		}
+
+		_, err = subprocess.RunCommand("mount", "-o", "ro", config.SourcePath, sourcePath)
+		if err != nil && luksDevice != "" {
+			return "", nil, fmt.Errorf("No usable filesystem found in the encrypted source: %w", err)
+		} else if err != nil {
+			return "", nil, fmt.Errorf("No usable filesystem found at offset %s: %w", c.flagSourceOffset, err)
+		}
+
+		reverter.Add(func() {
+			_ = unix.Unmount(sourcePath, unix.MNT_DETACH)
+			_ = os.Remove(sourcePath)
+		})
+
+		// Only the root entry is replaced, it must stay first for the other mounts to go below it.
+		config.Mounts = slices.DeleteFunc(config.Mounts, func(mount string) bool { return mount == rootMount })
+		config.Mounts = append([]string{sourcePath}, config.Mounts...)
+	}
+
+	// Setup a throw-away layer to hold any write to the source.
+	var overlayPath string
+	if c.flagReadOnlySource {
+		overlayPath = filepath.Join(path, "overlay")
+
+		err = os.Mkdir(overlayPath, 0o700)
+		if err != nil {
+			return "", nil, err
+		}
+
+		err = unix.Mount("tmpfs", overlayPath, "tmpfs", 0, "mode=0700")
+		if err != nil {
+			return "", nil, fmt.Errorf("Failed to mount tmpfs for the overlay: %w", err)
+		}
+
+		reverter.Add(func() {
+			_ = unix.Unmount(overlayPath, unix.MNT_DETACH)
+			_ = os.Remove(overlayPath)
+		})
+	}
+
+	// Transfer snapshots of the mounts rather than the live filesystems.
+	var snapshotSources map[string]string
+	if c.flagSnapshot && c.flagDryRun {
+		fmt.Fprintln(c.out, "Skipping the snapshots of the source in dry run mode")
+	} else if c.flagSnapshot {
+		paths := slices.Clone(config.Mounts)
+		for _, volume := range config.MountVolumes {
+			paths = append(paths, volume.Source)
+		}
+
+		snapshotsPath := filepath.Join(path, "snapshots")
+
+		var removeSnapshots func()
+
+		snapshotSources, removeSnapshots, err = snapshotMounts(c.out, snapshotsPath, paths)
+		if err != nil {
+			return "", nil, err
+		}
+
+		reverter.Add(func() {
+			removeSnapshots()
+			_ = os.Remove(snapshotsPath)
+		})
+	}
+
+	// Prepare the btrfs subvolumes to send rather than the files of the source.
+	if c.flagBtrfsSend && migrationType == MigrationTypeContainer && c.flagDryRun {
+		fmt.Fprintln(c.out, "Skipping the btrfs send stream preparation in dry run mode")
+	} else if c.flagBtrfsSend && migrationType == MigrationTypeContainer {
+		reason := c.btrfsSendUnsupported(config)
+		if reason == "" {
+			var removeSubvolumes func()
+
+			config.BtrfsVolume, config.BtrfsRootfs, removeSubvolumes, err = btrfsSendSubvolumes(c.out, filepath.Join(path, "btrfs-send"), config.Mounts[0])
+			if err != nil {
+				reason = err.Error()
+			} else {
+				reverter.Add(removeSubvolumes)
+			}
+		}
+
+		if reason != "" {
+			fmt.Fprintf(c.out, "WARNING: Can't send %q as a btrfs send stream (%s), transferring its files with rsync\n", config.SourcePath, reason)
+		}
+	}
+
+	// Setup the source (mounts). A plain directory is a single read-only bind mount, it's still
+	// transferred as rootfs which is where the server expects the files.
+	err = setupSource(fullPath, config.Mounts, snapshotSources, overlayPath)
+	if err != nil {
+		return "", nil, fmt.Errorf("Failed to setup the source: %w", err)
+	}
+
+	// Setup the mounts which get their own volume.
+	for i := range config.MountVolumes {
+		volumePath := filepath.Join(path, "volumes", strconv.Itoa(i), "rootfs")
+
+		err = os.MkdirAll(volumePath, 0o755)
+		if err != nil {
+			return "", nil, err
+		}
+
+		var volumeOverlayPath string
+		if overlayPath != "" {
+			volumeOverlayPath = filepath.Join(overlayPath, fmt.Sprintf("volume-%d", i))
+		}
+
+		err = setupSource(volumePath, []string{config.MountVolumes[i].Source}, snapshotSources, volumeOverlayPath)
+		if err != nil {
+			return "", nil, fmt.Errorf("Failed to setup the source: %w", err)
+		}
+
+		config.MountVolumes[i].transferPath = volumePath
+	}
+
+	c.checkpoint.setMounts(config.Mounts)
+
+	cleanup := reverter.Clone().Fail
+	reverter.Success()
+
+	return fullPath, cleanup, nil
+}
+
+// setupDiskSource sets up the disk images to transfer in path, building the one of --as-vm
+// first, and returns a function cleaning up behind them.
+func (c *cmdMigrate) setupDiskSource(path string, config *cmdMigrateData) (func(), error) {
+	var err error
+
+	reverter := revert.New()
+	defer reverter.Fail()
+
+	if c.flagAsVM && c.flagDryRun {
+		fmt.Fprintln(c.out, "Skipping the disk image build in dry run mode")
+	} else if c.flagAsVM {
+		config.SourcePath, err = c.buildVMImage(path, config)
+		if err != nil {
+			return nil, err
+		}
+
+		imagePath := config.SourcePath
+		reverter.Add(func() { _ = os.Remove(imagePath) })
+	}
+
+	// A stream can't be mounted, it's read directly by the transfer.
+	if !isStream(config.SourcePath) && !(c.flagAsVM && c.flagDryRun) {
+		config.SourcePath, err = c.setupDiskImage(path, config.SourcePath, c.imageFormat(config.SourcePath))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Setup the additional disks, each in its own directory.
+	if len(config.Disks) > 0 {
+		disksPath := filepath.Join(path, "disks")
+
+		err = os.Mkdir(disksPath, 0o700)
+		if err != nil {
+			return nil, err
+		}
+
+		reverter.Add(func() { _ = os.Remove(disksPath) })
+	}
+
+	for i := range config.Disks {
+		diskPath := filepath.Join(path, "disks", strconv.Itoa(i))
+
+		err = os.Mkdir(diskPath, 0o700)
+		if err != nil {
+			return nil, err
+		}
+
+		reverter.Add(func() {
+			cleanupDiskImage(diskPath)
+			_ = os.Remove(diskPath)
+		})
+
+		format, _ := detectImageFormat(config.Disks[i].Source)
+
+		_, err = c.setupDiskImage(diskPath, config.Disks[i].Source, format)
+		if err != nil {
+			return nil, err
+		}
+
+		config.Disks[i].transferPath = diskPath
+	}
+
+	cleanup := reverter.Clone().Fail
+	reverter.Success()
+
+	return cleanup, nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+// The server receives the files below rootfs, whatever the name of the source directory.
+func TestSetupFilesystemSourcePlainDirectory(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Mounting the source requires root")
+	}
+
+	source := filepath.Join(t.TempDir(), "data")
+	err := os.MkdirAll(filepath.Join(source, "etc"), 0o755)
+	require.NoError(t, err)
+
+	err = os.WriteFile(filepath.Join(source, "etc", "hostname"), []byte("web01\n"), 0o644)
+	require.NoError(t, err)
+
+	path := t.TempDir()
+
+	c := &cmdMigrate{out: io.Discard}
+	c.checkpoint, err = newCheckpoint(path, "web01", "", MigrationTypeContainer, source)
+	require.NoError(t, err)
+
+	config := &cmdMigrateData{SourcePath: source, Mounts: []string{source}}
+
+	fullPath, cleanup, err := c.setupFilesystemSource(path, config, MigrationTypeContainer, source, "")
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		_ = unix.Unmount(fullPath, unix.MNT_DETACH)
+		cleanup()
+	})
+
+	assert.Equal(t, filepath.Join(path, "rootfs"), fullPath)
+
+	content, err := os.ReadFile(filepath.Join(fullPath, "etc", "hostname"))
+	require.NoError(t, err)
+	assert.Equal(t, "web01\n", string(content))
+
+	args := rsyncSendArgs(fullPath, transferArgs{Excludes: []string{"/tmp"}}, MigrationTypeContainer)
+	assert.Subset(t, args, []string{"--exclude", "/rootfs/tmp"})
+	assert.Equal(t, []string{fullPath, "localhost:/tmp/foo"}, args[len(args)-2:])
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/lxc/incus/v6/shared/revert"
+	"github.com/lxc/incus/v6/shared/subprocess"
+)
+
+// btrfsSubvolumeInode is the inode number of the root directory of btrfs subvolumes.
+const btrfsSubvolumeInode = 256
+
+// snapshotPrefix starts the names of the snapshots taken of the source. Those left behind by an
+// interrupted migration may show up in the source, so they're never transferred (see rsyncArgs).
+const snapshotPrefix = ".incus-migrate-snapshot-"
+
+// snapshotMounts takes read-only snapshots of the mounts of a filesystem source (see --snapshot),
+// mounting them in the directory when needed. It returns the paths to transfer the mounts from,
+// by mount, along with a function removing the snapshots.
+//
+// Mounts which aren't btrfs subvolumes or on LVM logical volumes, or fail to be snapshotted, are
+// transferred live with a warning.
+func snapshotMounts(out io.Writer, dir string, paths []string) (map[string]string, func(), error) {
+	mounts, err := parseMountInfo("/proc/self/mountinfo")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sources := map[string]string{}
+
+	// Removed in reverse order, in case a snapshot is taken below another one.
+	var removals []func()
+	removeAll := func() {
+		for i := len(removals) - 1; i >= 0; i-- {
+			removals[i]()
+		}
+	}
+
+	reverter := revert.New()
+	defer reverter.Fail()
+
+	reverter.Add(removeAll)
+
+	for i, path := range paths {
+		mount := findMount(mounts, path)
+		if mount == nil {
+			return nil, nil, fmt.Errorf("Unable to find the mount holding %q", path)
+		}
+
+		var source string
+		var remove func()
+
+		target := filepath.Join(dir, strconv.Itoa(i))
+
+		if mount.FSType == "btrfs" {
+			source, remove, err = snapshotBtrfs(out, target, mount, path)
+		} else {
+			source, remove, err = snapshotLVM(out, target, mount, path)
+		}
+
+		if err != nil {
+			fmt.Fprintf(out, "WARNING: Can't snapshot %q (%v), transferring the live filesystem\n", path, err)
+			continue
+		}
+
+		fmt.Fprintf(out, "Transferring a snapshot of %q\n", path)
+		sources[path] = source
+		removals = append(removals, remove)
+	}
+
+	reverter.Success()
+
+	return sources, removeAll, nil
+}
+
+// snapshotBtrfs takes a read-only snapshot of a btrfs subvolume. The snapshot is kept out of the
+// source, at the top level of the filesystem holding the mount, which gets mounted on target.
+// It returns the path of the snapshot along with a function deleting and unmounting it.
+func snapshotBtrfs(out io.Writer, target string, mount *mountInfo, path string) (string, func(), error) {
+	var stat unix.Stat_t
+
+	err := unix.Stat(path, &stat)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if stat.Ino != btrfsSubvolumeInode {
+		return "", nil, errors.New("Not the root of a btrfs subvolume")
+	}
+
+	err = checkCommand("btrfs")
+	if err != nil {
+		return "", nil, err
+	}
+
+	// Nested subvolumes show up as empty directories in snapshots.
+	subvolumes, err := subprocess.RunCommand("btrfs", "subvolume", "list", "-o", path)
+	if err != nil {
+		return "", nil, fmt.Errorf("Failed to list the nested subvolumes: %w", err)
+	}
+
+	if strings.TrimSpace(subvolumes) != "" {
+		return "", nil, errors.New("It holds nested subvolumes which snapshots leave out")
+	}
+
+	reverter := revert.New()
+	defer reverter.Fail()
+
+	err = os.MkdirAll(target, 0o700)
+	if err != nil {
+		return "", nil, err
+	}
+
+	reverter.Add(func() { _ = os.Remove(target) })
+
+	err = unix.Mount(mount.Source, target, "btrfs", 0, "subvolid=5")
+	if err != nil {
+		return "", nil, fmt.Errorf("Failed to mount the top level of the filesystem: %w", err)
+	}
+
+	reverter.Add(func() { _ = unix.Unmount(target, unix.MNT_DETACH) })
+
+	snapshot := filepath.Join(target, fmt.Sprintf("%s%d", snapshotPrefix, os.Getpid()))
+
+	_, err = subprocess.RunCommand("btrfs", "subvolume", "snapshot", "-r", path, snapshot)
+	if err != nil {
+		return "", nil, fmt.Errorf("Failed to create the snapshot: %w", err)
+	}
+
+	remove := func() {
+		_, err := subprocess.RunCommand("btrfs", "subvolume", "delete", snapshot)
+		if err != nil {
+			fmt.Fprintf(out, "WARNING: Failed to delete the snapshot %q: %v\n", snapshot, err)
+		}
+
+		_ = unix.Unmount(target, unix.MNT_DETACH)
+		_ = os.Remove(target)
+	}
+
+	reverter.Success()
+
+	return snapshot, remove, nil
+}
+
+// snapshotLVM takes a read-only snapshot of the LVM logical volume holding a mount and mounts it
+// read-only on target. It returns the path within the snapshot matching the provided one along
+// with a function unmounting and removing the snapshot.
+func snapshotLVM(out io.Writer, target string, mount *mountInfo, path string) (string, func(), error) {
+	if !strings.HasPrefix(mount.Source, "/dev/") {
+		return "", nil, errors.New("Neither a btrfs subvolume nor on a logical volume")
+	}
+
+	// The path may be below the mount point, and the mount a subdirectory of the filesystem.
+	relPath, err := filepath.Rel(mount.MountPoint, path)
+	if err != nil {
+		return "", nil, err
+	}
+
+	err = checkCommand("lvs")
+	if err != nil {
+		return "", nil, err
+	}
+
+	output, err := subprocess.RunCommand("lvs", "--noheadings", "--separator", ":", "-o", "vg_name,lv_name", mount.Source)
+	if err != nil {
+		return "", nil, errors.New("Neither a btrfs subvolume nor on a logical volume")
+	}
+
+	vg, lv, _ := strings.Cut(strings.TrimSpace(output), ":")
+
+	volume, err := lookupLogicalVolume(vg + "/" + lv)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// Taking the snapshot freezes the filesystem, its journal then has nothing to replay.
+	snapshot, removeSnapshot, err := volume.createSnapshot(out)
+	if err != nil {
+		return "", nil, err
+	}
+
+	reverter := revert.New()
+	defer reverter.Fail()
+
+	reverter.Add(removeSnapshot)
+
+	err = os.MkdirAll(target, 0o700)
+	if err != nil {
+		return "", nil, err
+	}
+
+	reverter.Add(func() { _ = os.Remove(target) })
+
+	var options string
+	switch mount.FSType {
+	case "ext4":
+		options = "norecovery"
+	case "xfs":
+		// The snapshot shares the UUID of the mounted filesystem.
+		options = "norecovery,nouuid"
+	}
+
+	err = unix.Mount(snapshot.path, target, mount.FSType, unix.MS_RDONLY, options)
+	if err != nil {
+		return "", nil, fmt.Errorf("Failed to mount the snapshot: %w", err)
+	}
+
+	remove := func() {
+		_ = unix.Unmount(target, unix.MNT_DETACH)
+		_ = os.Remove(target)
+		removeSnapshot()
+	}
+
+	reverter.Success()
+
+	return filepath.Join(target, mount.Root, relPath), remove, nil
+}
+
+// btrfsSendSubvolumes prepares the read-only btrfs subvolumes sent as btrfs send streams for a
+// container whose root filesystem is a btrfs subvolume (see --btrfs-send): an empty one for the
+// volume and a snapshot of the source for its rootfs, which the target nests in the volume. Both
+// are kept out of the source (see snapshotBtrfs). It returns their paths along with a function
+// deleting them.
+func btrfsSendSubvolumes(out io.Writer, dir string, path string) (string, string, func(), error) {
+	mounts, err := parseMountInfo("/proc/self/mountinfo")
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	mount := findMount(mounts, path)
+	if mount == nil || mount.FSType != "btrfs" {
+		return "", "", nil, errors.New("Not on a btrfs filesystem")
+	}
+
+	rootfs, removeSnapshot, err := snapshotBtrfs(out, dir, mount, path)
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	reverter := revert.New()
+	defer reverter.Fail()
+
+	reverter.Add(removeSnapshot)
+
+	volume := rootfs + "-volume"
+
+	_, err = subprocess.RunCommand("btrfs", "subvolume", "create", volume)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("Failed to create the volume subvolume: %w", err)
+	}
+
+	remove := func() {
+		_, err := subprocess.RunCommand("btrfs", "subvolume", "delete", volume)
+		if err != nil {
+			fmt.Fprintf(out, "WARNING: Failed to delete the subvolume %q: %v\n", volume, err)
+		}
+
+		removeSnapshot()
+	}
+
+	reverter.Add(func() { _, _ = subprocess.RunCommand("btrfs", "subvolume", "delete", volume) })
+
+	// Only read-only subvolumes can be sent.
+	_, err = subprocess.RunCommand("btrfs", "property", "set", "-ts", volume, "ro", "true")
+	if err != nil {
+		return "", "", nil, fmt.Errorf("Failed to make the volume subvolume read-only: %w", err)
+	}
+
+	reverter.Success()
+
+	return volume, rootfs, remove, nil
+}
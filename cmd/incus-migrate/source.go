@@ -0,0 +1,1086 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"gopkg.in/yaml.v2"
+
+	incus "github.com/lxc/incus/v6/client"
+	"github.com/lxc/incus/v6/internal/linux"
+	"github.com/lxc/incus/v6/shared/subprocess"
+	"github.com/lxc/incus/v6/shared/units"
+	"github.com/lxc/incus/v6/shared/util"
+)
+
+// mountInfo represents a single entry of /proc/self/mountinfo.
+type mountInfo struct {
+	Root       string
+	MountPoint string
+	FSType     string
+	Source     string
+	Options    []string
+}
+
+// parseMountInfo parses a mountinfo file (usually /proc/self/mountinfo).
+func parseMountInfo(path string) ([]mountInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() { _ = f.Close() }()
+
+	mounts := []mountInfo{}
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Format: ID PARENT MAJ:MIN ROOT MOUNTPOINT OPTIONS [OPTIONAL...] - FSTYPE SOURCE SUPER-OPTIONS
+		fields := strings.Fields(scanner.Text())
+
+		separator := slices.Index(fields, "-")
+		if separator < 6 || len(fields) < separator+4 {
+			return nil, fmt.Errorf("Invalid mountinfo entry: %q", scanner.Text())
+		}
+
+		options := strings.Split(fields[5], ",")
+		options = append(options, strings.Split(fields[separator+3], ",")...)
+
+		mounts = append(mounts, mountInfo{
+			Root:       unescapeMountPath(fields[3]),
+			MountPoint: unescapeMountPath(fields[4]),
+			FSType:     fields[separator+1],
+			Source:     unescapeMountPath(fields[separator+2]),
+			Options:    options,
+		})
+	}
+
+	err = scanner.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return mounts, nil
+}
+
+// unescapeMountPath decodes the octal escapes (\040 for space, ...) used in mountinfo paths.
+func unescapeMountPath(path string) string {
+	if !strings.Contains(path, "\\") {
+		return path
+	}
+
+	var sb strings.Builder
+
+	for i := 0; i < len(path); i++ {
+		if path[i] == '\\' && i+3 < len(path) {
+			value, err := strconv.ParseUint(path[i+1:i+4], 8, 8)
+			if err == nil {
+				sb.WriteByte(byte(value))
+				i += 3
+				continue
+			}
+		}
+
+		sb.WriteByte(path[i])
+	}
+
+	return sb.String()
+}
+
+// findMount returns the mount holding the provided path.
+func findMount(mounts []mountInfo, path string) *mountInfo {
+	var found *mountInfo
+
+	for i, mount := range mounts {
+		if path != mount.MountPoint && !strings.HasPrefix(path, strings.TrimSuffix(mount.MountPoint, "/")+"/") {
+			continue
+		}
+
+		// Later entries take precedence as they're mounted on top of earlier ones.
+		if found == nil || len(mount.MountPoint) >= len(found.MountPoint) {
+			found = &mounts[i]
+		}
+	}
+
+	return found
+}
+
+// sourceFacts gathers hardware facts about the machine the tool runs on, along with the hostname
+// of the source at the provided path. The facts are returned as user.migrate.facts.* configuration
+// keys:
+//   - hostname: hostname found in the root filesystem of the source
+//   - cpu: CPU model and number of threads
+//   - memory: total memory
+//   - disks: whole disks and their sizes
+//   - interfaces: network interfaces and their MAC addresses
+//   - kernel: running kernel version
+func sourceFacts(sourcePath string) (map[string]string, error) {
+	facts := map[string]string{}
+
+	// Hostname.
+	hostname := sourceHostname(sourcePath)
+	if hostname != "" {
+		facts["hostname"] = hostname
+	}
+
+	// CPU.
+	cpuinfo, err := os.ReadFile("/proc/cpuinfo")
+	if err != nil {
+		return nil, err
+	}
+
+	model := ""
+	threads := 0
+	for _, line := range strings.Split(string(cpuinfo), "\n") {
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+
+		switch strings.TrimSpace(key) {
+		case "processor":
+			threads++
+		case "model name":
+			model = strings.TrimSpace(value)
+		}
+	}
+
+	if model != "" {
+		facts["cpu"] = fmt.Sprintf("%s (%d threads)", model, threads)
+	}
+
+	// Memory.
+	meminfo, err := os.ReadFile("/proc/meminfo")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, line := range strings.Split(string(meminfo), "\n") {
+		value, found := strings.CutPrefix(line, "MemTotal:")
+		if !found {
+			continue
+		}
+
+		kib, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimSpace(value), " kB"), 10, 64)
+		if err == nil {
+			facts["memory"] = units.GetByteSizeStringIEC(kib*1024, 2)
+		}
+
+		break
+	}
+
+	// Disks (skipping virtual devices).
+	entries, err := os.ReadDir("/sys/block")
+	if err == nil {
+		disks := []string{}
+		for _, entry := range entries {
+			if !util.PathExists(filepath.Join("/sys/block", entry.Name(), "device")) {
+				continue
+			}
+
+			content, err := os.ReadFile(filepath.Join("/sys/block", entry.Name(), "size"))
+			if err != nil {
+				continue
+			}
+
+			sectors, err := strconv.ParseInt(strings.TrimSpace(string(content)), 10, 64)
+			if err != nil {
+				continue
+			}
+
+			disks = append(disks, fmt.Sprintf("%s=%s", entry.Name(), units.GetByteSizeStringIEC(sectors*512, 2)))
+		}
+
+		if len(disks) > 0 {
+			facts["disks"] = strings.Join(disks, ",")
+		}
+	}
+
+	// Network interfaces (skipping the ones without a MAC address, like loopback).
+	ifaces, err := net.Interfaces()
+	if err == nil {
+		nics := []string{}
+		for _, iface := range ifaces {
+			if len(iface.HardwareAddr) == 0 {
+				continue
+			}
+
+			nics = append(nics, fmt.Sprintf("%s=%s", iface.Name, iface.HardwareAddr.String()))
+		}
+
+		if len(nics) > 0 {
+			facts["interfaces"] = strings.Join(nics, ",")
+		}
+	}
+
+	// Kernel.
+	uname, err := linux.Uname()
+	if err == nil {
+		facts["kernel"] = uname.Release
+	}
+
+	config := make(map[string]string, len(facts))
+	for key, value := range facts {
+		config["user.migrate.facts."+key] = value
+	}
+
+	return config, nil
+}
+
+// sourceSize returns the number of bytes to transfer from the source set up at the provided path.
+func sourceSize(path string, migrationType MigrationType) (int64, error) {
+	if migrationType == MigrationTypeVM || migrationType == MigrationTypeVolumeBlock {
+		return diskSize(filepath.Join(path, "root.img"))
+	}
+
+	var size int64
+
+	err := filepath.WalkDir(path, func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			// Unreadable entries won't be transferred either.
+			if errors.Is(err, fs.ErrPermission) {
+				return nil
+			}
+
+			return err
+		}
+
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+
+		size += info.Size()
+
+		return nil
+	})
+	if err != nil {
+		return -1, err
+	}
+
+	return size, nil
+}
+
+// diskSize returns the size of an image file or block device.
+func diskSize(path string) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return -1, err
+	}
+
+	defer func() { _ = f.Close() }()
+
+	// Seeking works for both image files and block devices.
+	return f.Seek(0, io.SeekEnd)
+}
+
+// imageVirtualSize returns the size of the disk stored in an image, as reported by qemu-img.
+func imageVirtualSize(path string) (int64, error) {
+	out, err := subprocess.RunCommand("qemu-img", "info", "--output=json", path)
+	if err != nil {
+		return -1, err
+	}
+
+	info := struct {
+		VirtualSize int64 `json:"virtual-size"`
+	}{}
+
+	err = json.Unmarshal([]byte(out), &info)
+	if err != nil {
+		return -1, fmt.Errorf("Failed to parse qemu-img output: %w", err)
+	}
+
+	return info.VirtualSize, nil
+}
+
+// checkConvertedImage makes sure that the raw image holds the whole disk of the image it was converted from.
+func checkConvertedImage(sourcePath string, convertedPath string) error {
+	expected, err := imageVirtualSize(sourcePath)
+	if err != nil {
+		return fmt.Errorf("Failed to get the size of %q: %w", sourcePath, err)
+	}
+
+	size, err := diskSize(convertedPath)
+	if err != nil {
+		return err
+	}
+
+	if size != expected {
+		return fmt.Errorf("Converted image of %q is incomplete (%d bytes out of %d)", sourcePath, size, expected)
+	}
+
+	return nil
+}
+
+// isEmptySource returns a description of why the source looks empty, or an empty string if it doesn't.
+func isEmptySource(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+
+	// Nothing can be told about a stream without consuming it.
+	if info.Mode()&os.ModeNamedPipe != 0 {
+		return "", nil
+	}
+
+	if info.IsDir() {
+		f, err := os.Open(path)
+		if err != nil {
+			return "", err
+		}
+
+		defer func() { _ = f.Close() }()
+
+		_, err = f.Readdirnames(1)
+		if errors.Is(err, io.EOF) {
+			return "is an empty directory", nil
+		}
+
+		return "", nil
+	}
+
+	size, err := diskSize(path)
+	if err != nil {
+		return "", err
+	}
+
+	if size == 0 {
+		return "is empty (zero bytes)", nil
+	}
+
+	// Blank disks start with zeroes where the partition table or filesystem would be.
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+
+	defer func() { _ = f.Close() }()
+
+	buf := make([]byte, 1024*1024)
+
+	n, err := io.ReadFull(f, buf)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return "", err
+	}
+
+	if !slices.ContainsFunc(buf[:n], func(b byte) bool { return b != 0 }) {
+		return fmt.Sprintf("looks blank (its first %s only contain zeroes)", units.GetByteSizeStringIEC(int64(n), 2)), nil
+	}
+
+	return "", nil
+}
+
+// rootfsInits are the paths of the init systems looked for in the source of containers.
+var rootfsInits = []string{"/sbin/init", "/usr/sbin/init", "/lib/systemd/systemd", "/usr/lib/systemd/systemd", "/bin/busybox"}
+
+// notRootfsReason returns a description of why a directory doesn't look like a root filesystem,
+// like a home directory selected rather than "/", or an empty string if it does.
+func notRootfsReason(path string) string {
+	info, err := os.Stat(path)
+	if err != nil || !info.IsDir() {
+		return ""
+	}
+
+	etc, err := os.Stat(filepath.Join(path, "etc"))
+	if err != nil || !etc.IsDir() {
+		return "has no /etc directory"
+	}
+
+	// The init may be an absolute link, pointing at the host rather than within the source.
+	for _, init := range rootfsInits {
+		_, err := os.Lstat(filepath.Join(path, init))
+		if err == nil {
+			return ""
+		}
+	}
+
+	return fmt.Sprintf("has no init system (none of %s)", strings.Join(rootfsInits, ", "))
+}
+
+// pseudoFilesystems lists the filesystem types which never hold data worth migrating.
+// squashfs is included as it's mostly used for read-only images (snaps, live media).
+var pseudoFilesystems = []string{
+	"autofs", "binfmt_misc", "bpf", "cgroup", "cgroup2", "configfs", "debugfs", "devpts", "devtmpfs",
+	"efivarfs", "fusectl", "hugetlbfs", "mqueue", "nsfs", "proc", "pstore", "ramfs", "rpc_pipefs",
+	"securityfs", "squashfs", "sysfs", "tmpfs", "tracefs",
+}
+
+// sourceSubMounts returns the real filesystems mounted below the source path.
+func sourceSubMounts(sourcePath string) ([]mountInfo, error) {
+	mounts, err := sourceAllSubMounts(sourcePath)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []mountInfo{}
+	for _, mount := range mounts {
+		if !slices.Contains(pseudoFilesystems, mount.FSType) {
+			result = append(result, mount)
+		}
+	}
+
+	return result, nil
+}
+
+// sourceAllSubMounts returns all the filesystems mounted below the source path, pseudo filesystems included.
+func sourceAllSubMounts(sourcePath string) ([]mountInfo, error) {
+	sourcePath, err := filepath.Abs(sourcePath)
+	if err != nil {
+		return nil, err
+	}
+
+	sourcePath, err = filepath.EvalSymlinks(sourcePath)
+	if err != nil {
+		return nil, err
+	}
+
+	mounts, err := parseMountInfo("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+
+	// Only keep the last entry for each mount point as it hides the earlier ones.
+	byMountPoint := map[string]mountInfo{}
+	for _, mount := range mounts {
+		byMountPoint[mount.MountPoint] = mount
+	}
+
+	result := []mountInfo{}
+	for mountPoint, mount := range byMountPoint {
+		if mountPoint == sourcePath || !strings.HasPrefix(mountPoint, strings.TrimSuffix(sourcePath, "/")+"/") {
+			continue
+		}
+
+		result = append(result, mount)
+	}
+
+	slices.SortFunc(result, func(a mountInfo, b mountInfo) int { return strings.Compare(a.MountPoint, b.MountPoint) })
+
+	return result, nil
+}
+
+// fstabMounts returns the filesystems listed in the fstab of the source which are currently mounted,
+// along with a description of the skipped entries. Swap, pseudo filesystems, noauto entries and
+// the root filesystem itself are ignored.
+func fstabMounts(sourcePath string) ([]mountInfo, []string, error) {
+	sourcePath, err := filepath.Abs(sourcePath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sourcePath, err = filepath.EvalSymlinks(sourcePath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	content, err := os.ReadFile(filepath.Join(sourcePath, "etc", "fstab"))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	mounts, err := parseMountInfo("/proc/self/mountinfo")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Only keep the last entry for each mount point as it hides the earlier ones.
+	byMountPoint := map[string]mountInfo{}
+	for _, mount := range mounts {
+		byMountPoint[mount.MountPoint] = mount
+	}
+
+	result := []mountInfo{}
+	skipped := []string{}
+
+	for _, line := range strings.Split(string(content), "\n") {
+		// Format: SPEC FILE VFSTYPE MNTOPS [FREQ PASSNO]
+		fields := strings.Fields(line)
+		if len(fields) < 4 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		path := unescapeMountPath(fields[1])
+		fsType := fields[2]
+
+		if path == "/" || fsType == "swap" || path == "none" || slices.Contains(pseudoFilesystems, fsType) {
+			continue
+		}
+
+		if slices.Contains(strings.Split(fields[3], ","), "noauto") {
+			skipped = append(skipped, fmt.Sprintf("%s (noauto)", path))
+			continue
+		}
+
+		mount, ok := byMountPoint[filepath.Join(sourcePath, path)]
+		if !ok {
+			skipped = append(skipped, fmt.Sprintf("%s (not mounted)", path))
+			continue
+		}
+
+		result = append(result, mount)
+	}
+
+	return result, skipped, nil
+}
+
+// isStream returns whether the path is a pipe, like a named pipe or a piped standard input,
+// which can only be read once and from the start.
+func isStream(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+
+	return info.Mode()&os.ModeNamedPipe != 0
+}
+
+// isPlainDirectory returns whether the path is a directory which isn't a mount point
+// and doesn't have anything mounted below it, such as an extracted image.
+func isPlainDirectory(path string) bool {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+
+	path, err = filepath.EvalSymlinks(path)
+	if err != nil || path == "/" {
+		return false
+	}
+
+	info, err := os.Stat(path)
+	if err != nil || !info.IsDir() {
+		return false
+	}
+
+	mounts, err := parseMountInfo("/proc/self/mountinfo")
+	if err != nil {
+		return false
+	}
+
+	for _, mount := range mounts {
+		if mount.MountPoint == path || strings.HasPrefix(mount.MountPoint, path+"/") {
+			return false
+		}
+	}
+
+	return true
+}
+
+// mountFSType returns the type of the filesystem holding the path.
+func mountFSType(mounts []mountInfo, path string) (string, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+
+	path, err = filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+
+	mount := findMount(mounts, path)
+	if mount == nil {
+		return "", fmt.Errorf("Unable to find the mount holding %q", path)
+	}
+
+	return mount.FSType, nil
+}
+
+// excludeMountsByFSType returns the mounts which aren't on one of the filesystem types along with
+// a description of the excluded ones.
+func excludeMountsByFSType(paths []string, fsTypes []string) ([]string, []string, error) {
+	mounts, err := parseMountInfo("/proc/self/mountinfo")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	kept := []string{}
+	excluded := []string{}
+
+	for _, path := range paths {
+		fsType, err := mountFSType(mounts, path)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if fsTypeMatches(fsType, fsTypes) {
+			excluded = append(excluded, fmt.Sprintf("%s (%s)", path, fsType))
+			continue
+		}
+
+		kept = append(kept, path)
+	}
+
+	return kept, excluded, nil
+}
+
+// fsTypeMatches returns whether a filesystem type is one of the provided ones. Subtypes match
+// their main type (fuse.sshfs matches fuse) and nfs4 matches nfs.
+func fsTypeMatches(fsType string, fsTypes []string) bool {
+	family, _, _ := strings.Cut(fsType, ".")
+	if family == "nfs4" {
+		family = "nfs"
+	}
+
+	return slices.Contains(fsTypes, fsType) || slices.Contains(fsTypes, family)
+}
+
+// removableMountReason returns why a mount looks like removable media or a virtual mount
+// (snap loop devices, container overlays, USB drives), or an empty string otherwise.
+func removableMountReason(mount mountInfo) string {
+	if mount.FSType == "overlay" {
+		return "overlay mount"
+	}
+
+	if !strings.HasPrefix(mount.Source, "/dev/") {
+		return ""
+	}
+
+	devPath, err := filepath.EvalSymlinks(mount.Source)
+	if err != nil {
+		return ""
+	}
+
+	name := filepath.Base(devPath)
+
+	if strings.HasPrefix(name, "loop") {
+		backingFile, err := os.ReadFile(filepath.Join("/sys/block", name, "loop", "backing_file"))
+		if err == nil && strings.HasSuffix(strings.TrimSpace(string(backingFile)), ".snap") {
+			return "snap loop device"
+		}
+
+		return "loop device"
+	}
+
+	// Partitions are found below their disk in sysfs.
+	sysPath, err := filepath.EvalSymlinks(filepath.Join("/sys/class/block", name))
+	if err != nil {
+		return ""
+	}
+
+	if util.PathExists(filepath.Join(sysPath, "partition")) {
+		sysPath = filepath.Dir(sysPath)
+	}
+
+	removable, err := os.ReadFile(filepath.Join(sysPath, "removable"))
+	if err == nil && strings.TrimSpace(string(removable)) == "1" {
+		return "removable device"
+	}
+
+	if strings.Contains(sysPath, "/usb") {
+		return "USB device"
+	}
+
+	return ""
+}
+
+// cronServices lists the names of the cron daemon unit across distributions.
+var cronServices = []string{"cron.service", "crond.service", "cronie.service"}
+
+// sourceScheduledJobs returns the cron jobs and enabled systemd timers found in a root filesystem.
+func sourceScheduledJobs(rootfs string) ([]string, []string) {
+	cronJobs := []string{}
+
+	// System crontab, only counted if it holds at least one job.
+	content, err := os.ReadFile(filepath.Join(rootfs, "etc", "crontab"))
+	if err == nil {
+		for _, line := range strings.Split(string(content), "\n") {
+			line = strings.TrimSpace(line)
+			fields := strings.Fields(line)
+			if len(fields) < 6 || strings.HasPrefix(line, "#") || strings.Contains(fields[0], "=") {
+				continue
+			}
+
+			// Debian's crontab only runs the cron.* directories, those are listed below.
+			if strings.Contains(line, "run-parts") {
+				continue
+			}
+
+			cronJobs = append(cronJobs, "/etc/crontab")
+			break
+		}
+	}
+
+	// Job files and user crontabs.
+	for _, dir := range []string{"/etc/cron.d", "/var/spool/cron/crontabs", "/var/spool/cron"} {
+		entries, err := os.ReadDir(filepath.Join(rootfs, dir))
+		if err != nil {
+			continue
+		}
+
+		for _, entry := range entries {
+			if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), ".") {
+				continue
+			}
+
+			cronJobs = append(cronJobs, filepath.Join(dir, entry.Name()))
+		}
+	}
+
+	// Periodic scripts.
+	for _, dir := range []string{"/etc/cron.hourly", "/etc/cron.daily", "/etc/cron.weekly", "/etc/cron.monthly"} {
+		entries, err := os.ReadDir(filepath.Join(rootfs, dir))
+		if err != nil {
+			continue
+		}
+
+		var count int
+		for _, entry := range entries {
+			if !strings.HasPrefix(entry.Name(), ".") {
+				count++
+			}
+		}
+
+		if count > 0 {
+			cronJobs = append(cronJobs, fmt.Sprintf("%s (%d scripts)", dir, count))
+		}
+	}
+
+	// Timers are enabled through symlinks in the .wants directories.
+	timers := []string{}
+
+	wants, _ := filepath.Glob(filepath.Join(rootfs, "etc", "systemd", "system", "*.wants", "*.timer"))
+	for _, path := range wants {
+		name := filepath.Base(path)
+		if !slices.Contains(timers, name) {
+			timers = append(timers, name)
+		}
+	}
+
+	slices.Sort(timers)
+
+	return cronJobs, timers
+}
+
+// sourceCronService returns the name of the cron daemon unit in a root filesystem, if any.
+func sourceCronService(rootfs string) string {
+	for _, name := range cronServices {
+		for _, dir := range []string{"/etc/systemd/system", "/lib/systemd/system", "/usr/lib/systemd/system"} {
+			if util.PathExists(filepath.Join(rootfs, dir, name)) {
+				return name
+			}
+		}
+	}
+
+	return ""
+}
+
+// sourceOSName returns the name of the distribution installed in a root filesystem, if any.
+func sourceOSName(rootfs string) string {
+	for _, path := range []string{"/etc/os-release", "/usr/lib/os-release"} {
+		// Symlinks may be absolute and so point outside of the root filesystem.
+		info, err := os.Lstat(filepath.Join(rootfs, path))
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+
+		content, err := os.ReadFile(filepath.Join(rootfs, path))
+		if err != nil {
+			continue
+		}
+
+		osRelease := map[string]string{}
+		for _, line := range strings.Split(string(content), "\n") {
+			key, value, found := strings.Cut(line, "=")
+			if found && !strings.HasPrefix(key, "#") {
+				osRelease[key] = strings.Trim(value, `'"`)
+			}
+		}
+
+		if osRelease["PRETTY_NAME"] != "" {
+			return osRelease["PRETTY_NAME"]
+		}
+
+		if osRelease["NAME"] != "" {
+			return strings.TrimSpace(osRelease["NAME"] + " " + osRelease["VERSION_ID"])
+		}
+	}
+
+	return ""
+}
+
+// sourceInitSystem returns the name of the init system of a root filesystem, if any.
+func sourceInitSystem(rootfs string) string {
+	for _, path := range []string{"/sbin/init", "/usr/sbin/init", "/usr/lib/systemd/systemd", "/lib/systemd/systemd"} {
+		// Links are resolved by name as they may be absolute.
+		target, err := os.Readlink(filepath.Join(rootfs, path))
+		if err != nil {
+			if !isRegularFile(filepath.Join(rootfs, path)) {
+				continue
+			}
+
+			target = path
+		}
+
+		switch {
+		case strings.Contains(target, "systemd"):
+			return "systemd"
+		case strings.Contains(target, "openrc"):
+			return "OpenRC"
+		case strings.Contains(target, "busybox"):
+			return "BusyBox"
+		case util.PathExists(filepath.Join(rootfs, "sbin", "openrc")):
+			return "OpenRC"
+		case util.PathExists(filepath.Join(rootfs, "etc", "inittab")):
+			return "SysV init"
+		default:
+			return filepath.Base(target)
+		}
+	}
+
+	return ""
+}
+
+// sourceEnforcesMAC returns whether a root filesystem enforces mandatory access control policies,
+// SELinux being enforcing or permissive in /etc/selinux/config or AppArmor profiles being present.
+func sourceEnforcesMAC(rootfs string) bool {
+	content, err := os.ReadFile(filepath.Join(rootfs, "etc", "selinux", "config"))
+	if err == nil {
+		for _, line := range strings.Split(string(content), "\n") {
+			key, value, found := strings.Cut(strings.TrimSpace(line), "=")
+			if found && key == "SELINUX" && slices.Contains([]string{"enforcing", "permissive"}, strings.Trim(value, `"' `)) {
+				return true
+			}
+		}
+	}
+
+	profiles, err := os.ReadDir(filepath.Join(rootfs, "etc", "apparmor.d"))
+
+	return err == nil && len(profiles) > 0
+}
+
+// sourceSwap returns the swap devices and files listed in the fstab of a root filesystem.
+func sourceSwap(rootfs string) []string {
+	content, err := os.ReadFile(filepath.Join(rootfs, "etc", "fstab"))
+	if err != nil {
+		return nil
+	}
+
+	swap := []string{}
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 3 && !strings.HasPrefix(fields[0], "#") && fields[2] == "swap" {
+			swap = append(swap, fields[0])
+		}
+	}
+
+	return swap
+}
+
+// sourceHostname returns the hostname found in the root filesystem at the provided path, if any.
+func sourceHostname(sourcePath string) string {
+	content, err := os.ReadFile(filepath.Join(sourcePath, "etc", "hostname"))
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(content))
+}
+
+// sourceDescription returns a description of the source for the new instance, made of the
+// hostname and distribution found in the root filesystem (containers only) and the date.
+func sourceDescription(sourcePath string, migrationType MigrationType) string {
+	origin := sourcePath
+
+	if migrationType == MigrationTypeContainer {
+		hostname := sourceHostname(sourcePath)
+		if hostname != "" {
+			origin = hostname
+		}
+
+		osName := sourceOSName(sourcePath)
+		if osName != "" {
+			origin = fmt.Sprintf("%s (%s)", origin, osName)
+		}
+	}
+
+	return fmt.Sprintf("Migrated from %s on %s", origin, time.Now().Format(time.DateOnly))
+}
+
+// sourceBackupConfig is the part of the backup.yaml file of an Incus instance read by
+// sourceVolatileConfig.
+type sourceBackupConfig struct {
+	// Used by virtual machine backups too.
+	Container *struct {
+		Config map[string]string `yaml:"config"`
+	} `yaml:"container"`
+}
+
+// sourceVolatileConfig returns the volatile keys of the Incus instance the source belongs to,
+// read from its backup.yaml file (next to the rootfs directory of containers and the root.img
+// disk of virtual machines).
+func sourceVolatileConfig(sourcePath string) (map[string]string, error) {
+	backupPath := filepath.Join(filepath.Dir(filepath.Clean(sourcePath)), "backup.yaml")
+
+	content, err := os.ReadFile(backupPath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("No Incus instance metadata found at %q", backupPath)
+		}
+
+		return nil, err
+	}
+
+	backup := sourceBackupConfig{}
+
+	err = yaml.Unmarshal(content, &backup)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse %q: %w", backupPath, err)
+	}
+
+	if backup.Container == nil {
+		return nil, fmt.Errorf("%q doesn't describe an instance", backupPath)
+	}
+
+	volatile := map[string]string{}
+	for key, value := range backup.Container.Config {
+		if strings.HasPrefix(key, "volatile.") {
+			volatile[key] = value
+		}
+	}
+
+	return volatile, nil
+}
+
+// sourceFilesystemWarnings returns warnings about features of the source filesystems
+// which can't be preserved through a file based (rsync) transfer.
+func sourceFilesystemWarnings(paths []string) []string {
+	mounts, err := parseMountInfo("/proc/self/mountinfo")
+	if err != nil {
+		return []string{fmt.Sprintf("Failed to inspect the source filesystems: %v", err)}
+	}
+
+	warnings := []string{}
+
+	for _, path := range paths {
+		path, err := filepath.Abs(path)
+		if err != nil {
+			continue
+		}
+
+		path, err = filepath.EvalSymlinks(path)
+		if err != nil {
+			continue
+		}
+
+		mount := findMount(mounts, path)
+		if mount == nil {
+			continue
+		}
+
+		switch mount.FSType {
+		case "btrfs":
+			warnings = append(warnings, fmt.Sprintf("%q is on btrfs: subvolumes, snapshots and reflinks won't be preserved (shared extents get copied in full)", path))
+
+			for _, option := range mount.Options {
+				if strings.HasPrefix(option, "compress") {
+					warnings = append(warnings, fmt.Sprintf("%q uses btrfs compression (%s): the data will be transferred uncompressed and may use more space on the target", path, option))
+					break
+				}
+			}
+
+		case "zfs":
+			warnings = append(warnings, fmt.Sprintf("%q is on ZFS: dataset properties (compression, record size, ...) and snapshots won't be preserved", path))
+		}
+	}
+
+	return warnings
+}
+
+// sourceFileCapabilities returns the paths (relative to the root filesystem) of the files
+// carrying file capabilities. Only the usual locations for binaries are looked at.
+func sourceFileCapabilities(rootfs string) ([]string, error) {
+	files := []string{}
+
+	for _, dir := range []string{"bin", "sbin", "usr/bin", "usr/sbin", "usr/libexec", "usr/local/bin", "usr/local/sbin", "opt"} {
+		err := filepath.WalkDir(filepath.Join(rootfs, dir), func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				// Skip missing directories and unreadable entries.
+				if errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrPermission) {
+					return nil
+				}
+
+				return err
+			}
+
+			if !entry.Type().IsRegular() {
+				return nil
+			}
+
+			size, err := unix.Lgetxattr(path, "security.capability", nil)
+			if err != nil || size <= 0 {
+				return nil
+			}
+
+			relPath, err := filepath.Rel(rootfs, path)
+			if err != nil {
+				return err
+			}
+
+			files = append(files, string(os.PathSeparator)+relPath)
+
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return files, nil
+}
+
+// warnFileCapabilities lists the files of the source relying on file capabilities before
+// transferring an unprivileged container, which may not keep them depending on the kernel and
+// storage driver of the server. The server doesn't expose extended attributes, so they can't be
+// checked once transferred.
+func (c *cmdMigrate) warnFileCapabilities(server incus.InstanceServer, config *cmdMigrateData, rootfs string) {
+	privileged := util.IsTrue(config.InstanceArgs.Config["security.privileged"])
+	for _, name := range config.InstanceArgs.Profiles {
+		if privileged {
+			break
+		}
+
+		profile, _, err := server.GetProfile(name)
+		if err == nil {
+			privileged = util.IsTrue(profile.Config["security.privileged"])
+		}
+	}
+
+	if privileged {
+		return
+	}
+
+	files, err := sourceFileCapabilities(rootfs)
+	if err != nil {
+		fmt.Fprintf(c.out, "WARNING: Failed to look for file capabilities: %v\n", err)
+		return
+	}
+
+	if len(files) == 0 {
+		return
+	}
+
+	fmt.Fprintln(c.out, "WARNING: The following files of the source rely on file capabilities, which may not be kept in an unprivileged container:")
+	for _, file := range files {
+		fmt.Fprintf(c.out, "  %s\n", file)
+	}
+
+	fmt.Fprintln(c.out, "Run `getcap` on them in the container once migrated to check")
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFSTypeMatches(t *testing.T) {
+	tests := []struct {
+		name    string
+		fsType  string
+		fsTypes []string
+		want    bool
+	}{
+		{
+			name:    "Exact type",
+			fsType:  "ext4",
+			fsTypes: []string{"ext4"},
+			want:    true,
+		},
+		{
+			name:    "Other type",
+			fsType:  "xfs",
+			fsTypes: []string{"ext4", "nfs"},
+			want:    false,
+		},
+		{
+			name:    "FUSE subtype",
+			fsType:  "fuse.sshfs",
+			fsTypes: []string{"fuse"},
+			want:    true,
+		},
+		{
+			name:    "Exact FUSE subtype",
+			fsType:  "fuse.gvfsd-fuse",
+			fsTypes: []string{"fuse.gvfsd-fuse"},
+			want:    true,
+		},
+		{
+			name:    "Other FUSE subtype",
+			fsType:  "fuse.sshfs",
+			fsTypes: []string{"fuse.gvfsd-fuse"},
+			want:    false,
+		},
+		{
+			name:    "FUSE block device",
+			fsType:  "fuseblk",
+			fsTypes: []string{"fuse"},
+			want:    false,
+		},
+		{
+			name:    "NFSv4",
+			fsType:  "nfs4",
+			fsTypes: []string{"nfs"},
+			want:    true,
+		},
+		{
+			name:    "Only NFSv4",
+			fsType:  "nfs",
+			fsTypes: []string{"nfs4"},
+			want:    false,
+		},
+		{
+			name:    "No types",
+			fsType:  "nfs",
+			fsTypes: nil,
+			want:    false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, fsTypeMatches(tt.fsType, tt.fsTypes))
+		})
+	}
+}
+
+func TestIsPlainDirectory(t *testing.T) {
+	dir := t.TempDir()
+
+	file := filepath.Join(dir, "file")
+	err := os.WriteFile(file, nil, 0o600)
+	require.NoError(t, err)
+
+	link := filepath.Join(t.TempDir(), "link")
+	err = os.Symlink(dir, link)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		path string
+		want bool
+	}{
+		{
+			name: "Directory",
+			path: dir,
+			want: true,
+		},
+		{
+			name: "Symlink to a directory",
+			path: link,
+			want: true,
+		},
+		{
+			name: "File",
+			path: file,
+			want: false,
+		},
+		{
+			name: "Missing path",
+			path: filepath.Join(dir, "missing"),
+			want: false,
+		},
+		{
+			name: "Root filesystem",
+			path: "/",
+			want: false,
+		},
+		{
+			name: "Mount point",
+			path: "/proc",
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, isPlainDirectory(tt.path))
+		})
+	}
+}
+
+func TestSourceVolatileConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		backup  string
+		want    map[string]string
+		wantErr string
+	}{
+		{
+			name: "Instance",
+			backup: `container:
+  name: web01
+  config:
+    image.os: Debian
+    volatile.uuid: 8f0a3c1e-1b2d-4c5e-8f9a-0b1c2d3e4f5a
+    volatile.eth0.hwaddr: 00:16:3e:12:34:56
+  devices:
+    root:
+      path: /
+      type: disk
+pool:
+  name: default
+`,
+			want: map[string]string{
+				"volatile.uuid":        "8f0a3c1e-1b2d-4c5e-8f9a-0b1c2d3e4f5a",
+				"volatile.eth0.hwaddr": "00:16:3e:12:34:56",
+			},
+		},
+		{
+			name:    "Custom volume",
+			backup:  "volume:\n  name: data\n",
+			wantErr: "doesn't describe an instance",
+		},
+		{
+			name:    "Invalid file",
+			backup:  "container: [\n",
+			wantErr: "Failed to parse",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			err := os.WriteFile(filepath.Join(dir, "backup.yaml"), []byte(tt.backup), 0o600)
+			require.NoError(t, err)
+
+			got, err := sourceVolatileConfig(filepath.Join(dir, "rootfs"))
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
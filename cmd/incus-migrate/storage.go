@@ -0,0 +1,399 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+
+	incus "github.com/lxc/incus/v6/client"
+	"github.com/lxc/incus/v6/shared/api"
+	"github.com/lxc/incus/v6/shared/units"
+	"github.com/lxc/incus/v6/shared/util"
+)
+
+// checkSourceSize makes sure the source fits in the requested volume size and in the target storage pool.
+func (c *cmdMigrate) checkSourceSize(server incus.InstanceServer, config *cmdMigrateData, path string, migrationType MigrationType) error {
+	if c.flagSkipSizeChecks {
+		return nil
+	}
+
+	var pool string
+	var volumeSize string
+
+	if migrationType == MigrationTypeVolumeBlock || migrationType == MigrationTypeVolumeFilesystem {
+		pool = config.Pool
+		volumeSize = config.CustomVolumeArgs.Config["size"]
+	} else {
+		pool = config.InstanceArgs.Devices["root"]["pool"]
+		volumeSize = config.InstanceArgs.Devices["root"]["size"]
+	}
+
+	// Nothing to compare against.
+	if pool == "" && volumeSize == "" && len(config.Disks) == 0 {
+		return nil
+	}
+
+	var size int64
+	var err error
+
+	if c.flagSourceSize != "" {
+		size, err = units.ParseByteSizeString(c.flagSourceSize)
+		if err != nil {
+			return err
+		}
+	} else {
+		fmt.Fprintln(c.out, "Calculating the size of the source (use --source-size or --skip-size-checks to skip this)")
+
+		size, err = sourceSize(path, migrationType)
+		if err != nil {
+			return fmt.Errorf("Failed to calculate the size of the source: %w", err)
+		}
+	}
+
+	if volumeSize != "" {
+		limit, err := units.ParseByteSizeString(volumeSize)
+		if err != nil {
+			return err
+		}
+
+		if size > limit {
+			return fmt.Errorf("The source (%s) is larger than the requested volume size (%s)", units.GetByteSizeStringIEC(size, 2), volumeSize)
+		}
+	}
+
+	// The additional disks get their own volumes, which take space as well.
+	needed := map[string]int64{}
+	if pool != "" {
+		needed[pool] = size
+	}
+
+	total := size
+	for _, disk := range config.Disks {
+		diskSize, err := sourceSize(disk.transferPath, MigrationTypeVM)
+		if err != nil {
+			return fmt.Errorf("Failed to calculate the size of the disk %q: %w", disk.Source, err)
+		}
+
+		needed[disk.Pool] += diskSize
+		total += diskSize
+	}
+
+	c.progress.setTotal(total)
+
+	for _, pool := range slices.Sorted(maps.Keys(needed)) {
+		resources, err := server.GetStoragePoolResources(pool)
+		if err != nil {
+			fmt.Fprintf(c.out, "WARNING: Unable to check the space available in storage pool %q: %v\n", pool, err)
+			continue
+		}
+
+		available := int64(resources.Space.Total) - int64(resources.Space.Used)
+		if resources.Space.Total > 0 && needed[pool] > available {
+			return fmt.Errorf("Not enough space in storage pool %q (%s needed, %s available)", pool, units.GetByteSizeStringIEC(needed[pool], 2), units.GetByteSizeStringIEC(available, 2))
+		}
+	}
+
+	return nil
+}
+
+func (c *cmdMigrate) askStorage(server incus.InstanceServer, config *cmdMigrateData) error {
+	storagePools, err := server.GetStoragePoolNames()
+	if err != nil {
+		return err
+	}
+
+	if len(storagePools) == 0 {
+		return fmt.Errorf("No storage pools available")
+	}
+
+	storagePool, err := c.global.asker.AskChoice("Please provide the storage pool to use: ", storagePools, "")
+	if err != nil {
+		return err
+	}
+
+	config.InstanceArgs.Devices["root"] = map[string]string{
+		"type": "disk",
+		"pool": storagePool,
+		"path": "/",
+	}
+
+	changeStorageSize, err := c.global.asker.AskBool("Do you want to change the storage size? [default=no]: ", "no")
+	if err != nil {
+		return err
+	}
+
+	if changeStorageSize {
+		size, err := c.global.asker.AskString("Please specify the storage size: ", "", func(s string) error {
+			return c.checkRequestedSize(config, s, config.InstanceArgs.Type == api.InstanceTypeVM && !c.flagAsVM)
+		})
+		if err != nil {
+			return err
+		}
+
+		config.InstanceArgs.Devices["root"]["size"] = size
+	}
+
+	if config.InstanceArgs.Type == api.InstanceTypeContainer && c.flagFSType != "" {
+		config.InstanceArgs.Devices["root"]["initial.block.filesystem"] = c.flagFSType
+
+		warning, err := fsTypeWarning(server, storagePool, c.flagFSType)
+		if err != nil {
+			return err
+		}
+
+		if warning != "" {
+			fmt.Fprintf(c.out, "WARNING: %s\n", warning)
+		}
+	}
+
+	if config.InstanceArgs.Type == api.InstanceTypeVM && c.flagStateSize != "" {
+		config.InstanceArgs.Devices["root"]["size.state"] = c.flagStateSize
+	} else if config.InstanceArgs.Type == api.InstanceTypeVM {
+		changeStateSize, err := c.global.asker.AskBool("Do you want to set the state size (for stateful snapshots and stops)? [default=no]: ", "no")
+		if err != nil {
+			return err
+		}
+
+		if changeStateSize {
+			size, err := c.global.asker.AskString("Please specify the state size: ", "", func(s string) error {
+				_, err := units.ParseByteSizeString(s)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+
+			config.InstanceArgs.Devices["root"]["size.state"] = size
+		}
+	}
+
+	return nil
+}
+
+// fsTypeWarning validates a filesystem type for block.filesystem and returns a warning
+// when the storage pool doesn't format its volumes with it.
+func fsTypeWarning(server incus.InstanceServer, poolName string, fsType string) (string, error) {
+	if !slices.Contains([]string{"ext4", "xfs", "btrfs"}, fsType) {
+		return "", fmt.Errorf("Invalid filesystem type %q (must be one of ext4, xfs or btrfs)", fsType)
+	}
+
+	pool, _, err := server.GetStoragePool(poolName)
+	if err != nil {
+		return "", err
+	}
+
+	switch pool.Driver {
+	case "ceph", "linstor", "lvm":
+		return "", nil
+	case "zfs":
+		if util.IsTrue(pool.Config["volume.zfs.block_mode"]) {
+			return "", nil
+		}
+
+		return fmt.Sprintf("Storage pool %q doesn't have volume.zfs.block_mode set, the %s filesystem type is ignored", poolName, fsType), nil
+	}
+
+	return fmt.Sprintf("Storage pool %q uses the %s driver which doesn't format volumes, the %s filesystem type is ignored", poolName, pool.Driver, fsType), nil
+}
+
+// setRootDiskKey sets a key on the root disk device, copying it from the profiles if needed.
+func (c *cmdMigrate) setRootDiskKey(server incus.InstanceServer, config *cmdMigrateData, key string, value string) error {
+	for _, device := range config.InstanceArgs.Devices {
+		if device["type"] == "disk" && device["path"] == "/" {
+			device[key] = value
+			return nil
+		}
+	}
+
+	profiles := config.InstanceArgs.Profiles
+	if profiles == nil {
+		profiles = []string{"default"}
+	}
+
+	// Later profiles take precedence.
+	for i := len(profiles) - 1; i >= 0; i-- {
+		profile, _, err := server.GetProfile(profiles[i])
+		if err != nil {
+			return err
+		}
+
+		for deviceName, device := range profile.Devices {
+			if device["type"] != "disk" || device["path"] != "/" {
+				continue
+			}
+
+			rootDisk := maps.Clone(device)
+			rootDisk[key] = value
+			config.InstanceArgs.Devices[deviceName] = rootDisk
+
+			return nil
+		}
+	}
+
+	return errors.New("No root disk device found in the instance profiles, select a storage pool first")
+}
+
+// checkRequestedSize checks that a requested volume size isn't smaller than the source, when the
+// size of the source is known up front: from --source-size or for disks. Filesystem sources are
+// otherwise only measured once set up (see checkSourceSize).
+func (c *cmdMigrate) checkRequestedSize(config *cmdMigrateData, requested string, isDisk bool) error {
+	size, err := units.ParseByteSizeString(requested)
+	if err != nil {
+		return err
+	}
+
+	var sourceSize int64
+
+	if c.flagSourceSize != "" {
+		// Validated in run.
+		sourceSize, _ = units.ParseByteSizeString(c.flagSourceSize)
+	} else if isDisk && !isStream(config.SourcePath) {
+		format := c.imageFormat(config.SourcePath)
+		if format == imageFormatRaw {
+			sourceSize, err = diskSize(config.SourcePath)
+		} else {
+			sourceSize, err = imageVirtualSize(config.SourcePath)
+		}
+
+		// Not being able to tell the size of the source isn't a reason to reject the size.
+		if err != nil {
+			return nil
+		}
+	}
+
+	if size < sourceSize {
+		return fmt.Errorf("The requested size (%s) is smaller than the source (%s)", units.GetByteSizeStringIEC(size, 2), units.GetByteSizeStringIEC(sourceSize, 2))
+	}
+
+	return nil
+}
+
+// askVolumeSize sets the size of the custom volume, making sure that a block source fits in it.
+// Filesystem sources are checked against the size once set up (see checkSourceSize).
+func (c *cmdMigrate) askVolumeSize(config *cmdMigrateData) error {
+	validate := func(s string) error {
+		return c.checkRequestedSize(config, s, config.CustomVolumeArgs.ContentType == "block")
+	}
+
+	size := c.flagVolumeSize
+	if size == "" && c.preseed != nil {
+		if c.preseed.Size == "" {
+			return nil
+		}
+
+		size = c.preseed.Size
+	}
+
+	if size != "" {
+		err := validate(size)
+		if err != nil {
+			return fmt.Errorf("Invalid volume size %q: %w", size, err)
+		}
+	} else {
+		changeSize, err := c.global.asker.AskBool("Do you want to set the volume size? [default=no]: ", "no")
+		if err != nil {
+			return err
+		}
+
+		if !changeSize {
+			return nil
+		}
+
+		size, err = c.global.asker.AskString("Please specify the volume size: ", "", validate)
+		if err != nil {
+			return err
+		}
+	}
+
+	if config.CustomVolumeArgs.Config == nil {
+		config.CustomVolumeArgs.Config = map[string]string{}
+	}
+
+	config.CustomVolumeArgs.Config["size"] = size
+
+	return nil
+}
+
+// rootDiskPool returns the storage pool of the root disk, set on the instance or in its profiles.
+func rootDiskPool(server incus.InstanceServer, config *cmdMigrateData) (string, error) {
+	for _, device := range config.InstanceArgs.Devices {
+		if device["type"] == "disk" && device["path"] == "/" {
+			return device["pool"], nil
+		}
+	}
+
+	profiles := config.InstanceArgs.Profiles
+	if profiles == nil {
+		profiles = []string{"default"}
+	}
+
+	// Later profiles take precedence.
+	for i := len(profiles) - 1; i >= 0; i-- {
+		profile, _, err := server.GetProfile(profiles[i])
+		if err != nil {
+			return "", err
+		}
+
+		for _, device := range profile.Devices {
+			if device["type"] == "disk" && device["path"] == "/" {
+				return device["pool"], nil
+			}
+		}
+	}
+
+	return "", errors.New("No root disk device found in the instance profiles, select a storage pool first")
+}
+
+// applyStateSize sets the size of the state of a virtual machine, if requested.
+func (c *cmdMigrate) applyStateSize(server incus.InstanceServer, config *cmdMigrateData) error {
+	if c.flagStateSize == "" {
+		return nil
+	}
+
+	if config.InstanceArgs.Type != api.InstanceTypeVM {
+		return errors.New("A state size can only be set for virtual machines")
+	}
+
+	_, err := units.ParseByteSizeString(c.flagStateSize)
+	if err != nil {
+		return fmt.Errorf("Invalid state size %q: %w", c.flagStateSize, err)
+	}
+
+	return c.setRootDiskKey(server, config, "size.state", c.flagStateSize)
+}
+
+// applyFSType sets the filesystem of the root disk of a container, if requested. It returns
+// warnings about the filesystem on its storage pool.
+func (c *cmdMigrate) applyFSType(server incus.InstanceServer, config *cmdMigrateData) ([]string, error) {
+	if c.flagFSType == "" {
+		return nil, nil
+	}
+
+	if config.InstanceArgs.Type != api.InstanceTypeContainer {
+		return nil, errors.New("A filesystem type can only be set for containers and filesystem custom volumes")
+	}
+
+	err := c.setRootDiskKey(server, config, "initial.block.filesystem", c.flagFSType)
+	if err != nil {
+		return nil, err
+	}
+
+	var warnings []string
+	for _, device := range config.InstanceArgs.Devices {
+		if device["type"] != "disk" || device["path"] != "/" {
+			continue
+		}
+
+		warning, err := fsTypeWarning(server, device["pool"], c.flagFSType)
+		if err != nil {
+			return nil, err
+		}
+
+		if warning != "" {
+			warnings = append(warnings, warning)
+		}
+	}
+
+	return warnings, nil
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	incus "github.com/lxc/incus/v6/client"
+	"github.com/lxc/incus/v6/shared/api"
+)
+
+// migrateTarget represents an additional server to migrate to.
+type migrateTarget struct {
+	url               string
+	server            incus.InstanceServer
+	clientFingerprint string
+
+	// Project created on the server for the migration (see createProject).
+	createdProject string
+}
+
+// checkAdditionalTargets checks the configuration gathered against the main server on each of the
+// additional targets, before anything gets transferred. The project created on the main server
+// for the migration is created on those lacking it too.
+//
+// The cluster member only applies to the main server, additional targets using the automatic
+// placement of the instance.
+func (c *cmdMigrate) checkAdditionalTargets(config *cmdMigrateData, migrationType MigrationType) error {
+	for i := range c.additionalTargets {
+		target := &c.additionalTargets[i]
+
+		err := c.checkAdditionalTarget(target, config, migrationType)
+		if err != nil {
+			return fmt.Errorf("Additional target %q: %w", target.url, err)
+		}
+	}
+
+	return nil
+}
+
+// checkAdditionalTarget checks that the project, storage pools, profiles and networks used by the
+// migration exist on the target, and that nothing of the same name exists there already.
+func (c *cmdMigrate) checkAdditionalTarget(target *migrateTarget, config *cmdMigrateData, migrationType MigrationType) error {
+	projectNames, err := target.server.GetProjectNames()
+	if err != nil {
+		return fmt.Errorf("Failed to list projects: %w", err)
+	}
+
+	project := config.Project
+	if project == "" {
+		project = api.ProjectDefaultName
+	}
+
+	if !slices.Contains(projectNames, project) {
+		switch project {
+		case c.dryRunProject:
+			// The project doesn't exist yet, its instances would use the default profiles.
+			fmt.Fprintf(c.out, "Project %q would be created on %q too\n", project, target.url)
+			project = api.ProjectDefaultName
+		case c.createdProject:
+			err = c.createProject(target.server, project)
+			if err != nil {
+				return err
+			}
+
+			target.createdProject = project
+		default:
+			return fmt.Errorf("Project %q doesn't exist", project)
+		}
+	}
+
+	err = checkProjectAccess(target.server, project)
+	if err != nil {
+		return err
+	}
+
+	server := target.server.UseProject(project)
+
+	if migrationType == MigrationTypeVolumeFilesystem || migrationType == MigrationTypeVolumeBlock {
+		err = checkTargetPools(server, []string{config.Pool})
+		if err != nil {
+			return err
+		}
+
+		_, _, err = server.GetStoragePoolVolume(config.Pool, "custom", config.CustomVolumeArgs.Name)
+		if err == nil {
+			return fmt.Errorf("Storage volume %q already exists in storage pool %q", config.CustomVolumeArgs.Name, config.Pool)
+		}
+
+		return nil
+	}
+
+	_, _, err = server.GetInstance(config.InstanceArgs.Name)
+	if err == nil {
+		return fmt.Errorf("Instance %q already exists", config.InstanceArgs.Name)
+	}
+
+	// The pools of the root disk and of the volumes of the mounts and additional disks.
+	pools := []string{config.InstanceArgs.Devices["root"]["pool"]}
+	for _, volume := range config.MountVolumes {
+		pools = append(pools, volume.Pool)
+	}
+
+	for _, disk := range config.Disks {
+		pools = append(pools, disk.Pool)
+	}
+
+	err = checkTargetPools(server, pools)
+	if err != nil {
+		return err
+	}
+
+	for _, volume := range config.MountVolumes {
+		_, _, err = server.GetStoragePoolVolume(volume.Pool, "custom", volume.Name)
+		if err == nil {
+			return fmt.Errorf("Storage volume %q already exists in storage pool %q", volume.Name, volume.Pool)
+		}
+	}
+
+	for _, disk := range config.Disks {
+		_, _, err = server.GetStoragePoolVolume(disk.Pool, "custom", disk.Name)
+		if err == nil {
+			return fmt.Errorf("Storage volume %q already exists in storage pool %q", disk.Name, disk.Pool)
+		}
+	}
+
+	// Without any profile set, the instance gets the default one.
+	profiles := config.InstanceArgs.Profiles
+	if profiles == nil {
+		profiles = []string{"default"}
+	}
+
+	for _, profile := range profiles {
+		_, _, err = server.GetProfile(profile)
+		if err != nil {
+			return fmt.Errorf("Profile %q: %w", profile, err)
+		}
+	}
+
+	for name, device := range config.InstanceArgs.Devices {
+		if device["type"] != "nic" {
+			continue
+		}
+
+		network := device["network"]
+		if network == "" {
+			network = device["parent"]
+		}
+
+		if network == "" {
+			continue
+		}
+
+		_, _, err = server.GetNetwork(network)
+		if err != nil {
+			return fmt.Errorf("Network %q of device %q: %w", network, name, err)
+		}
+	}
+
+	return nil
+}
+
+// checkTargetPools checks that the storage pools exist on the server, ignoring empty names.
+func checkTargetPools(server incus.InstanceServer, pools []string) error {
+	for _, pool := range pools {
+		if pool == "" {
+			continue
+		}
+
+		_, _, err := server.GetStoragePool(pool)
+		if err != nil {
+			return fmt.Errorf("Storage pool %q: %w", pool, err)
+		}
+	}
+
+	return nil
+}
+
+// transferAdditionalTargets transfers the source at path to each of the additional targets, a
+// failure not preventing the others. It returns the URLs of the targets that succeeded and failed.
+func (c *cmdMigrate) transferAdditionalTargets(ctx context.Context, config *cmdMigrateData, path string, migrationType MigrationType, migrationHandler func(ctx context.Context, server incus.InstanceServer, config *cmdMigrateData, path string, migrationType MigrationType) error) ([]string, []string) {
+	var succeededTargets []string
+	var failedTargets []string
+	for _, target := range c.additionalTargets {
+		fmt.Fprintf(c.out, "\nMigrating to additional target %q\n", target.url)
+		c.setPhase(fmt.Sprintf("transferring to %s", target.url))
+
+		targetServer := target.server
+		if config.Project != "" {
+			targetServer = targetServer.UseProject(config.Project)
+		}
+
+		err := c.retryTransfer(ctx, config, fmt.Sprintf("transferring to %s", target.url), func() error {
+			return migrationHandler(ctx, targetServer, config, path, migrationType)
+		})
+
+		if err != nil {
+			err = c.transferTimeoutError(ctx, err)
+		}
+
+		if err != nil {
+			fmt.Fprintf(c.out, "Migration to %q failed: %v\n", target.url, err)
+			failedTargets = append(failedTargets, target.url)
+			continue
+		}
+
+		fmt.Fprintf(c.out, "Migration to %q succeeded\n", target.url)
+		succeededTargets = append(succeededTargets, target.url)
+	}
+
+	return succeededTargets, failedTargets
+}
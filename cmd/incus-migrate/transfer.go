@@ -2,26 +2,66 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"maps"
+	"math"
 	"net"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 
+	incus "github.com/lxc/incus/v6/client"
 	"github.com/lxc/incus/v6/internal/linux"
 	"github.com/lxc/incus/v6/internal/migration"
 	"github.com/lxc/incus/v6/internal/rsync"
+	internalUtil "github.com/lxc/incus/v6/internal/util"
+	"github.com/lxc/incus/v6/shared/api"
+	"github.com/lxc/incus/v6/shared/revert"
+	"github.com/lxc/incus/v6/shared/subprocess"
 	"github.com/lxc/incus/v6/shared/util"
 	"github.com/lxc/incus/v6/shared/ws"
 )
 
+// rsyncExitTimeout is the rsync exit code for a timeout in data send/receive.
+const rsyncExitTimeout = 30
+
+// errTransferStalled is returned when rsync gave up after no IO for the stall timeout.
+var errTransferStalled = errors.New("Transfer stalled")
+
+// retryDelay is the delay before the first retry of a failed transfer, doubling for each retry
+// up to retryMaxDelay (see --retries).
+const retryDelay = 5 * time.Second
+
+// retryMaxDelay is the maximum delay between two retries of a failed transfer.
+const retryMaxDelay = 5 * time.Minute
+
+// transferError is an error which occurred while transferring data, once the target was ready
+// to receive it. Unlike other errors, it's worth retrying.
+type transferError struct {
+	err error
+}
+
+func (e transferError) Error() string {
+	return e.err.Error()
+}
+
+func (e transferError) Unwrap() error {
+	return e.err
+}
+
 // Send an rsync stream of a path over a websocket.
-func rsyncSend(ctx context.Context, conn *websocket.Conn, path string, rsyncArgs string, migrationType MigrationType) error {
-	cmd, dataSocket, stderr, err := rsyncSendSetup(ctx, path, rsyncArgs, migrationType)
+func rsyncSend(ctx context.Context, conn *websocket.Conn, path string, transferArgs transferArgs, migrationType MigrationType, stdout io.Writer) error {
+	cmd, dataSocket, stderr, err := rsyncSendSetup(ctx, path, transferArgs, migrationType, stdout)
 	if err != nil {
 		return err
 	}
@@ -30,7 +70,12 @@ func rsyncSend(ctx context.Context, conn *websocket.Conn, path string, rsyncArgs
 		defer func() { _ = dataSocket.Close() }()
 	}
 
-	readDone, writeDone := ws.Mirror(conn, dataSocket)
+	var rwc io.ReadWriteCloser = dataSocket
+	if transferArgs.BytesSent != nil {
+		rwc = &countingReadWriteCloser{ReadWriteCloser: dataSocket, count: transferArgs.BytesSent}
+	}
+
+	readDone, writeDone := ws.Mirror(conn, rwc)
 	<-writeDone
 	_ = dataSocket.Close()
 
@@ -45,6 +90,11 @@ func rsyncSend(ctx context.Context, conn *websocket.Conn, path string, rsyncArgs
 	<-readDone
 
 	if err != nil {
+		var exitErr *exec.ExitError
+		if transferArgs.StallTimeout > 0 && errors.As(err, &exitErr) && exitErr.ExitCode() == rsyncExitTimeout {
+			return fmt.Errorf("%w: no data was exchanged for %s (see --stall-timeout)\n%s", errTransferStalled, transferArgs.StallTimeout, output)
+		}
+
 		return fmt.Errorf("Failed to rsync: %v\n%s", err, output)
 	}
 
@@ -52,7 +102,7 @@ func rsyncSend(ctx context.Context, conn *websocket.Conn, path string, rsyncArgs
 }
 
 // Spawn the rsync process.
-func rsyncSendSetup(ctx context.Context, path string, rsyncArgs string, migrationType MigrationType) (*exec.Cmd, net.Conn, io.ReadCloser, error) {
+func rsyncSendSetup(ctx context.Context, path string, transferArgs transferArgs, migrationType MigrationType, stdout io.Writer) (*exec.Cmd, net.Conn, io.ReadCloser, error) {
 	auds := fmt.Sprintf("@incus-migrate/%s", uuid.New().String())
 	if len(auds) > linux.ABSTRACT_UNIX_SOCK_LEN-1 {
 		auds = auds[:linux.ABSTRACT_UNIX_SOCK_LEN-1]
@@ -74,6 +124,60 @@ func rsyncSendSetup(ctx context.Context, path string, rsyncArgs string, migratio
 
 	rsyncCmd := fmt.Sprintf("sh -c \"%s netcat %s\"", execPath, auds)
 
+	args := rsyncSendArgs(path, transferArgs, migrationType)
+	args = append(args, []string{"-e", rsyncCmd}...)
+
+	cmd, err := rsyncCommand(ctx, args, transferArgs)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	cmd.Stdout = stdout
+
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	conn, err := l.Accept()
+	if err != nil {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		return nil, nil, nil, err
+	}
+
+	_ = l.Close()
+
+	return cmd, conn, stderr, nil
+}
+
+// rsyncSendArgs returns the rsync arguments sending the path to the server. The directory itself
+// is sent, not only its content, so the server finds the files below its name (rootfs).
+func rsyncSendArgs(path string, transferArgs transferArgs, migrationType MigrationType) []string {
+	args := rsyncArgs(transferArgs, migrationType)
+
+	// The source directory is part of the transferred paths, so patterns get anchored below it.
+	for _, exclude := range transferArgs.Excludes {
+		args = append(args, "--exclude", "/"+filepath.Base(path)+exclude)
+	}
+
+	return append(args, path, "localhost:/tmp/foo")
+}
+
+// rsyncArgs returns the rsync options for a transfer, leaving out the excluded paths and the
+// source and destination which depend on how the data gets sent.
+func rsyncArgs(transferArgs transferArgs, migrationType MigrationType) []string {
+	// Ownership is kept by number, the users and groups of the source may not exist on the server
+	// and the files of containers get shifted by number anyway.
+	//
+	// Partially transferred files are kept so that a resumed transfer (see --resume) picks up where it
+	// stopped. Options like --append-verify can't be used as they also need to be set on the receiving
+	// side, which the server runs with its own options.
 	args := []string{
 		"-ar",
 		"--devices",
@@ -83,13 +187,25 @@ func rsyncSendSetup(ctx context.Context, path string, rsyncArgs string, migratio
 	}
 
 	if migrationType == MigrationTypeContainer || migrationType == MigrationTypeVolumeFilesystem {
-		args = append(args, "--xattrs", "--delete", "--compress", "--compress-level=2")
+		args = append(args, "--xattrs", "--delete")
+
+		if transferArgs.Compression != "none" {
+			level := 2
+			if transferArgs.CompressionLevel > 0 {
+				level = transferArgs.CompressionLevel
+			}
+
+			args = append(args, "--compress", fmt.Sprintf("--compress-level=%d", level))
+		}
 	}
 
 	if migrationType == MigrationTypeVM || migrationType == MigrationTypeVolumeBlock {
 		args = append(args, "--exclude", "*.img")
 	}
 
+	// Snapshots left behind by an interrupted migration (see snapshotBtrfs).
+	args = append(args, "--exclude", snapshotPrefix+"*")
+
 	if rsync.AtLeast("3.1.3") {
 		args = append(args, "--filter=-x security.selinux")
 	}
@@ -98,36 +214,399 @@ func rsyncSendSetup(ctx context.Context, path string, rsyncArgs string, migratio
 		args = append(args, "--ignore-missing-args")
 	}
 
-	if rsyncArgs != "" {
-		args = append(args, strings.Split(rsyncArgs, " ")...)
+	// The file names go to the same output as the transfer errors, next to the progress.
+	if transferArgs.Verbose {
+		args = append(args, "--verbose")
 	}
 
-	args = append(args, []string{path, "localhost:/tmp/foo"}...)
-	args = append(args, []string{"-e", rsyncCmd}...)
+	if transferArgs.Checksum {
+		args = append(args, "--checksum", "--itemize-changes")
+	}
+
+	// rsync takes the limit in units of 1024 bytes per second, where 0 means unlimited.
+	if transferArgs.BandwidthLimit > 0 {
+		args = append(args, fmt.Sprintf("--bwlimit=%d", max(1, (transferArgs.BandwidthLimit+1023)/1024)))
+	}
+
+	if transferArgs.StallTimeout > 0 {
+		args = append(args, fmt.Sprintf("--timeout=%d", int(math.Ceil(transferArgs.StallTimeout.Seconds()))))
+	}
+
+	if transferArgs.RsyncArgs != "" {
+		args = append(args, strings.Split(transferArgs.RsyncArgs, " ")...)
+	}
+
+	return args
+}
 
+// rsyncCommand returns the rsync command for the arguments, run with a lower IO priority when
+// requested.
+func rsyncCommand(ctx context.Context, args []string, transferArgs transferArgs) (*exec.Cmd, error) {
 	cmd := exec.CommandContext(ctx, "rsync", args...)
-	cmd.Stdout = os.Stderr
 
-	stderr, err := cmd.StderrPipe()
+	// Run rsync with a lower IO priority.
+	if transferArgs.IOPriority != "" {
+		cmdArgs, err := ioniceArgs(transferArgs.IOPriority)
+		if err != nil {
+			return nil, err
+		}
+
+		cmdArgs = append(cmdArgs, "rsync")
+		cmd = exec.CommandContext(ctx, "ionice", append(cmdArgs, args...)...)
+	}
+
+	// The algorithm is negotiated with the rsync of the server, forcing it with --compress-choice
+	// would need the same option on the receiving side.
+	if transferArgs.Compression != "" && transferArgs.Compression != "none" {
+		cmd.Env = append(os.Environ(), "RSYNC_COMPRESS_LIST="+transferArgs.Compression)
+	}
+
+	return cmd, nil
+}
+
+// rsyncSSHDir is the default location of the data of the target server, holding the storage pools.
+// Servers using another one need the directory of the container to be set with --ssh-target-path.
+const rsyncSSHDir = "/var/lib/incus"
+
+// SSH host key policies of --rsync-ssh.
+const (
+	sshHostKeyStrict    = "strict"
+	sshHostKeyAcceptNew = "accept-new"
+	sshHostKeyInsecure  = "insecure"
+)
+
+// rsyncSSHPath returns the directory holding the root filesystem of a container on the target
+// server, which is only mounted while stopped by storage pools like dir and btrfs.
+func rsyncSSHPath(server incus.InstanceServer, name string) (string, error) {
+	inst, _, err := server.GetInstance(name)
 	if err != nil {
-		return nil, nil, nil, err
+		return "", err
 	}
 
-	err = cmd.Start()
+	var rootPool string
+	for _, device := range inst.ExpandedDevices {
+		if device["type"] == "disk" && device["path"] == "/" && device["source"] == "" {
+			rootPool = device["pool"]
+			break
+		}
+	}
+
+	if rootPool == "" {
+		return "", fmt.Errorf("No root disk device found for instance %q", name)
+	}
+
+	pool, _, err := server.GetStoragePool(rootPool)
 	if err != nil {
-		return nil, nil, nil, err
+		return "", err
 	}
 
-	conn, err := l.Accept()
+	if !slices.Contains([]string{"dir", "btrfs"}, pool.Driver) {
+		return "", fmt.Errorf("The files of containers can't be sent over SSH to storage pools using the %q driver, only dir and btrfs pools keep the volumes of stopped instances mounted", pool.Driver)
+	}
+
+	volumeName := inst.Name
+	if inst.Project != "" && inst.Project != api.ProjectDefaultName {
+		volumeName = inst.Project + "_" + inst.Name
+	}
+
+	return filepath.Join(rsyncSSHDir, "storage-pools", pool.Name, "containers", volumeName, "rootfs"), nil
+}
+
+// rsyncSSHSend sends the content of a container root filesystem straight to its directory on the
+// target server with rsync over SSH, rather than through the API (see --rsync-ssh). The directory
+// is either found with rsyncSSHPath or set with --ssh-target-path, and must be empty unless
+// resuming.
+func rsyncSSHSend(ctx context.Context, path string, host string, remotePath string, sshArgs []string, resume bool, transferArgs transferArgs) error {
+	// Missing directories would otherwise get created, outside of the storage of the instance.
+	out, err := subprocess.RunCommandContext(ctx, "ssh", append(sshArgs, host, "test", "-d", shellQuote(remotePath), "&&", "find", shellQuote(remotePath), "-mindepth", "1", "-maxdepth", "1", "-print", "-quit")...)
+	if err != nil {
+		return fmt.Errorf("The directory %q of the container doesn't exist on %q (set --ssh-target-path when the target server doesn't store its data in %q): %w", remotePath, host, rsyncSSHDir, err)
+	}
+
+	if !resume && strings.TrimSpace(out) != "" {
+		return fmt.Errorf("The directory %q of the new container on %q isn't empty", remotePath, host)
+	}
+
+	return rsyncSSHRun(ctx, path, host, remotePath, sshArgs, transferArgs, os.Stderr)
+}
+
+// rsyncSSHChecksumPass sends again the files of a container sent by rsyncSSHSend which differ from
+// the source, comparing their checksums (see --final-checksum-pass). This needs rsync on both
+// ends, the receiving rsync of the server can't be asked to compare checksums. It returns the
+// number of files sent again.
+func rsyncSSHChecksumPass(ctx context.Context, path string, host string, remotePath string, sshArgs []string, transferArgs transferArgs) (int, error) {
+	transferArgs.Checksum = true
+
+	var out strings.Builder
+
+	err := rsyncSSHRun(ctx, path, host, remotePath, sshArgs, transferArgs, &out)
+	if err != nil {
+		return 0, err
+	}
+
+	return countItemizedFiles(out.String()), nil
+}
+
+// rsyncSSHRun runs rsync over SSH to the directory of the container, printing its output to stdout.
+func rsyncSSHRun(ctx context.Context, path string, host string, remotePath string, sshArgs []string, transferArgs transferArgs, stdout io.Writer) error {
+	// rsync splits the remote shell command on spaces, preserving quoted arguments.
+	sshCmd := "ssh"
+	for _, arg := range sshArgs {
+		sshCmd += " " + shellQuote(arg)
+	}
+
+	args := rsyncArgs(transferArgs, MigrationTypeContainer)
+
+	// The content of the source directory is transferred, so patterns are anchored at its root.
+	for _, exclude := range transferArgs.Excludes {
+		args = append(args, "--exclude", exclude)
+	}
+
+	args = append(args, "-e", sshCmd, internalUtil.AddSlash(path), host+":"+internalUtil.AddSlash(remotePath))
+
+	cmd, err := rsyncCommand(ctx, args, transferArgs)
+	if err != nil {
+		return err
+	}
+
+	var stderr strings.Builder
+
+	cmd.Stdout = stdout
+	cmd.Stderr = &stderr
+
+	err = cmd.Run()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if transferArgs.StallTimeout > 0 && errors.As(err, &exitErr) && exitErr.ExitCode() == rsyncExitTimeout {
+			return fmt.Errorf("%w: no data was exchanged for %s (see --stall-timeout)\n%s", errTransferStalled, transferArgs.StallTimeout, stderr.String())
+		}
+
+		return fmt.Errorf("Failed to rsync: %v\n%s", err, stderr.String())
+	}
+
+	return nil
+}
+
+// countItemizedFiles returns the number of regular files sent according to the output of rsync
+// --itemize-changes, where those lines start with "<f". Attribute only changes and deletions
+// aren't counted.
+func countItemizedFiles(output string) int {
+	var count int
+	for _, line := range strings.Split(output, "\n") {
+		if strings.HasPrefix(line, "<f") {
+			count++
+		}
+	}
+
+	return count
+}
+
+// rsyncSSHArgs returns the arguments of ssh for --rsync-ssh, for the identity, known hosts file
+// and host key policy set on the command line.
+func rsyncSSHArgs(identity string, knownHosts string, hostKeyPolicy string) []string {
+	args := []string{}
+	if identity != "" {
+		args = append(args, "-i", identity)
+	}
+
+	switch hostKeyPolicy {
+	case sshHostKeyAcceptNew:
+		args = append(args, "-o", "StrictHostKeyChecking=accept-new")
+	case sshHostKeyInsecure:
+		return append(args, "-o", "StrictHostKeyChecking=no", "-o", "UserKnownHostsFile=/dev/null")
+	default:
+		args = append(args, "-o", "StrictHostKeyChecking=yes")
+	}
+
+	if knownHosts != "" {
+		args = append(args, "-o", "UserKnownHostsFile="+knownHosts)
+	}
+
+	return args
+}
+
+// reportSSHHostKey prints the fingerprints of the host keys of an SSH server which isn't in the
+// known hosts file yet, before they get recorded on the first connection. This is best effort,
+// nothing gets printed when the keys can't be retrieved.
+func reportSSHHostKey(out io.Writer, destination string, knownHosts string) {
+	_, host, found := strings.Cut(destination, "@")
+	if !found {
+		host = destination
+	}
+
+	args := []string{"-F", host}
+	if knownHosts != "" {
+		args = append(args, "-f", knownHosts)
+	}
+
+	_, err := subprocess.RunCommand("ssh-keygen", args...)
+	if err == nil {
+		return
+	}
+
+	keys, err := subprocess.RunCommand("ssh-keyscan", "-q", host)
+	if err != nil || keys == "" {
+		return
+	}
+
+	var fingerprints strings.Builder
+
+	err = subprocess.RunCommandWithFds(context.Background(), strings.NewReader(keys), &fingerprints, "ssh-keygen", "-l", "-f", "-")
+	if err != nil {
+		return
+	}
+
+	fmt.Fprintf(out, "The host key of %q isn't known yet, recording it:\n%s", host, fingerprints.String())
+}
+
+// shellQuote quotes a value for a shell, like the one running the commands passed to ssh.
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// btrfsSubvolume is a subvolume sent to the target, as listed in the btrfs migration header.
+type btrfsSubvolume struct {
+	// Path of the subvolume in the volume, / being the volume itself.
+	Path string `json:"path"`
+
+	// Name of the snapshot of the volume the subvolume belongs to, empty for the volume itself.
+	Snapshot string `json:"snapshot"`
+
+	// Whether the subvolume is read-only once received.
+	Readonly bool `json:"readonly"`
+}
+
+// btrfsSendVolume sends a container volume to the target as btrfs send streams: the migration
+// header listing the subvolumes, then the empty volume and the rootfs nested in it.
+func btrfsSendVolume(ctx context.Context, conn *websocket.Conn, transferArgs transferArgs) error {
+	header := struct {
+		Subvolumes []btrfsSubvolume `json:"subvolumes"`
+	}{
+		Subvolumes: []btrfsSubvolume{{Path: "/"}, {Path: "/rootfs"}},
+	}
+
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+
+	wrapper := ws.NewWrapper(conn)
+
+	_, err = wrapper.Write(headerJSON)
+	if err != nil {
+		return fmt.Errorf("Failed sending the btrfs migration header: %w", err)
+	}
+
+	// End the frame.
+	err = wrapper.Close()
+	if err != nil {
+		return err
+	}
+
+	err = btrfsSend(ctx, conn, transferArgs.BtrfsVolume, transferArgs)
 	if err != nil {
+		return err
+	}
+
+	return btrfsSend(ctx, conn, transferArgs.BtrfsRootfs, transferArgs)
+}
+
+// btrfsSend sends a read-only btrfs subvolume to the target as a btrfs send stream.
+func btrfsSend(ctx context.Context, conn *websocket.Conn, subvolume string, transferArgs transferArgs) error {
+	var stderr strings.Builder
+
+	cmd := exec.CommandContext(ctx, "btrfs", "send", subvolume)
+	cmd.Stderr = &stderr
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		return err
+	}
+
+	var reader io.Reader = stdout
+	if transferArgs.BytesSent != nil {
+		reader = &countingReader{Reader: stdout, count: transferArgs.BytesSent}
+	}
+
+	wrapper := ws.NewWrapper(conn)
+
+	_, copyErr := io.Copy(wrapper, reader)
+	if copyErr != nil {
 		_ = cmd.Process.Kill()
-		_ = cmd.Wait()
-		return nil, nil, nil, err
 	}
 
-	_ = l.Close()
+	err = cmd.Wait()
+	if err != nil {
+		return fmt.Errorf("Failed to btrfs send: %v\n%s", err, stderr.String())
+	}
 
-	return cmd, conn, stderr, nil
+	if copyErr != nil {
+		return fmt.Errorf("Failed to btrfs send: %w", copyErr)
+	}
+
+	// Closing the wrapper tells the target that the stream is over.
+	return wrapper.Close()
+}
+
+// ioniceArgs returns the ionice arguments for the provided IO priority ("idle" or a best-effort level).
+func ioniceArgs(priority string) ([]string, error) {
+	if priority == "idle" {
+		return []string{"-c", "3"}, nil
+	}
+
+	level, err := strconv.Atoi(priority)
+	if err != nil || level < 0 || level > 7 {
+		return nil, fmt.Errorf("Invalid IO priority %q (must be \"idle\" or between 0 and 7)", priority)
+	}
+
+	return []string{"-c", "2", "-n", priority}, nil
+}
+
+// parseCompression parses a compression setting, an algorithm optionally followed by a level
+// like "zstd:3" or "none".
+func parseCompression(value string) (string, int, error) {
+	algorithm, levelStr, hasLevel := strings.Cut(value, ":")
+	if algorithm == "" {
+		return "", 0, fmt.Errorf("Invalid compression %q (must be an algorithm like zstd, optionally followed by a level like zstd:3)", value)
+	}
+
+	if !hasLevel {
+		return algorithm, 0, nil
+	}
+
+	if algorithm == "none" {
+		return "", 0, errors.New("No compression level can be set along with none")
+	}
+
+	level, err := strconv.Atoi(levelStr)
+	if err != nil || level < 1 || level > 22 {
+		return "", 0, fmt.Errorf("Invalid compression level %q (must be between 1 and 22)", levelStr)
+	}
+
+	return algorithm, level, nil
+}
+
+// rsyncCompressAlgorithms returns the compression algorithms supported by the local rsync, only
+// zlib being available before rsync 3.2.0.
+func rsyncCompressAlgorithms() []string {
+	out, err := subprocess.RunCommand("rsync", "--version")
+	if err != nil {
+		return []string{"zlib", "none"}
+	}
+
+	lines := strings.Split(out, "\n")
+	for i, line := range lines {
+		if strings.TrimSpace(line) == "Compress list:" && i+1 < len(lines) {
+			return strings.Fields(lines[i+1])
+		}
+	}
+
+	return []string{"zlib", "none"}
 }
 
 func protoSendError(conn *websocket.Conn, err error) {
@@ -139,3 +618,60 @@ func protoSendError(conn *websocket.Conn, err error) {
 		_ = conn.Close()
 	}
 }
+
+// sendInstanceSSH creates an empty instance and sends the files straight to its volume over SSH
+// (see --rsync-ssh). An interrupted migration leaves the instance behind.
+func (c *cmdMigrate) sendInstanceSSH(ctx context.Context, server incus.InstanceServer, reverter *revert.Reverter, config *cmdMigrateData, path string, transferArgs transferArgs) error {
+	if !config.Resume {
+		args := config.InstanceArgs
+		args.Config = maps.Clone(args.Config)
+		args.Config[migratePartialKey] = "true"
+		args.Source = api.InstanceSource{Type: "none"}
+
+		op, err := server.CreateInstance(args)
+		if err != nil {
+			return err
+		}
+
+		err = op.Wait()
+		if err != nil {
+			return err
+		}
+	}
+
+	reverter.Add(func() {
+		_, _ = server.DeleteInstance(config.InstanceArgs.Name)
+	})
+
+	remotePath := c.flagSSHTargetPath
+	if remotePath == "" {
+		var err error
+
+		remotePath, err = rsyncSSHPath(server, config.InstanceArgs.Name)
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(c.out, "Transferring instance over SSH to %q on %q\n", remotePath, c.flagRsyncSSH)
+
+	sshArgs := rsyncSSHArgs(c.flagSSHIdentity, c.flagSSHKnownHosts, c.flagSSHHostKeyPolicy)
+
+	if c.flagSSHHostKeyPolicy == sshHostKeyAcceptNew {
+		reportSSHHostKey(c.out, c.flagRsyncSSH, c.flagSSHKnownHosts)
+	}
+
+	err := rsyncSSHSend(ctx, path, c.flagRsyncSSH, remotePath, sshArgs, config.Resume, transferArgs)
+	if err != nil || !c.flagFinalChecksumPass {
+		return err
+	}
+
+	corrected, err := rsyncSSHChecksumPass(ctx, path, c.flagRsyncSSH, remotePath, sshArgs, transferArgs)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(c.out, "Final checksum pass corrected %d files\n", corrected)
+
+	return nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCountItemizedFiles(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   int
+	}{
+		{
+			name:   "Nothing sent",
+			output: "",
+			want:   0,
+		},
+		{
+			name:   "Files sent",
+			output: "<fcs....... etc/hostname\n<f+++++++++ etc/new\n",
+			want:   2,
+		},
+		{
+			name:   "Directories, links, attributes and deletions",
+			output: "cd+++++++++ var/cache/\ncL+++++++++ bin -> usr/bin\n.f...p..... etc/shadow\n*deleting   tmp/old\n<fc........ usr/bin/ls\n",
+			want:   1,
+		},
+		{
+			name:   "Verbose summary",
+			output: "sending incremental file list\n<f..t...... etc/motd\n\nsent 1,234 bytes  received 56 bytes\ntotal size is 9,876  speedup is 7.65\n",
+			want:   1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, countItemizedFiles(tt.output))
+		})
+	}
+}
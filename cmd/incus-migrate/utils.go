@@ -3,25 +3,30 @@ package main
 import (
 	"bufio"
 	"context"
-	"crypto/x509"
 	"encoding/pem"
 	"errors"
 	"fmt"
 	"io"
 	"net/url"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"reflect"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"golang.org/x/sys/unix"
 
 	incus "github.com/lxc/incus/v6/client"
+	"github.com/lxc/incus/v6/internal/linux"
 	"github.com/lxc/incus/v6/internal/migration"
 	"github.com/lxc/incus/v6/internal/ports"
 	internalUtil "github.com/lxc/incus/v6/internal/util"
 	"github.com/lxc/incus/v6/internal/version"
 	"github.com/lxc/incus/v6/shared/api"
+	"github.com/lxc/incus/v6/shared/subprocess"
 	localtls "github.com/lxc/incus/v6/shared/tls"
 	"github.com/lxc/incus/v6/shared/ws"
 )
@@ -41,7 +46,55 @@ const MigrationTypeVolumeFilesystem = MigrationType("volume-filesystem")
 // MigrationTypeVolumeBlock defines the migration type value for a custom volume of type block.
 const MigrationTypeVolumeBlock = MigrationType("volume-block")
 
-func transferRootfs(ctx context.Context, op incus.Operation, rootfs string, rsyncArgs string, migrationType MigrationType) error {
+// transferArgs represents the options used when transferring the source to the target.
+type transferArgs struct {
+	// Extra arguments to pass to rsync.
+	RsyncArgs string
+
+	// IO priority for rsync, either "idle" or a best-effort level (0-7).
+	IOPriority string
+
+	// Called with the number of bytes sent to the target (optional).
+	BytesSent func(int64)
+
+	// Abort file transfers after that long without any IO (rsync --timeout).
+	StallTimeout time.Duration
+
+	// Whether to list the transferred files as they go (rsync --verbose).
+	Verbose bool
+
+	// Whether to compare files by checksum rather than by size and modification time, listing the
+	// ones sent (rsync --checksum --itemize-changes). Only usable when rsync runs on both ends as
+	// the receiving side needs the option too.
+	Checksum bool
+
+	// Where to print the messages about the transfer.
+	Output io.Writer
+
+	// Maximum rate of file transfers in bytes per second (rsync --bwlimit), unlimited when 0.
+	BandwidthLimit int64
+
+	// Paths to leave out of file transfers, as absolute patterns within the source (see validateExclude).
+	Excludes []string
+
+	// Compression algorithm of file transfers ("none" to disable it) and its level, rsync defaults
+	// being used when empty or 0.
+	Compression      string
+	CompressionLevel int
+
+	// Stream to read the block volume from rather than root.img, along with its size as it
+	// can't be found out beforehand (optional).
+	BlockSource string
+	BlockSize   int64
+
+	// Read-only btrfs subvolumes of the volume and of its rootfs, sent as btrfs send streams when the
+	// target storage pool is btrfs too rather than transferring the files with rsync (containers
+	// only, optional).
+	BtrfsVolume string
+	BtrfsRootfs string
+}
+
+func transferRootfs(ctx context.Context, op incus.Operation, rootfs string, args transferArgs, migrationType MigrationType) error {
 	opAPI := op.Get()
 
 	// Connect to the websockets
@@ -50,6 +103,18 @@ func transferRootfs(ctx context.Context, op incus.Operation, rootfs string, rsyn
 		return err
 	}
 
+	// Unblock the exchanges with the server when the context gets cancelled (see --timeout).
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = wsControl.Close()
+		case <-done:
+		}
+	}()
+
 	abort := func(err error) error {
 		protoSendError(wsControl, err)
 		return err
@@ -67,27 +132,48 @@ func transferRootfs(ctx context.Context, op incus.Operation, rootfs string, rsyn
 	if migrationType == MigrationTypeVM || migrationType == MigrationTypeVolumeBlock {
 		fs = migration.MigrationFSType_BLOCK_AND_RSYNC
 		rsyncHasFeature = false
+	} else if args.BtrfsVolume != "" && migrationType == MigrationTypeContainer {
+		// The target falls back to rsync when its storage pool isn't btrfs.
+		fs = migration.MigrationFSType_BTRFS
+		rsyncHasFeature = true
 	} else {
 		fs = migration.MigrationFSType_RSYNC
 		rsyncHasFeature = true
 	}
 
+	// The server runs rsync with or without compression depending on the offered features.
+	rsyncCompress := rsyncHasFeature && args.Compression != "none"
+
 	offerHeader := migration.MigrationHeader{
 		RsyncFeatures: &migration.RsyncFeatures{
 			Xattrs:   &rsyncHasFeature,
 			Delete:   &rsyncHasFeature,
-			Compress: &rsyncHasFeature,
+			Compress: &rsyncCompress,
 		},
 		Fs: &fs,
 	}
 
+	// The subvolumes are listed in a header, rootfs being nested in the volume.
+	if fs == migration.MigrationFSType_BTRFS {
+		btrfsHasFeature := true
+
+		offerHeader.BtrfsFeatures = &migration.BtrfsFeatures{
+			MigrationHeader:  &btrfsHasFeature,
+			HeaderSubvolumes: &btrfsHasFeature,
+		}
+	}
+
 	if migrationType == MigrationTypeVM || migrationType == MigrationTypeVolumeBlock {
-		stat, err := os.Stat(filepath.Join(rootfs, "root.img"))
-		if err != nil {
-			return abort(err)
+		size := args.BlockSize
+		if args.BlockSource == "" {
+			stat, err := os.Stat(filepath.Join(rootfs, "root.img"))
+			if err != nil {
+				return abort(err)
+			}
+
+			size = stat.Size()
 		}
 
-		size := stat.Size()
 		offerHeader.VolumeSize = &size
 		rootfs = internalUtil.AddSlash(rootfs)
 	}
@@ -103,16 +189,30 @@ func transferRootfs(ctx context.Context, op incus.Operation, rootfs string, rsyn
 		return abort(err)
 	}
 
-	rsyncFeaturesOffered := offerHeader.GetRsyncFeaturesSlice()
-	rsyncFeaturesResponse := respHeader.GetRsyncFeaturesSlice()
+	sendStream := fs == migration.MigrationFSType_BTRFS && respHeader.GetFs() == migration.MigrationFSType_BTRFS
+
+	if sendStream {
+		features := respHeader.GetBtrfsFeaturesSlice()
+		if !slices.Contains(features, migration.BTRFSFeatureMigrationHeader) || !slices.Contains(features, migration.BTRFSFeatureSubvolumes) {
+			return abort(errors.New("The target server doesn't support receiving nested btrfs subvolumes"))
+		}
+
+		err = btrfsSendVolume(ctx, wsFs, args)
+		if err != nil {
+			return abort(fmt.Errorf("Failed sending filesystem volume: %w", err))
+		}
+	} else {
+		rsyncFeaturesOffered := offerHeader.GetRsyncFeaturesSlice()
+		rsyncFeaturesResponse := respHeader.GetRsyncFeaturesSlice()
 
-	if !reflect.DeepEqual(rsyncFeaturesOffered, rsyncFeaturesResponse) {
-		return abort(fmt.Errorf("Offered rsync features (%v) differ from those in the migration response (%v)", rsyncFeaturesOffered, rsyncFeaturesResponse))
+		if !reflect.DeepEqual(rsyncFeaturesOffered, rsyncFeaturesResponse) {
+			return abort(fmt.Errorf("Offered rsync features (%v) differ from those in the migration response (%v)", rsyncFeaturesOffered, rsyncFeaturesResponse))
+		}
 	}
 
 	// Send the filesystem
-	if migrationType != MigrationTypeVolumeBlock {
-		err = rsyncSend(ctx, wsFs, rootfs, rsyncArgs, migrationType)
+	if migrationType != MigrationTypeVolumeBlock && !sendStream {
+		err = rsyncSend(ctx, wsFs, rootfs, args, migrationType, os.Stderr)
 		if err != nil {
 			return abort(fmt.Errorf("Failed sending filesystem volume: %w", err))
 		}
@@ -120,7 +220,12 @@ func transferRootfs(ctx context.Context, op incus.Operation, rootfs string, rsyn
 
 	if migrationType == MigrationTypeVM || migrationType == MigrationTypeVolumeBlock {
 		// Send block volume
-		f, err := os.Open(filepath.Join(rootfs, "root.img"))
+		blockSource := filepath.Join(rootfs, "root.img")
+		if args.BlockSource != "" {
+			blockSource = args.BlockSource
+		}
+
+		f, err := os.Open(blockSource)
 		if err != nil {
 			return abort(err)
 		}
@@ -135,11 +240,25 @@ func transferRootfs(ctx context.Context, op incus.Operation, rootfs string, rsyn
 			_ = f.Close()
 		}()
 
-		_, err = io.Copy(conn, f)
+		var reader io.Reader = f
+		if args.BytesSent != nil {
+			reader = &countingReader{Reader: f, count: args.BytesSent}
+		}
+
+		// A stream may hold more or less than the announced size.
+		if args.BlockSource != "" {
+			reader = io.LimitReader(reader, args.BlockSize)
+		}
+
+		sent, err := io.Copy(conn, reader)
 		if err != nil {
 			return abort(fmt.Errorf("Failed sending block volume: %w", err))
 		}
 
+		if args.BlockSource != "" && sent != args.BlockSize {
+			return abort(fmt.Errorf("The stream ended after %d bytes rather than the %d bytes of the disk", sent, args.BlockSize))
+		}
+
 		err = conn.Close()
 		if err != nil {
 			return abort(err)
@@ -168,9 +287,54 @@ func (m *cmdMigrate) connectLocal() (incus.InstanceServer, error) {
 	return incus.ConnectIncusUnix("", &args)
 }
 
-func (m *cmdMigrate) connectTarget(uri string, certPath string, keyPath string, authType string, token string) (incus.InstanceServer, string, error) {
+// getServerCertificate returns the certificate to pin when connecting to the server, in PEM
+// format, or an empty string when the system CA trusts it.
+//
+// A non-empty fingerprint is always checked, even against a server trusted by the system CA.
+// Otherwise, confirm is called with the fingerprint of the certificate, which is rejected
+// when confirm is nil.
+func getServerCertificate(uri string, fingerprint string, confirm func(fingerprint string) error) (string, error) {
 	args := incus.ConnectionArgs{
-		AuthType: authType,
+		UserAgent: fmt.Sprintf("LXC-MIGRATE %s", version.Version),
+	}
+
+	if fingerprint == "" {
+		_, err := incus.ConnectIncus(uri, &args)
+		if err == nil {
+			return "", nil
+		}
+	}
+
+	// The certificate is fetched once, the checked one being the one later pinned.
+	certificate, err := localtls.GetRemoteCertificate(uri, args.UserAgent)
+	if err != nil {
+		return "", fmt.Errorf("Failed to get remote certificate: %w", err)
+	}
+
+	digest := localtls.CertFingerprint(certificate)
+
+	if fingerprint != "" {
+		if digest != fingerprint {
+			return "", fmt.Errorf("Certificate fingerprint of %q doesn't match the expected one (got %s)", uri, digest)
+		}
+	} else if confirm == nil {
+		return "", fmt.Errorf("Certificate of %q isn't trusted by the system and no fingerprint was provided to check it (fingerprint is %s)", uri, digest)
+	} else {
+		err = confirm(digest)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.Raw})), nil
+}
+
+// connectTarget connects and authenticates to the server, serverCert being the certificate
+// returned by getServerCertificate.
+func (m *cmdMigrate) connectTarget(uri string, serverCert string, certPath string, keyPath string, authType string, token string) (incus.InstanceServer, string, error) {
+	args := incus.ConnectionArgs{
+		AuthType:      authType,
+		TLSServerCert: serverCert,
 	}
 
 	clientFingerprint := ""
@@ -195,7 +359,7 @@ func (m *cmdMigrate) connectTarget(uri string, certPath string, keyPath string,
 
 			// When using certificate add tokens, there's no need to show the temporary certificate.
 			if token == "" {
-				fmt.Printf("\nYour temporary certificate is:\n%s\n", string(clientCrt))
+				fmt.Fprintf(m.out, "\nYour temporary certificate is:\n%s\n", string(clientCrt))
 			}
 		} else {
 			var err error
@@ -215,29 +379,11 @@ func (m *cmdMigrate) connectTarget(uri string, certPath string, keyPath string,
 		args.TLSClientKey = string(clientKey)
 	}
 
-	// Attempt to connect using the system CA
+	// Connect using either the system CA or the already checked server certificate.
 	args.UserAgent = fmt.Sprintf("LXC-MIGRATE %s", version.Version)
 	c, err := incus.ConnectIncus(uri, &args)
-
-	var certificate *x509.Certificate
 	if err != nil {
-		// Failed to connect using the system CA, so retrieve the remote certificate
-		certificate, err = localtls.GetRemoteCertificate(uri, args.UserAgent)
-		if err != nil {
-			return nil, "", err
-		}
-	}
-
-	// Handle certificate prompt
-	if certificate != nil {
-		serverCrt := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.Raw})
-		args.TLSServerCert = string(serverCrt)
-
-		// Setup a new connection, this time with the remote certificate
-		c, err = incus.ConnectIncus(uri, &args)
-		if err != nil {
-			return nil, "", err
-		}
+		return nil, "", err
 	}
 
 	// Get server information
@@ -248,11 +394,22 @@ func (m *cmdMigrate) connectTarget(uri string, certPath string, keyPath string,
 
 	// Check if our cert is already trusted
 	if srv.Auth == "trusted" {
-		fmt.Printf("\nRemote server:\n  Hostname: %s\n  Version: %s\n\n", srv.Environment.ServerName, srv.Environment.ServerVersion)
+		fmt.Fprintf(m.out, "\nRemote server:\n  Hostname: %s\n  Version: %s\n\n", srv.Environment.ServerName, srv.Environment.ServerVersion)
 		return c, "", nil
 	}
 
 	if authType == api.AuthenticationMethodTLS {
+		// A provided certificate only needs to be trusted once, later runs reuse it.
+		if token == "" && clientFingerprint == "" {
+			token, err = m.global.asker.AskString("The client certificate isn't trusted by the server, please provide a certificate token: ", "", func(token string) error {
+				_, err := localtls.CertificateTokenDecode(token)
+				return err
+			})
+			if err != nil {
+				return nil, "", err
+			}
+		}
+
 		if token != "" {
 			req := api.CertificatesPost{
 				TrustToken: token,
@@ -263,10 +420,10 @@ func (m *cmdMigrate) connectTarget(uri string, certPath string, keyPath string,
 				return nil, "", fmt.Errorf("Failed to create certificate: %w", err)
 			}
 		} else {
-			fmt.Println("A temporary client certificate was generated, use `incus config trust add` on the target server.")
-			fmt.Println("")
+			fmt.Fprintln(m.out, "A temporary client certificate was generated, use `incus config trust add` on the target server.")
+			fmt.Fprintln(m.out, "")
 
-			fmt.Print("Press ENTER after the certificate was added to the remote server: ")
+			fmt.Fprint(m.out, "Press ENTER after the certificate was added to the remote server: ")
 			_, err = bufio.NewReader(os.Stdin).ReadString('\n')
 			if err != nil {
 				return nil, "", err
@@ -290,23 +447,52 @@ func (m *cmdMigrate) connectTarget(uri string, certPath string, keyPath string,
 		return nil, "", fmt.Errorf("Server doesn't trust us after authentication")
 	}
 
-	fmt.Printf("\nRemote server:\n  Hostname: %s\n  Version: %s\n\n", srv.Environment.ServerName, srv.Environment.ServerVersion)
+	fmt.Fprintf(m.out, "\nRemote server:\n  Hostname: %s\n  Version: %s\n\n", srv.Environment.ServerName, srv.Environment.ServerVersion)
 
 	return c, clientFingerprint, nil
 }
 
-func setupSource(path string, mounts []string) error {
+// setupSource assembles the mount tree at path. When overlayPath is set, each mount is exposed through
+// an overlay whose upper layer lives in overlayPath so that any write is discarded along with it.
+func setupSource(path string, mounts []string, sources map[string]string, overlayPath string) error {
 	prefix := "/"
 	if len(mounts) > 0 {
 		prefix = mounts[0]
 	}
 
 	// Mount everything
-	for _, mount := range mounts {
+	for i, mount := range mounts {
 		target := fmt.Sprintf("%s/%s", path, strings.TrimPrefix(mount, prefix))
 
+		source := mount
+		if sources[mount] != "" {
+			source = sources[mount]
+		}
+
+		if overlayPath != "" {
+			upperDir := filepath.Join(overlayPath, strconv.Itoa(i), "upper")
+			workDir := filepath.Join(overlayPath, strconv.Itoa(i), "work")
+
+			for _, dir := range []string{upperDir, workDir} {
+				err := os.MkdirAll(dir, 0o700)
+				if err != nil {
+					return err
+				}
+			}
+
+			// Escape the characters used as separators in the overlay options.
+			lowerDir := strings.NewReplacer(`\`, `\\`, ",", `\,`, ":", `\:`).Replace(source)
+
+			err := unix.Mount("overlay", target, "overlay", 0, fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", lowerDir, upperDir, workDir))
+			if err != nil {
+				return fmt.Errorf("Failed to mount overlay for %s: %w", mount, err)
+			}
+
+			continue
+		}
+
 		// Mount the path
-		err := unix.Mount(mount, target, "none", unix.MS_BIND, "")
+		err := unix.Mount(source, target, "none", unix.MS_BIND, "")
 		if err != nil {
 			return fmt.Errorf("Failed to mount %s: %w", mount, err)
 		}
@@ -342,3 +528,266 @@ func parseURL(URL string) (string, error) {
 
 	return uri.String(), nil
 }
+
+// checkRoot returns an error if the tool isn't running as root.
+func checkRoot() error {
+	if os.Geteuid() != 0 {
+		return errors.New("This tool must be run as root")
+	}
+
+	return nil
+}
+
+// checkCommand returns an error if the given command can't be found.
+func checkCommand(name string) error {
+	_, err := exec.LookPath(name)
+	if err != nil {
+		return fmt.Errorf("Unable to find required command %q", name)
+	}
+
+	return nil
+}
+
+// setupLoopDevice attaches the source to a read-only loop device starting at the given offset.
+func setupLoopDevice(path string, offset int64) (string, error) {
+	size, err := diskSize(path)
+	if err != nil {
+		return "", err
+	}
+
+	if offset >= size {
+		return "", fmt.Errorf("Offset %d is beyond the end of %q (%d bytes)", offset, path, size)
+	}
+
+	out, err := subprocess.RunCommand("losetup", "--find", "--show", "--read-only", "--offset", strconv.FormatInt(offset, 10), path)
+	if err != nil {
+		return "", fmt.Errorf("Failed to setup loop device for %q: %w", path, err)
+	}
+
+	return strings.TrimSpace(out), nil
+}
+
+// Disk image formats.
+const (
+	imageFormatRaw   = "raw"
+	imageFormatQCOW2 = "qcow2"
+	imageFormatVMDK  = "vmdk"
+	imageFormatVHD   = "vhd"
+	imageFormatVHDX  = "vhdx"
+)
+
+// imageFormats are the disk image formats needing a conversion.
+var imageFormats = []string{imageFormatQCOW2, imageFormatVMDK, imageFormatVHD, imageFormatVHDX}
+
+// imageFormatMagics maps the header signature of the disk image formats needing a conversion to their name.
+var imageFormatMagics = map[string]string{
+	"QFI\xfb":  imageFormatQCOW2,
+	"KDMV":     imageFormatVMDK,
+	"conectix": imageFormatVHD,
+	"vhdxfile": imageFormatVHDX,
+}
+
+// vhdFooterSize is the size of the footer ending every VHD image. Fixed size VHD images are a raw
+// disk followed by that footer, dynamic ones also start with a copy of it.
+const vhdFooterSize = 512
+
+// qemuImgFormats maps the disk image formats whose qemu-img name differs to that name.
+var qemuImgFormats = map[string]string{
+	imageFormatVHD: "vpc",
+}
+
+// detectImageFormat returns the format of a disk source (raw, qcow2, vmdk, vhd or vhdx) along
+// with the evidence the decision was based on.
+//
+// Block devices are always raw. Image files are identified by the signature at the start of
+// their header (or in the footer for fixed size VHD images), the file extension is only reported
+// when it disagrees with the detected format as qemu-img itself ignores it. Anything without a
+// known signature is considered raw.
+func detectImageFormat(path string) (string, string) {
+	if linux.IsBlockdevPath(path) {
+		return imageFormatRaw, "block device"
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return imageFormatRaw, fmt.Sprintf("header unreadable (%v)", err)
+	}
+
+	defer func() { _ = f.Close() }()
+
+	header := make([]byte, 8)
+
+	_, err = io.ReadFull(f, header)
+	if err != nil {
+		return imageFormatRaw, "file too small to hold an image header"
+	}
+
+	format := imageFormatRaw
+	evidence := fmt.Sprintf("no image signature in the header (starts with %q)", header[:4])
+
+	for magic, magicFormat := range imageFormatMagics {
+		if strings.HasPrefix(string(header), magic) {
+			format = magicFormat
+			evidence = fmt.Sprintf("%s signature %q in the header", format, magic)
+		}
+	}
+
+	if format == imageFormatRaw {
+		footer := make([]byte, len("conectix"))
+
+		info, err := f.Stat()
+		if err == nil && info.Size() >= vhdFooterSize {
+			_, err = f.ReadAt(footer, info.Size()-vhdFooterSize)
+			if err == nil && string(footer) == "conectix" {
+				format = imageFormatVHD
+				evidence = fmt.Sprintf("%s signature %q in the footer", format, footer)
+			}
+		}
+	}
+
+	extFormats := map[string]string{".qcow2": imageFormatQCOW2, ".vmdk": imageFormatVMDK, ".vhd": imageFormatVHD, ".vhdx": imageFormatVHDX, ".raw": imageFormatRaw, ".img": imageFormatRaw}
+
+	ext := strings.ToLower(filepath.Ext(path))
+	if extFormats[ext] != "" && extFormats[ext] != format {
+		evidence += fmt.Sprintf(", despite the %s extension", ext)
+	}
+
+	return format, evidence
+}
+
+// qemuImgReservedArgs are the qemu-img convert arguments which can't be overridden, along with the reason.
+var qemuImgReservedArgs = map[string]string{
+	"-f":                  "the source format is set with --source-format",
+	"-O":                  "the output must be a raw image",
+	"-n":                  "the output file is created by incus-migrate",
+	"--image-opts":        "the source is passed as a path",
+	"--target-image-opts": "the output is passed as a path",
+}
+
+// qemuImgValueArgs are the qemu-img convert options taking a value, either as the next argument
+// or, for the short ones, attached to it (like -fraw).
+var qemuImgValueArgs = []string{"--object", "-t", "-T", "-B", "-F", "-f", "-O", "-o", "-l", "-s", "-S", "-r", "-m"}
+
+// qemuImgArgs splits extra qemu-img convert arguments, rejecting those incus-migrate relies on.
+// They're parsed the way qemu-img does (getopt), short options possibly being grouped (like -pW)
+// or having their value attached.
+//
+// As qemu-img concatenates all the images given before the output one, anything which isn't an
+// option or the value of one is rejected too, so that the source and destination can't change.
+func qemuImgArgs(args string) ([]string, error) {
+	fields := strings.Fields(args)
+
+	var valueOf string
+
+	for _, field := range fields {
+		if valueOf != "" {
+			valueOf = ""
+			continue
+		}
+
+		if field == "-" || field == "--" || !strings.HasPrefix(field, "-") {
+			return nil, fmt.Errorf("The qemu-img argument %q isn't an option, the source and destination are set by incus-migrate", field)
+		}
+
+		// Long options, with their value either attached after "=" or as the next argument.
+		if strings.HasPrefix(field, "--") {
+			name, _, hasValue := strings.Cut(field, "=")
+
+			reason, ok := qemuImgReservedArgs[name]
+			if ok {
+				return nil, fmt.Errorf("The qemu-img argument %q can't be overridden (%s)", name, reason)
+			}
+
+			if !hasValue && slices.Contains(qemuImgValueArgs, name) {
+				valueOf = name
+			}
+
+			continue
+		}
+
+		// Short options, the first one taking a value getting the rest of the argument if any.
+		for i, option := range field[1:] {
+			name := "-" + string(option)
+
+			reason, ok := qemuImgReservedArgs[name]
+			if ok {
+				return nil, fmt.Errorf("The qemu-img argument %q can't be overridden (%s)", name, reason)
+			}
+
+			if slices.Contains(qemuImgValueArgs, name) {
+				if i+2 == len(field) {
+					valueOf = name
+				}
+
+				break
+			}
+		}
+	}
+
+	if valueOf != "" {
+		return nil, fmt.Errorf("The qemu-img argument %q is missing its value", valueOf)
+	}
+
+	return fields, nil
+}
+
+// convertImage converts a qcow2, vmdk, vhd or vhdx image to a raw image. The conversion goes to a temporary
+// name first, so that the destination only ever shows up once complete.
+// Extra arguments come after the default ones, so they take precedence over them (like -t or -T).
+func convertImage(sourcePath string, destPath string, format string, extraArgs []string) error {
+	if !slices.Contains(imageFormats, format) {
+		return fmt.Errorf("Unsupported image format %q for %q", format, sourcePath)
+	}
+
+	// Confirm the command is available.
+	err := checkCommand("qemu-img")
+	if err != nil {
+		return err
+	}
+
+	qemuFormat, ok := qemuImgFormats[format]
+	if !ok {
+		qemuFormat = format
+	}
+
+	convCmd := []string{"qemu-img", "convert", "-f", qemuFormat, "-O", "raw"}
+
+	partialPath := destPath + ".partial"
+
+	cmd := []string{
+		"nice", "-n19", // Run with low priority to reduce CPU impact on other processes.
+	}
+
+	cmd = append(cmd, convCmd...)
+	cmd = append(cmd, "-p", "-t", "writeback")
+
+	// Check for Direct I/O support.
+	from, err := os.OpenFile(sourcePath, unix.O_DIRECT|unix.O_RDONLY, 0)
+	if err == nil {
+		cmd = append(cmd, "-T", "none")
+		_ = from.Close()
+	}
+
+	to, err := os.OpenFile(partialPath, unix.O_DIRECT|unix.O_RDONLY, 0)
+	if err == nil {
+		cmd = append(cmd, "-t", "none")
+		_ = to.Close()
+	}
+
+	cmd = append(cmd, extraArgs...)
+	cmd = append(cmd, sourcePath, partialPath)
+
+	err = exec.Command(cmd[0], cmd[1:]...).Run()
+	if err != nil {
+		_ = os.Remove(partialPath)
+		return err
+	}
+
+	err = checkConvertedImage(sourcePath, partialPath)
+	if err != nil {
+		_ = os.Remove(partialPath)
+		return err
+	}
+
+	return os.Rename(partialPath, destPath)
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQemuImgArgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    string
+		want    []string
+		wantErr string
+	}{
+		{
+			name: "No arguments",
+			args: "",
+			want: []string{},
+		},
+		{
+			name: "Grouped flags",
+			args: "-pW",
+			want: []string{"-pW"},
+		},
+		{
+			name: "Attached value",
+			args: "-m8",
+			want: []string{"-m8"},
+		},
+		{
+			name: "Separate value",
+			args: "-m 8 -W",
+			want: []string{"-m", "8", "-W"},
+		},
+		{
+			name: "Grouped flags ending with a value option",
+			args: "-Wm 8",
+			want: []string{"-Wm", "8"},
+		},
+		{
+			name: "Creation options",
+			args: "-o a=b",
+			want: []string{"-o", "a=b"},
+		},
+		{
+			name: "Long option with a separate value",
+			args: "--object x",
+			want: []string{"--object", "x"},
+		},
+		{
+			name: "Long option with an attached value",
+			args: "--object=x -W",
+			want: []string{"--object=x", "-W"},
+		},
+		{
+			name: "Value looking like an option",
+			args: "-o -O",
+			want: []string{"-o", "-O"},
+		},
+		{
+			name:    "Reserved option with an attached value",
+			args:    "-fraw",
+			wantErr: `"-f" can't be overridden`,
+		},
+		{
+			name:    "Reserved option grouped with flags",
+			args:    "-pO raw",
+			wantErr: `"-O" can't be overridden`,
+		},
+		{
+			name:    "Reserved long option",
+			args:    "--image-opts",
+			wantErr: `"--image-opts" can't be overridden`,
+		},
+		{
+			name:    "Trailing short option without its value",
+			args:    "-W -m",
+			wantErr: `"-m" is missing its value`,
+		},
+		{
+			name:    "Trailing long option without its value",
+			args:    "--object",
+			wantErr: `"--object" is missing its value`,
+		},
+		{
+			name:    "Positional argument",
+			args:    "-W disk.img",
+			wantErr: `"disk.img" isn't an option`,
+		},
+		{
+			name:    "Positional argument after a value",
+			args:    "-m 8 8",
+			wantErr: `"8" isn't an option`,
+		},
+		{
+			name:    "End of options",
+			args:    "-- -W",
+			wantErr: `"--" isn't an option`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := qemuImgArgs(tt.args)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestValidateFlags(t *testing.T) {
+	source := t.TempDir()
+
+	tests := []struct {
+		name    string
+		args    []string
+		config  string
+		wantErr string
+	}{
+		{
+			name: "No options",
+			args: []string{},
+		},
+		{
+			name: "Independent options",
+			args: []string{"--as-vm", "--as-vm-dir", source, "--label", "env=prod", "--retries", "3"},
+		},
+		{
+			name:    "Conflicting options",
+			args:    []string{"--export", "source.tar", "--import", "source.tar"},
+			wantErr: "--export can't be used with --import",
+		},
+		{
+			name:    "Conflicting boolean options",
+			args:    []string{"--select-mounts", "--mounts-from-fstab"},
+			wantErr: "--select-mounts can't be used with --mounts-from-fstab",
+		},
+		{
+			name:    "Conflicting value and boolean options",
+			args:    []string{"--as-vm", "--firmware", "bios"},
+			wantErr: "--as-vm can't be used with --firmware",
+		},
+		{
+			name: "Conflicting option set to false",
+			args: []string{"--as-vm=false", "--firmware", "bios"},
+		},
+		{
+			name: "Conflicting option set to an empty value",
+			args: []string{"--as-vm", "--firmware", ""},
+		},
+		{
+			name:    "Target option while exporting",
+			args:    []string{"--export", "source.tar", "--remote", "backup"},
+			wantErr: "--remote can't be used with --export, pass it along with --import instead",
+		},
+		{
+			name:    "Source option while importing",
+			args:    []string{"--import", "source.tar", "--snapshot"},
+			wantErr: "--snapshot can't be used with --import, pass it along with --export instead",
+		},
+		{
+			name:    "Missing dependency",
+			args:    []string{"--client-cert", "client.crt"},
+			wantErr: "--client-cert requires --client-key",
+		},
+		{
+			name:    "Dependency set to false",
+			args:    []string{"--idmap-isolated=false", "--idmap-base", "100000"},
+			wantErr: "--idmap-base requires --idmap-isolated",
+		},
+		{
+			name: "Met dependency",
+			args: []string{"--idmap-isolated", "--idmap-base", "100000"},
+		},
+		{
+			name:    "Dependency on --rsync-ssh",
+			args:    []string{"--ssh-target-path", "/srv/rootfs"},
+			wantErr: "--ssh-target-path requires --rsync-ssh",
+		},
+		{
+			name:    "Checksum pass without --rsync-ssh",
+			args:    []string{"--final-checksum-pass"},
+			wantErr: "--final-checksum-pass requires --rsync-ssh",
+		},
+		{
+			name:    "Firmware from the configuration file",
+			args:    []string{"--as-vm"},
+			config:  "type: virtual-machine\nsource: SOURCE\nname: vm01\nfirmware: uefi\n",
+			wantErr: "--as-vm can't be used with --firmware",
+		},
+		{
+			name:    "Standard input from the configuration file",
+			config:  "type: virtual-machine\nsource: \"-\"\nname: vm01\n",
+			wantErr: "--source-stdin requires --source-size",
+		},
+		{
+			name:   "Standard input from the configuration file with a size",
+			args:   []string{"--source-size", "10GiB"},
+			config: "type: virtual-machine\nsource: \"-\"\nname: vm01\n",
+		},
+		{
+			name:    "Conflict with the configuration file",
+			args:    []string{"--select-mounts"},
+			config:  "type: container\nsource: SOURCE\nname: c01\n",
+			wantErr: "--config can't be used with --select-mounts",
+		},
+		{
+			name:    "Invalid value",
+			args:    []string{"--source-offset", "0"},
+			wantErr: "must be a positive multiple of 512 bytes",
+		},
+		{
+			name:    "Reserved label",
+			args:    []string{"--label", "migrate.source=elsewhere"},
+			wantErr: "the migrate.* keys are reserved",
+		},
+		{
+			name:    "Invalid label key",
+			args:    []string{"--label", "a b=c"},
+			wantErr: "the key must be made of letters, digits, dots, dashes and underscores",
+		},
+		{
+			name:    "Missing label key",
+			args:    []string{"--label", "=c"},
+			wantErr: "Bad KEY=VALUE label",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &cmdMigrate{}
+			cmd := c.command()
+
+			args := tt.args
+			if tt.config != "" {
+				path := filepath.Join(t.TempDir(), "config.yaml")
+				err := os.WriteFile(path, []byte(strings.ReplaceAll(tt.config, "SOURCE", source)), 0o600)
+				assert.NoError(t, err)
+
+				args = append(args, "--config", path)
+			}
+
+			err := cmd.ParseFlags(args)
+			assert.NoError(t, err)
+
+			err = c.validateFlags(cmd)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+
+			assert.NoError(t, err)
+		})
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	incus "github.com/lxc/incus/v6/client"
+)
+
+// verifySampleSize is the number of files compared by --verify.
+const verifySampleSize = 100
+
+// verifyMaxReported is the maximum number of mismatching files listed by --verify.
+const verifyMaxReported = 10
+
+// verificationError is an error reported when the transferred files differ from the source. The
+// transfer did complete, so resuming it wouldn't fix them.
+type verificationError struct {
+	err error
+}
+
+func (e verificationError) Error() string {
+	return e.err.Error()
+}
+
+func (e verificationError) Unwrap() error {
+	return e.err
+}
+
+// verifyContainerFiles compares the checksums of a random sample of the transferred files with
+// those of their copy in the container, going through the file API of the server. The source may
+// be live, so files modified since the transfer started are left out.
+func verifyContainerFiles(out io.Writer, server incus.InstanceServer, name string, rootfs string, excludes []string, since time.Time) error {
+	files, total, changed, err := sampleSourceFiles(rootfs, excludes, since, verifySampleSize)
+	if err != nil {
+		return fmt.Errorf("Failed to list the source files: %w", err)
+	}
+
+	fmt.Fprintf(out, "Verifying the checksums of %d of the %d transferred files\n", len(files), total)
+	if changed > 0 {
+		fmt.Fprintf(out, "Skipped %d files modified on the source during the transfer\n", changed)
+	}
+
+	var mismatches []string
+	for _, file := range files {
+		sourceSum, err := stableFileChecksum(filepath.Join(rootfs, file), since)
+		if err != nil {
+			// The file went away, can't be read or changed in the meantime.
+			continue
+		}
+
+		content, _, err := server.GetInstanceFile(name, file)
+		if err != nil {
+			mismatches = append(mismatches, fmt.Sprintf("%s (%v)", file, err))
+			continue
+		}
+
+		targetSum, err := readerChecksum(content)
+		_ = content.Close()
+		if err != nil {
+			mismatches = append(mismatches, fmt.Sprintf("%s (%v)", file, err))
+			continue
+		}
+
+		if sourceSum != targetSum {
+			mismatches = append(mismatches, file)
+		}
+	}
+
+	if len(mismatches) == 0 {
+		fmt.Fprintln(out, "All the verified files match the source")
+		return nil
+	}
+
+	reported := mismatches[:min(len(mismatches), verifyMaxReported)]
+
+	return verificationError{fmt.Errorf("Verification failed, %d of %d files differ from the source: %s", len(mismatches), len(files), strings.Join(reported, ", "))}
+}
+
+// sampleSourceFiles picks up to count regular files at random in the root filesystem (reservoir
+// sampling), leaving out the excluded ones and those changed since the given time. It returns
+// their path within the root filesystem along with the number of files sampled from and the
+// number of modified ones.
+func sampleSourceFiles(rootfs string, excludes []string, since time.Time, count int) ([]string, int, int, error) {
+	var sample []string
+	var total int
+	var changed int
+
+	err := filepath.WalkDir(rootfs, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			// Unreadable entries won't be transferred either.
+			if errors.Is(err, fs.ErrPermission) {
+				return nil
+			}
+
+			return err
+		}
+
+		relPath, err := filepath.Rel(rootfs, path)
+		if err != nil {
+			return err
+		}
+
+		relPath = string(os.PathSeparator) + relPath
+
+		if isExcluded(relPath, entry.IsDir(), excludes) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+
+		var stat unix.Stat_t
+
+		err = unix.Lstat(path, &stat)
+		if err != nil {
+			return nil
+		}
+
+		if changedSince(&stat, since) {
+			changed++
+			return nil
+		}
+
+		total++
+
+		if len(sample) < count {
+			sample = append(sample, relPath)
+		} else if i := rand.IntN(total); i < count {
+			sample[i] = relPath
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	return sample, total, changed, nil
+}
+
+// isExcluded returns whether a path within the source matches one of the --exclude patterns. As
+// with rsync, patterns ending with a slash only match directories.
+func isExcluded(path string, isDir bool, excludes []string) bool {
+	for _, exclude := range excludes {
+		if strings.HasSuffix(exclude, "/") {
+			if !isDir {
+				continue
+			}
+
+			exclude = strings.TrimRight(exclude, "/")
+		}
+
+		matched, _ := filepath.Match(exclude, path)
+		if matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// stableFileChecksum returns the SHA-256 checksum of a file, failing if it was changed since
+// the given time, including while being read.
+func stableFileChecksum(path string, since time.Time) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+
+	defer func() { _ = f.Close() }()
+
+	sum, err := readerChecksum(f)
+	if err != nil {
+		return "", err
+	}
+
+	var stat unix.Stat_t
+
+	err = unix.Fstat(int(f.Fd()), &stat)
+	if err != nil {
+		return "", err
+	}
+
+	if changedSince(&stat, since) {
+		return "", fmt.Errorf("%q was modified during the transfer", path)
+	}
+
+	return sum, nil
+}
+
+// changedSince returns whether a file was changed since the given time, going by its change time.
+// Unlike the modification time, it can't be set by the tools restoring a tree (tar, rsync, ...), so
+// files dated in the future aren't mistaken for files modified during the transfer.
+func changedSince(stat *unix.Stat_t, since time.Time) bool {
+	return !time.Unix(stat.Ctim.Unix()).Before(since)
+}
+
+// readerChecksum returns the SHA-256 checksum of the content of a reader.
+func readerChecksum(r io.Reader) (string, error) {
+	hash := sha256.New()
+
+	_, err := io.Copy(hash, r)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSampleSourceFiles(t *testing.T) {
+	rootfs := t.TempDir()
+
+	for _, name := range []string{"old", "future", "excluded"} {
+		err := os.WriteFile(filepath.Join(rootfs, name), []byte(name), 0o600)
+		require.NoError(t, err)
+	}
+
+	// A restored tree may carry modification times in the future.
+	future := time.Now().Add(24 * time.Hour)
+	err := os.Chtimes(filepath.Join(rootfs, "future"), future, future)
+	require.NoError(t, err)
+
+	// The change time has a coarser granularity than time.Now on some filesystems.
+	time.Sleep(10 * time.Millisecond)
+	since := time.Now()
+	time.Sleep(10 * time.Millisecond)
+
+	err = os.WriteFile(filepath.Join(rootfs, "modified"), []byte("modified"), 0o600)
+	require.NoError(t, err)
+
+	files, total, changed, err := sampleSourceFiles(rootfs, []string{"/excluded"}, since, 10)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"/old", "/future"}, files)
+	assert.Equal(t, 2, total)
+	assert.Equal(t, 1, changed)
+
+	_, err = stableFileChecksum(filepath.Join(rootfs, "future"), since)
+	assert.NoError(t, err)
+
+	_, err = stableFileChecksum(filepath.Join(rootfs, "modified"), since)
+	assert.ErrorContains(t, err, "was modified during the transfer")
+}
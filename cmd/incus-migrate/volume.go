@@ -0,0 +1,381 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	incus "github.com/lxc/incus/v6/client"
+	cli "github.com/lxc/incus/v6/internal/cmd"
+	"github.com/lxc/incus/v6/shared/api"
+	"github.com/lxc/incus/v6/shared/revert"
+)
+
+func (c *cmdMigrateData) renderCustomVolume() string {
+	data := struct {
+		Name         string `yaml:"Name"`
+		Project      string `yaml:"Project"`
+		Target       string `yaml:"Cluster member,omitempty"`
+		Type         string `yaml:"Type"`
+		Source       string `yaml:"Source"`
+		SourceFormat string `yaml:"Source format,omitempty"`
+		Size         string `yaml:"Size,omitempty"`
+		FSType       string `yaml:"Filesystem,omitempty"`
+	}{
+		c.CustomVolumeArgs.Name,
+		c.Project,
+		c.Target,
+		c.CustomVolumeArgs.ContentType,
+		c.SourcePath,
+		c.SourceFormat,
+		c.CustomVolumeArgs.Config["size"],
+		c.CustomVolumeArgs.Config["block.filesystem"],
+	}
+
+	out, err := yaml.Marshal(&data)
+	if err != nil {
+		return ""
+	}
+
+	return string(out)
+}
+
+func (c *cmdMigrate) gatherCustomVolumeInfo(server incus.InstanceServer, migrationType MigrationType) (cmdMigrateData, error) {
+	var err error
+
+	config := cmdMigrateData{}
+
+	config.CustomVolumeArgs = api.StorageVolumesPost{
+		Type: "custom",
+		Source: api.StorageVolumeSource{
+			Type: "migration",
+			Mode: "push",
+		},
+	}
+
+	if migrationType == MigrationTypeVolumeFilesystem {
+		config.CustomVolumeArgs.ContentType = "filesystem"
+	} else {
+		config.CustomVolumeArgs.ContentType = "block"
+	}
+
+	// Project
+	err = c.askProject(server, &config)
+	if err != nil {
+		return cmdMigrateData{}, err
+	}
+
+	if config.Project != "" {
+		server = c.useProject(server, config.Project)
+	}
+
+	err = checkTargetResources(server, false)
+	if err != nil {
+		return cmdMigrateData{}, err
+	}
+
+	// Cluster member
+	err = c.askTarget(server, &config)
+	if err != nil {
+		return cmdMigrateData{}, err
+	}
+
+	// Pool
+	pools, err := server.GetStoragePools()
+	if err != nil {
+		return cmdMigrateData{}, err
+	}
+
+	poolNames := []string{}
+	for _, p := range pools {
+		poolNames = append(poolNames, p.Name)
+	}
+
+	if c.preseed != nil {
+		if !slices.Contains(poolNames, c.preseed.Pool) {
+			return cmdMigrateData{}, fmt.Errorf("Pool %q doesn't exist", c.preseed.Pool)
+		}
+
+		config.Pool = c.preseed.Pool
+	}
+
+	for config.Pool == "" {
+		poolName, err := c.global.asker.AskString("Name of the pool: ", "", nil)
+		if err != nil {
+			return cmdMigrateData{}, err
+		}
+
+		if !slices.Contains(poolNames, poolName) {
+			fmt.Fprintf(c.out, "Pool %q doesn't exists\n", poolName)
+			continue
+		}
+
+		config.Pool = poolName
+		break
+	}
+
+	// Custom volume name
+	volumes, err := server.GetStoragePoolVolumes(config.Pool)
+	if err != nil {
+		return cmdMigrateData{}, err
+	}
+
+	volumeNames := []string{}
+	for _, v := range volumes {
+		if v.Type != "custom" {
+			continue
+		}
+
+		volumeNames = append(volumeNames, v.Name)
+	}
+
+	if c.preseed != nil {
+		if slices.Contains(volumeNames, c.preseed.Name) {
+			config.Resume, err = c.askResumeVolume(server, config.Pool, c.preseed.Name, config.CustomVolumeArgs.ContentType)
+			if err != nil {
+				return cmdMigrateData{}, err
+			}
+
+			if !config.Resume {
+				return cmdMigrateData{}, fmt.Errorf("Storage volume %q already exists", c.preseed.Name)
+			}
+		}
+
+		config.CustomVolumeArgs.Name = c.preseed.Name
+	}
+
+	for config.CustomVolumeArgs.Name == "" {
+		volumeName, err := c.global.asker.AskString("Name of the new custom volume: ", "", nil)
+		if err != nil {
+			return cmdMigrateData{}, err
+		}
+
+		if slices.Contains(volumeNames, volumeName) {
+			config.Resume, err = c.askResumeVolume(server, config.Pool, volumeName, config.CustomVolumeArgs.ContentType)
+			if err != nil {
+				return cmdMigrateData{}, err
+			}
+
+			if !config.Resume {
+				fmt.Fprintf(c.out, "Storage volume %q already exists\n", volumeName)
+				continue
+			}
+		}
+
+		config.CustomVolumeArgs.Name = volumeName
+		break
+	}
+
+	err = c.askSourcePath(&config, migrationType)
+	if err != nil {
+		return cmdMigrateData{}, err
+	}
+
+	err = c.askContentType(&config)
+	if err != nil {
+		return cmdMigrateData{}, err
+	}
+
+	if config.CustomVolumeArgs.ContentType == "block" {
+		migrationType = MigrationTypeVolumeBlock
+	} else {
+		migrationType = MigrationTypeVolumeFilesystem
+	}
+
+	// Volume size
+	err = c.askVolumeSize(&config)
+	if err != nil {
+		return cmdMigrateData{}, err
+	}
+
+	// Volume filesystem
+	var warnings []string
+
+	if c.flagFSType != "" {
+		if migrationType != MigrationTypeVolumeFilesystem {
+			return cmdMigrateData{}, errors.New("A filesystem type can only be set for containers and filesystem custom volumes")
+		}
+
+		warning, err := fsTypeWarning(server, config.Pool, c.flagFSType)
+		if err != nil {
+			return cmdMigrateData{}, err
+		}
+
+		if warning != "" {
+			warnings = append(warnings, warning)
+		}
+
+		if config.CustomVolumeArgs.Config == nil {
+			config.CustomVolumeArgs.Config = map[string]string{}
+		}
+
+		config.CustomVolumeArgs.Config["block.filesystem"] = c.flagFSType
+	}
+
+	fmt.Fprintln(c.out, "\nCustom volume to be created:")
+
+	scanner := bufio.NewScanner(strings.NewReader(config.renderCustomVolume()))
+	for scanner.Scan() {
+		fmt.Fprintf(c.out, "  %s\n", scanner.Text())
+	}
+
+	if migrationType == MigrationTypeVolumeFilesystem {
+		warnings = append(sourceFilesystemWarnings([]string{config.SourcePath}), warnings...)
+	}
+
+	printWarnings(c.out, warnings)
+
+	if c.preseed != nil {
+		return config, nil
+	}
+
+	shouldMigrate, err := c.global.asker.AskBool("Do you want to continue? [default=yes]: ", "yes")
+	if err != nil {
+		return cmdMigrateData{}, err
+	}
+
+	if !shouldMigrate {
+		return cmdMigrateData{}, nil
+	}
+
+	return config, nil
+}
+
+func (c *cmdMigrate) migrateCustomVolume(ctx context.Context, server incus.InstanceServer, migrationType MigrationType) error {
+	if migrationType != MigrationTypeVolumeBlock && migrationType != MigrationTypeVolumeFilesystem {
+		return fmt.Errorf("Wrong migration type for migrateCustomVolume")
+	}
+
+	if c.flagFinalChecksumPass {
+		return errors.New("The final checksum pass is only supported for containers")
+	}
+
+	if c.flagRsyncSSH != "" {
+		return errors.New("Only the files of containers can be sent over SSH (--rsync-ssh)")
+	}
+
+	if c.flagBtrfsSend {
+		return errors.New("Only containers can be sent as btrfs send streams (--btrfs-send)")
+	}
+
+	if c.flagNetplan != "" {
+		return errors.New("A netplan configuration can only be provided for instances")
+	}
+
+	if len(c.flagAfterCreateConfig) > 0 {
+		return errors.New("Configuration to apply after creation can only be provided for instances")
+	}
+
+	if len(c.flagExclude) > 0 {
+		return errors.New("Paths can only be excluded from containers")
+	}
+
+	if c.flagPostMigrateSnapshot {
+		return errors.New("Only instances can be snapshotted after the migration")
+	}
+
+	err := checkVolumeMigrationSupport(server, migrationType)
+	if err != nil {
+		return err
+	}
+
+	for _, target := range c.additionalTargets {
+		err := checkVolumeMigrationSupport(target.server, migrationType)
+		if err != nil {
+			return fmt.Errorf("Additional target %q: %w", target.url, err)
+		}
+	}
+
+	config, err := c.gatherCustomVolumeInfo(server, migrationType)
+	if err != nil {
+		return err
+	}
+
+	// User decided not to migrate.
+	if config.CustomVolumeArgs.Name == "" {
+		return nil
+	}
+
+	// The content type may have been switched to match the source.
+	if config.CustomVolumeArgs.ContentType == "block" {
+		migrationType = MigrationTypeVolumeBlock
+	} else {
+		migrationType = MigrationTypeVolumeFilesystem
+	}
+
+	err = c.runMigration(ctx, server, &config, migrationType, func(ctx context.Context, server incus.InstanceServer, config *cmdMigrateData, path string, migrationType MigrationType) (err error) {
+		reverter := revert.New()
+		defer func() {
+			if err != nil && c.keepPartial(ctx, config, err) {
+				reverter.Success()
+			}
+
+			reverter.Fail()
+		}()
+
+		// Create the custom volume, or refresh the one left behind by an interrupted migration.
+		args := config.CustomVolumeArgs
+		args.Config = maps.Clone(args.Config)
+		if args.Config == nil {
+			args.Config = map[string]string{}
+		}
+
+		args.Config[migratePartialKey] = "true"
+		args.Source.Refresh = config.Resume
+
+		op, err := server.CreateStoragePoolVolumeFromMigration(config.Pool, args)
+		if err != nil {
+			return err
+		}
+
+		reverter.Add(func() {
+			_ = server.DeleteStoragePoolVolume(config.Pool, "custom", config.CustomVolumeArgs.Name)
+		})
+
+		progress := cli.ProgressRenderer{Format: "Transferring custom volume: %s", Quiet: c.flagFormat == "json"}
+		_, err = op.AddHandler(progress.UpdateOp)
+		if err != nil {
+			progress.Done("")
+			return err
+		}
+
+		if c.progress != nil {
+			_, _ = op.AddHandler(c.progress.updateOp)
+		}
+
+		err = transferRootfs(ctx, op, path, c.sourceTransferArgs(config), migrationType)
+		if err != nil {
+			// The server deletes volumes whose migration failed.
+			_, _, getErr := server.GetStoragePoolVolume(config.Pool, "custom", config.CustomVolumeArgs.Name)
+			if c.flagResume && getErr == nil {
+				fmt.Fprintf(c.out, "The partially transferred custom volume %q was kept, run again with --resume to continue the transfer\n", config.CustomVolumeArgs.Name)
+			}
+
+			return transferError{err}
+		}
+
+		err = clearVolumePartial(server, config.Pool, config.CustomVolumeArgs.Name)
+		if err != nil {
+			progress.Done("")
+			return err
+		}
+
+		progress.Done(fmt.Sprintf("Custom volume %s successfully created", config.CustomVolumeArgs.Name))
+		reverter.Success()
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	c.printResult(server, &config, migrationType)
+
+	return nil
+}